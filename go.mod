@@ -1,9 +1,11 @@
 module github.com/tarantool/tvisor
 
-go 1.15
+go 1.16
 
 require (
 	github.com/magefile/mage v1.11.0
 	github.com/mitchellh/mapstructure v1.4.1
-	github.com/stretchr/testify v1.7.0
+	github.com/pelletier/go-toml/v2 v2.2.2
+	github.com/stretchr/testify v1.9.0
+	gopkg.in/yaml.v3 v3.0.1
 )
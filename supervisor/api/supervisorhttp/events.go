@@ -0,0 +1,87 @@
+package supervisorhttp
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tarantool/tvisor/supervisor/core"
+)
+
+// EventsHandler is used to stream the Instance lifecycle events over HTTP
+// by using Server-Sent Events. The ID of each event is its sequence number.
+//
+// To resume the stream after reconnecting, a client should pass the
+// sequence number of the last received event by using the "Last-Event-ID"
+// header (it is set by browsers automatically) or the "after" query
+// parameter. The events kept in memory with greater sequence numbers will
+// be sent first.
+type EventsHandler struct {
+	sv *core.Supervisor
+}
+
+// NewEventsHandler creates EventsHandler.
+func NewEventsHandler(sv *core.Supervisor) *EventsHandler {
+	return &EventsHandler{sv: sv}
+}
+
+// ServeHTTP handles requests to the event stream.
+func (handler *EventsHandler) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSON(wr, http.StatusMethodNotAllowed, &errorResult{"Only GET is allowed."})
+		return
+	}
+
+	lastID := req.Header.Get("Last-Event-ID")
+	if after := req.URL.Query().Get("after"); after != "" {
+		lastID = after
+	}
+	var after uint64
+	if lastID != "" {
+		var err error
+		if after, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			writeJSON(wr, http.StatusBadRequest,
+				&errorResult{"The last event ID should be a non-negative number."})
+			return
+		}
+	}
+
+	events, subscriber, cancel := handler.sv.SubscribeEvents(after)
+	defer cancel()
+
+	sse, err := newSSEWriter(wr)
+	if err != nil {
+		writeJSON(wr, http.StatusInternalServerError, &errorResult{err.Error()})
+		return
+	}
+	for i := range events {
+		if err := sendEvent(sse, &events[i]); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(sseKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-subscriber:
+			if !ok {
+				return
+			}
+			if err := sendEvent(sse, &event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := sse.keepAlive(); err != nil {
+				return
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// sendEvent sends the lifecycle event.
+func sendEvent(sse *sseWriter, event *core.Event) error {
+	return sse.send(strconv.FormatUint(event.Seq, 10), event.Type, event)
+}
@@ -4,16 +4,26 @@ Supervisorhttp provides the HTTP API for working with Supervisor.
 package supervisorhttp
 
 import (
-	"encoding/json"
-	"log"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/tarantool/tvisor/supervisor/core"
+	"github.com/tarantool/tvisor/supervisor/metrics"
 )
 
+// unknownCommandName is used in the metrics instead of the command name
+// if the command can't be parsed (to not create a lot of series).
+const unknownCommandName = "unknown"
+
 // SupervisorHandler is used to communicate with the Supervisor over HTTP.
 type SupervisorHandler struct {
 	sv *core.Supervisor
+	// requests counts the API requests by the command name and the result.
+	requests *metrics.CounterVec
+	// latencies is the histogram of the API request latencies
+	// by the command name.
+	latencies *metrics.HistogramVec
 }
 
 // statusResult describes the result of the "status" command.
@@ -31,6 +41,11 @@ type listResult struct {
 	Instances map[string]*core.InstanceStatus `json:"instances"`
 }
 
+// rollingRestartResult describes the result of the "rolling_restart" command.
+type rollingRestartResult struct {
+	Restarted []int `json:"restarted"`
+}
+
 // doneResult describes the success of the command
 // execution if there is no return value.
 type doneResult struct {
@@ -42,30 +57,145 @@ type errorResult struct {
 	Err string `json:"err"`
 }
 
+// instanceId returns the ID of the Instance set by the ID or by the key.
+func instanceId(sv *core.Supervisor, param instanceParam) (int, error) {
+	if param.Key == "" {
+		return param.ID, nil
+	}
+	return sv.InstanceId(param.Key)
+}
+
+// newInstanceSpec returns the settings of the Instance
+// started by the "start" command.
+func newInstanceSpec(params *commandParams) *core.InstanceSpec {
+	spec := core.InstanceSpec{
+		Name:          params.Name,
+		KeySuffix:     params.KeySuffix,
+		Env:           params.Env,
+		RestartPolicy: params.RestartPolicy,
+		Log:           params.Log,
+		Readiness:     params.Readiness,
+		Liveness:      params.Liveness,
+		Iproto:        params.Iproto,
+		Rlimits:       params.Rlimits,
+		Cgroup:        params.Cgroup,
+		User:          params.User,
+		Group:         params.Group,
+		Groups:        params.Groups,
+		WorkDir:       params.WorkDir,
+		Umask:         params.Umask,
+		Session:       params.Session,
+		StopSequence:  params.StopSequence,
+		PreStop:       params.PreStop,
+	}
+	if spec.RestartPolicy == "" {
+		if params.Restartable {
+			spec.RestartPolicy = core.RestartAlways
+		} else {
+			spec.RestartPolicy = core.RestartNever
+		}
+	}
+	return &spec
+}
+
 // callCommand invokes the command and returns the execution result.
 func callCommand(cmd *command, sv *core.Supervisor) interface{} {
 	var res interface{}
+	var id int
+	if _, ok := cmdParamsSpec[cmd.Name]["id"]; ok {
+		var err error
+		if id, err = instanceId(sv, cmd.Params.ID); err != nil {
+			return &errorResult{`Can't find the Instance: "` + err.Error() + `"`}
+		}
+	}
 	switch cmd.Name {
 	case "start":
-		id, err := sv.StartInstance(cmd.Params.Name, cmd.Params.Env,
-			cmd.Params.Restartable)
+		spec := newInstanceSpec(&cmd.Params)
+		start := sv.StartInstance
+		if cmd.Params.AllowDuplicate {
+			start = sv.StartDuplicateInstance
+		}
+		id, err := start(spec)
 		if err != nil {
 			return &errorResult{`Can't start an Instance: "` + err.Error() + `"`}
 		}
+		if cmd.Params.WaitReady {
+			err := sv.WaitInstanceReady(id, time.Duration(cmd.Params.ReadyTimeout))
+			if err != nil {
+				// The Instance that isn't ready is useless
+				// for the client, which doesn't know its ID.
+				sv.StopInstance(id, true)
+				return &errorResult{`The Instance isn't ready: "` + err.Error() + `"`}
+			}
+		}
 		res = &startResult{id}
 	case "stop":
-		if err := sv.StopInstance(cmd.Params.ID, cmd.Params.Force); err != nil {
+		if err := sv.StopInstance(id, cmd.Params.Force); err != nil {
 			return &errorResult{`Can't stop the Instace: "` + err.Error() + `"`}
 		}
 		res = &doneResult{true}
 	case "status":
-		status, err := sv.GetInstanceStatus(cmd.Params.ID)
+		status, err := sv.GetInstanceStatus(id)
 		if err != nil {
 			return &errorResult{`Can't get the Instace: "` + err.Error() + `"`}
 		}
 		res = &statusResult{status}
 	case "list":
 		res = &listResult{sv.ListInstances()}
+	case "logs":
+		filter, err := core.NewOutputFilter(cmd.Params.Stream, cmd.Params.Grep, cmd.Params.Regex)
+		if err != nil {
+			return &errorResult{`Invalid filter: "` + err.Error() + `"`}
+		}
+		lines, err := sv.GetInstanceOutput(id, cmd.Params.Lines, filter)
+		if err != nil {
+			return &errorResult{`Can't get the Instance output: "` + err.Error() + `"`}
+		}
+		res = &logsResult{lines}
+	case "restart":
+		if err := sv.RestartInstance(id, cmd.Params.Mode); err != nil {
+			return &errorResult{`Can't restart the Instance: "` + err.Error() + `"`}
+		}
+		res = &doneResult{true}
+	case "rolling_restart":
+		if (cmd.Params.IDs == nil) == (cmd.Params.Pattern == "") {
+			return &errorResult{`Either "ids" or "pattern" should be set.`}
+		}
+		var ids []int
+		for _, param := range cmd.Params.IDs {
+			id, err := instanceId(sv, param)
+			if err != nil {
+				return &errorResult{`Can't find the Instances: "` + err.Error() + `"`}
+			}
+			ids = append(ids, id)
+		}
+		if cmd.Params.IDs == nil {
+			var err error
+			if ids, err = sv.MatchInstances(cmd.Params.Pattern); err != nil {
+				return &errorResult{`Can't find the Instances: "` + err.Error() + `"`}
+			}
+		}
+		restarted, err := sv.RollingRestart(ids, cmd.Params.Mode,
+			time.Duration(cmd.Params.ReadyTimeout))
+		if err != nil {
+			return &errorResult{`The rolling restart has been aborted: "` +
+				err.Error() + `"`}
+		}
+		res = &rollingRestartResult{restarted}
+	case "signal":
+		err := sv.SignalInstance(id, string(cmd.Params.Signal))
+		if err != nil {
+			return &errorResult{`Can't send the signal: "` + err.Error() + `"`}
+		}
+		res = &doneResult{true}
+	case "drift":
+		res = sv.Drift()
+	case "reload_config":
+		report, err := sv.ReloadCfg()
+		if err != nil {
+			return &errorResult{`Can't reload the config: "` + err.Error() + `"`}
+		}
+		res = report
 	}
 
 	return res
@@ -73,11 +203,19 @@ func callCommand(cmd *command, sv *core.Supervisor) interface{} {
 
 // NewSupervisorHandler creates SupervisorHandler.
 func NewSupervisorHandler(sv *core.Supervisor) *SupervisorHandler {
-	return &SupervisorHandler{sv: sv}
+	return &SupervisorHandler{
+		sv: sv,
+		requests: metrics.NewCounterVec("tvisor_api_requests_total",
+			"Number of API requests.", "command_name", "result"),
+		latencies: metrics.NewHistogramVec("tvisor_api_request_duration_seconds",
+			"API request latencies.", nil, "command_name"),
+	}
 }
 
 // ServeHTTP handles requests to the Supervisor.
 func (handler *SupervisorHandler) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+
 	// Parse, check and call the command.
 	var res interface{}
 	var status int
@@ -85,15 +223,35 @@ func (handler *SupervisorHandler) ServeHTTP(wr http.ResponseWriter, req *http.Re
 	if err := parseCommand(req.Body, &cmd); err != nil {
 		status = http.StatusBadRequest
 		res = &errorResult{err.Error()}
+		cmd.Name = unknownCommandName
 	} else {
 		status = http.StatusOK
 		res = callCommand(&cmd, handler.sv)
 	}
 
+	_, failed := res.(*errorResult)
+	handler.observe(cmd.Name, failed, start)
+
 	// Write the result.
-	wr.Header().Set("Content-Type", "application/json")
-	wr.WriteHeader(status)
-	if err := json.NewEncoder(wr).Encode(res); err != nil {
-		log.Printf("An error occurred while encoding the response: \"%v\"\n", err)
+	writeJSON(wr, status, res)
+}
+
+// observe counts the API request and observes its latency.
+// start - the time at which the request has been received.
+func (handler *SupervisorHandler) observe(cmdName string, failed bool, start time.Time) {
+	result := "ok"
+	if failed {
+		result = "error"
+	}
+	handler.requests.Inc(cmdName, result)
+	handler.latencies.Observe(time.Since(start).Seconds(), cmdName)
+}
+
+// writeMetrics writes the metrics of the API requests
+// in the Prometheus text format.
+func (handler *SupervisorHandler) writeMetrics(w io.Writer) error {
+	if err := handler.requests.Write(w); err != nil {
+		return err
 	}
+	return handler.latencies.Write(w)
 }
@@ -0,0 +1,178 @@
+package supervisorhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/tarantool/tvisor/supervisor/core"
+)
+
+// defaultLogsLines is the default number of the last lines of the
+// Instance output returned by the "logs" command and the logs endpoint.
+const defaultLogsLines = 100
+
+// logsResult describes the result of the "logs" command.
+type logsResult struct {
+	Lines []core.OutputLine `json:"lines"`
+}
+
+// LogsHandler is used to read and follow the Instance output over HTTP.
+//
+// Query parameters:
+// id - Instance ID (required if "key" isn't set);
+// key - Instance key;
+// lines - the number of the last lines to return (default: 100);
+// stream - "stdout" / "stderr" (default: both);
+// grep - a substring (or a regular expression) to search for;
+// regex - "true" if "grep" is a regular expression;
+// follow - "true" to stream new lines by using Server-Sent Events.
+type LogsHandler struct {
+	sv *core.Supervisor
+}
+
+// NewLogsHandler creates LogsHandler.
+func NewLogsHandler(sv *core.Supervisor) *LogsHandler {
+	return &LogsHandler{sv: sv}
+}
+
+// logsQuery describes the parsed query parameters of the logs endpoint.
+type logsQuery struct {
+	inst   instanceParam
+	lines  int
+	filter *core.OutputFilter
+	follow bool
+}
+
+// parseLogsQuery parses and checks the query parameters.
+func parseLogsQuery(values url.Values) (*logsQuery, error) {
+	var query logsQuery
+	var err error
+	if query.inst.Key = values.Get("key"); query.inst.Key == "" {
+		if query.inst.ID, err = strconv.Atoi(values.Get("id")); err != nil {
+			return nil, errors.New(`The parameter "id" should be a number.`)
+		}
+	}
+
+	query.lines = defaultLogsLines
+	if lines := values.Get("lines"); lines != "" {
+		if query.lines, err = strconv.Atoi(lines); err != nil {
+			return nil, errors.New(`The parameter "lines" should be a number.`)
+		}
+	}
+
+	var regex bool
+	if regexStr := values.Get("regex"); regexStr != "" {
+		if regex, err = strconv.ParseBool(regexStr); err != nil {
+			return nil, errors.New(`The parameter "regex" should be a boolean.`)
+		}
+	}
+	if followStr := values.Get("follow"); followStr != "" {
+		if query.follow, err = strconv.ParseBool(followStr); err != nil {
+			return nil, errors.New(`The parameter "follow" should be a boolean.`)
+		}
+	}
+
+	query.filter, err = core.NewOutputFilter(values.Get("stream"), values.Get("grep"), regex)
+	if err != nil {
+		return nil, err
+	}
+	return &query, nil
+}
+
+// writeJSON writes the JSON response with the HTTP status.
+func writeJSON(wr http.ResponseWriter, status int, res interface{}) {
+	wr.Header().Set("Content-Type", "application/json")
+	wr.WriteHeader(status)
+	if err := json.NewEncoder(wr).Encode(res); err != nil {
+		log.Printf("An error occurred while encoding the response: \"%v\"\n", err)
+	}
+}
+
+// ServeHTTP handles requests to the Instance output.
+func (handler *LogsHandler) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSON(wr, http.StatusMethodNotAllowed, &errorResult{"Only GET is allowed."})
+		return
+	}
+	query, err := parseLogsQuery(req.URL.Query())
+	if err != nil {
+		writeJSON(wr, http.StatusBadRequest, &errorResult{err.Error()})
+		return
+	}
+
+	id, err := instanceId(handler.sv, query.inst)
+	if err != nil {
+		writeJSON(wr, http.StatusOK, &errorResult{`Can't find the Instance: "` +
+			err.Error() + `"`})
+		return
+	}
+
+	// Subscribe before reading the last lines to not lose new ones.
+	var follower <-chan core.OutputLine
+	if query.follow {
+		var cancel func()
+		follower, cancel, err = handler.sv.FollowInstanceOutput(id)
+		if err != nil {
+			writeJSON(wr, http.StatusOK, &errorResult{`Can't follow the Instance output: "` +
+				err.Error() + `"`})
+			return
+		}
+		defer cancel()
+	}
+
+	lines, err := handler.sv.GetInstanceOutput(id, query.lines, query.filter)
+	if err != nil {
+		writeJSON(wr, http.StatusOK, &errorResult{`Can't get the Instance output: "` +
+			err.Error() + `"`})
+		return
+	}
+	if !query.follow {
+		writeJSON(wr, http.StatusOK, &logsResult{lines})
+		return
+	}
+
+	sse, err := newSSEWriter(wr)
+	if err != nil {
+		writeJSON(wr, http.StatusInternalServerError, &errorResult{err.Error()})
+		return
+	}
+	for i := range lines {
+		if err := sse.send("", "line", &lines[i]); err != nil {
+			return
+		}
+	}
+
+	// The lines received while the last lines were being
+	// read could be returned twice, let's skip them.
+	var lastTime time.Time
+	if len(lines) != 0 {
+		lastTime = lines[len(lines)-1].Time
+	}
+	ticker := time.NewTicker(sseKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case line, ok := <-follower:
+			if !ok {
+				return
+			}
+			if !line.Time.After(lastTime) || !query.filter.Match(&line) {
+				continue
+			}
+			if err := sse.send("", "line", &line); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := sse.keepAlive(); err != nil {
+				return
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package supervisorhttp
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+
+	"github.com/tarantool/tvisor/supervisor/core"
+)
+
+// metricsContentType is the content type of the Prometheus text format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// MetricsHandler exposes the metrics of the Supervisor,
+// the Instances and the API in the Prometheus text format.
+type MetricsHandler struct {
+	sv  *core.Supervisor
+	api *SupervisorHandler
+}
+
+// NewMetricsHandler creates MetricsHandler.
+// api - the handler of the API requests to get their metrics.
+func NewMetricsHandler(sv *core.Supervisor, api *SupervisorHandler) *MetricsHandler {
+	return &MetricsHandler{sv: sv, api: api}
+}
+
+// ServeHTTP handles requests to the metrics.
+func (handler *MetricsHandler) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSON(wr, http.StatusMethodNotAllowed, &errorResult{"Only GET is allowed."})
+		return
+	}
+
+	// Collect all the metrics before writing to
+	// be able to respond with an error.
+	var buf bytes.Buffer
+	if err := handler.sv.WriteMetrics(&buf); err != nil {
+		writeJSON(wr, http.StatusInternalServerError, &errorResult{err.Error()})
+		return
+	}
+	if err := handler.api.writeMetrics(&buf); err != nil {
+		writeJSON(wr, http.StatusInternalServerError, &errorResult{err.Error()})
+		return
+	}
+
+	wr.Header().Set("Content-Type", metricsContentType)
+	wr.WriteHeader(http.StatusOK)
+	if _, err := buf.WriteTo(wr); err != nil {
+		log.Printf("An error occurred while writing the metrics: \"%v\"\n", err)
+	}
+}
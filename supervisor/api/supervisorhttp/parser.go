@@ -5,14 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
+	"github.com/tarantool/tvisor/supervisor/core"
 )
 
 // commandJSON describes the Supervisor command sent using the HTTP API.
 type commandJSON struct {
 	// Name - name of the command.
-	// Now available: start, stop, status, list.
+	// Now available: start, stop, status, list, logs, drift, reload_config.
 	Name string `json:"command_name"`
 	// Params - command parameters.
 	Params map[string]interface{} `json:"params"`
@@ -28,9 +33,28 @@ type paramSpec struct {
 // for all available commands.
 var cmdParamsSpec = map[string]map[string]paramSpec{
 	"start": {
-		"name":        {Required: true},
-		"env":         {Required: false},
-		"restartable": {Required: false, Default: true},
+		"name":            {Required: true},
+		"key_suffix":      {Required: false},
+		"allow_duplicate": {Required: false, Default: false},
+		"env":             {Required: false},
+		"restartable":     {Required: false, Default: true},
+		"restart_policy":  {Required: false},
+		"log":             {Required: false},
+		"readiness":       {Required: false},
+		"liveness":        {Required: false},
+		"iproto":          {Required: false},
+		"rlimits":         {Required: false},
+		"cgroup":          {Required: false},
+		"user":            {Required: false},
+		"group":           {Required: false},
+		"groups":          {Required: false},
+		"work_dir":        {Required: false},
+		"umask":           {Required: false},
+		"session":         {Required: false, Default: false},
+		"stop_sequence":   {Required: false},
+		"pre_stop":        {Required: false},
+		"wait_ready":      {Required: false, Default: false},
+		"ready_timeout":   {Required: false, Default: defaultReadyTimeout},
 	},
 	"stop": {
 		"id":    {Required: true},
@@ -40,25 +64,130 @@ var cmdParamsSpec = map[string]map[string]paramSpec{
 		"id": {Required: true},
 	},
 	"list": {},
+	"logs": {
+		"id":     {Required: true},
+		"lines":  {Required: false, Default: defaultLogsLines},
+		"stream": {Required: false},
+		"grep":   {Required: false},
+		"regex":  {Required: false, Default: false},
+	},
+	"restart": {
+		"id":   {Required: true},
+		"mode": {Required: false, Default: core.RestartModeGraceful},
+	},
+	"rolling_restart": {
+		"ids":           {Required: false},
+		"pattern":       {Required: false},
+		"mode":          {Required: false, Default: core.RestartModeGraceful},
+		"ready_timeout": {Required: false, Default: defaultReadyTimeout},
+	},
+	"signal": {
+		"id":     {Required: true},
+		"signal": {Required: true},
+	},
+	"drift":         {},
+	"reload_config": {},
 }
 
 // commandParams structure contains all the parameters
 // of all commands that can be passed through the HTTP API.
 type commandParams struct {
-	// ID - Instance ID.
-	ID int
+	// ID - Instance ID or key.
+	ID instanceParam
 	// Name - Instance name.
 	Name string
+	// KeySuffix - the suffix of the Instance key.
+	KeySuffix string `mapstructure:"key_suffix"`
+	// AllowDuplicate - start the Instance even if its key is already
+	// used (the ID is appended to the key then).
+	// Default: false.
+	AllowDuplicate bool `mapstructure:"allow_duplicate"`
 	// Env - environment variables for the starting Instance.
 	Env []string
 	// Restartable - the setting is responsible for the
 	// need to restart the Instance on failure.
 	// Default: true.
 	Restartable bool
+	// RestartPolicy - describes when the Instance should be restarted
+	// after termination: "always", "on-failure" or "never".
+	// Overrides Restartable if set.
+	RestartPolicy string `mapstructure:"restart_policy"`
+	// Log - overrides the rotation settings of the Instance log.
+	Log *core.LogSpec
+	// Readiness - describes the check of the Instance readiness.
+	Readiness *core.ProbeSpec
+	// Liveness - describes the periodic check of the Instance health.
+	Liveness *core.ProbeSpec
+	// Iproto - describes the connection to the Instance
+	// used to query "box.info".
+	Iproto *core.IprotoSpec
+	// Rlimits - describes the resource limits of the Instance process.
+	Rlimits *core.RlimitsSpec
+	// Cgroup - describes the limits of the cgroup of the Instance.
+	Cgroup *core.CgroupSpec
+	// User - the name or ID of the user that runs the Instance.
+	User string
+	// Group - the name or ID of the group of the Instance process.
+	Group string
+	// Groups - the supplementary groups of the Instance process.
+	Groups []string
+	// WorkDir - overrides the template of the working
+	// directory of the Instance.
+	WorkDir string `mapstructure:"work_dir"`
+	// Umask - the file mode creation mask of the Instance
+	// process (an octal number).
+	Umask string
+	// Session - run the Instance in its own session.
+	// Default: false.
+	Session bool
+	// StopSequence - the signals sent to the Instance on the stop.
+	StopSequence []core.StopStep `mapstructure:"stop_sequence"`
+	// PreStop - describes the hook run before the Instance is stopped.
+	PreStop *core.PreStopSpec `mapstructure:"pre_stop"`
+	// WaitReady - wait for the Instance to become ready before
+	// responding. If it isn't ready in time, it will be stopped.
+	// Default: false.
+	WaitReady bool `mapstructure:"wait_ready"`
+	// ReadyTimeout - the time to wait for the Instance readiness.
+	// Default: 60s.
+	ReadyTimeout core.Duration `mapstructure:"ready_timeout"`
 	// Force - the setting is responsible for "force" termination
 	// the Instance in case of a graceful termination failure.
 	// Default: true.
 	Force bool
+	// Lines - the number of the last lines of the Instance output.
+	// Default: 100.
+	Lines int
+	// Stream - the name of the output stream: "stdout" or "stderr".
+	// Default: both streams.
+	Stream string
+	// Grep - a substring (or a regular expression) to search for
+	// in the Instance output.
+	Grep string
+	// Regex - "Grep" is a regular expression.
+	// Default: false.
+	Regex bool
+	// Signal - the name or the number of the signal.
+	Signal signalParam
+	// Mode - the restart mode: "graceful" or "force".
+	// Default: "graceful".
+	Mode string
+	// IDs - the IDs (or the keys) of the Instances to restart.
+	IDs []instanceParam
+	// Pattern - the shell pattern of the names
+	// of the Instances to restart.
+	Pattern string
+}
+
+// signalParam is a signal set by the name or the number.
+type signalParam string
+
+// instanceParam refers to an Instance by the ID or by the key.
+type instanceParam struct {
+	// ID - the ID of the Instance (if the Key is empty).
+	ID int
+	// Key - the key of the Instance.
+	Key string
 }
 
 // command describes the Supervisor command
@@ -80,7 +209,12 @@ func parseCommand(r io.Reader, cmd *command) error {
 	if err := decoder.Decode(&cmdJSON); err != nil {
 		return err
 	}
+	return parseCommandJSON(&cmdJSON, cmd)
+}
 
+// parseCommandJSON checks the parameters of the decoded
+// command and parses them to a "command" struct.
+func parseCommandJSON(cmdJSON *commandJSON, cmd *command) error {
 	// Check command name.
 	cmdSpec, ok := cmdParamsSpec[cmdJSON.Name]
 	if !ok {
@@ -88,6 +222,9 @@ func parseCommand(r io.Reader, cmd *command) error {
 	}
 
 	// Check parameters.
+	if cmdJSON.Params == nil {
+		cmdJSON.Params = make(map[string]interface{})
+	}
 	checkedParamsCount := 0
 	for paramName, spec := range cmdSpec {
 		_, ok := cmdJSON.Params[paramName]
@@ -114,11 +251,108 @@ func parseCommand(r io.Reader, cmd *command) error {
 	}
 
 	// Parse cmdJSON to a "command" structure.
-	// Additionally, all types of parameters will be checked.
-	err := mapstructure.Decode(cmdJSON, cmd)
+	// Additionally, all types of parameters will be checked
+	// and unknown nested keys (e.g. in "readiness") are rejected.
+	paramsDecoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(durationHook, limitHook,
+			signalHook, instanceHook),
+		ErrorUnused: true,
+		Result:      cmd,
+	})
+	if err == nil {
+		err = paramsDecoder.Decode(cmdJSON)
+	}
 	if err != nil {
 		err = fmt.Errorf(`Failed to parse command params: "%v"`, err.Error())
 	}
 
 	return err
 }
+
+// defaultReadyTimeout is the default time (in seconds)
+// to wait for the Instance readiness.
+const defaultReadyTimeout = 60.0
+
+// durationHook converts a number of seconds or a duration
+// string (see time.ParseDuration) to core.Duration.
+func durationHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(core.Duration(0)) {
+		return data, nil
+	}
+	switch value := data.(type) {
+	case float64:
+		return core.Duration(value * float64(time.Second)), nil
+	case string:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		return core.Duration(duration), nil
+	}
+	return nil, errors.New("A duration should be a number of seconds or a duration string.")
+}
+
+// signalHook converts the number of a signal to signalParam.
+func signalHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(signalParam("")) {
+		return data, nil
+	}
+	switch value := data.(type) {
+	case float64:
+		return signalParam(strconv.FormatFloat(value, 'f', -1, 64)), nil
+	case string:
+		return signalParam(value), nil
+	}
+	return nil, errors.New("A signal should be a name or a number.")
+}
+
+// instanceHook converts the ID (a number) or the key (a string)
+// of an Instance to instanceParam.
+func instanceHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(instanceParam{}) {
+		return data, nil
+	}
+	switch value := data.(type) {
+	case float64:
+		if value == math.Trunc(value) && value >= math.MinInt32 && value <= math.MaxInt32 {
+			return instanceParam{ID: int(value)}, nil
+		}
+	case string:
+		return instanceParam{Key: value}, nil
+	}
+	return nil, errors.New("An instance should be set by the ID (an integer) or the key (a string).")
+}
+
+// limitTypes is a map of the limit types to the name of the unlimited value.
+var limitTypes = map[reflect.Type]string{
+	reflect.TypeOf(core.RlimitValue(0)): "unlimited",
+	reflect.TypeOf(core.CgroupLimit(0)): "max",
+}
+
+// limitHook converts a number or the name of the unlimited value
+// to a limit (core.RlimitValue or core.CgroupLimit).
+func limitHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	unlimited, ok := limitTypes[to]
+	if !ok {
+		return data, nil
+	}
+	errInvalid := errors.New(`A limit should be a non-negative integer or "` +
+		unlimited + `".`)
+	var jsonData []byte
+	switch value := data.(type) {
+	case float64:
+		if value < 0 || value != math.Trunc(value) || value >= math.MaxUint64 {
+			return nil, errInvalid
+		}
+		jsonData = []byte(strconv.FormatFloat(value, 'f', -1, 64))
+	case string:
+		jsonData = []byte(strconv.Quote(value))
+	default:
+		return nil, errInvalid
+	}
+	limit := reflect.New(to)
+	if err := json.Unmarshal(jsonData, limit.Interface()); err != nil {
+		return nil, errInvalid
+	}
+	return limit.Elem().Interface(), nil
+}
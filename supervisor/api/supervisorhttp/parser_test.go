@@ -3,8 +3,10 @@ package supervisorhttp
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tarantool/tvisor/supervisor/core"
 )
 
 // parse parses the command from JSON into a "command"
@@ -32,6 +34,7 @@ func TestParser(t *testing.T) {
   "command_name": "start",
   "params": {
     "name": "test_inst",
+    "restart_policy": "on-failure",
     "env": [
       "TRYAM=true"
     ]
@@ -45,7 +48,111 @@ func TestParser(t *testing.T) {
 	assert.Equal(cmd.Name, "start")
 	assert.Equal(cmd.Params.Name, "test_inst")
 	assert.Equal(cmd.Params.Restartable, true)
+	assert.Equal(cmd.Params.RestartPolicy, "on-failure")
 	assert.Equal(cmd.Params.Env[0], "TRYAM=true")
+	assert.Equal(cmd.Params.WaitReady, false)
+	assert.Nil(cmd.Params.Readiness)
+	assert.Equal(cmd.Params.AllowDuplicate, false)
+
+	// Start command with a key suffix.
+	parse(t, []byte(`{"command_name": "start", "params": {"name": "storage",
+		"key_suffix": "a-1", "allow_duplicate": true}}`), &cmd)
+	assert.Equal(cmd.Params.KeySuffix, "a-1")
+	assert.Equal(cmd.Params.AllowDuplicate, true)
+
+	// Start command with a readiness probe.
+	jsonStartReady := []byte(`{
+  "command_name": "start",
+  "params": {
+    "name": "test_inst",
+    "readiness": {
+      "type": "exec",
+      "command": ["tarantoolctl", "status", "test_inst"],
+      "interval": 0.5
+    },
+    "liveness": {
+      "type": "iproto",
+      "address": "127.0.0.1:3301",
+      "failure_threshold": 5
+    },
+    "iproto": {
+      "address": "127.0.0.1:3301",
+      "user": "admin",
+      "password": "secret",
+      "interval": 10
+    },
+    "rlimits": {
+      "nofile": {"soft": 65536, "hard": 65536},
+      "core": {"soft": "unlimited"}
+    },
+    "cgroup": {
+      "memory_max": 1073741824,
+      "memory_high": "max",
+      "cpu_max": 1.5,
+      "cpu_weight": 200
+    },
+    "user": "tarantool",
+    "groups": ["adm", "1001"],
+    "work_dir": "/var/lib/tarantool/{name}-{id}",
+    "umask": "0027",
+    "session": true,
+    "stop_sequence": [
+      {"signal": "SIGTERM", "after": 0},
+      {"signal": "SIGINT", "after": 10},
+      {"signal": "SIGKILL", "after": 30}
+    ],
+    "pre_stop": {"type": "iproto", "eval": "box.snapshot()", "timeout": 5},
+    "wait_ready": true,
+    "ready_timeout": 10
+  }
+}
+`)
+
+	cmd = command{}
+	parse(t, jsonStartReady, &cmd)
+	// Check parsing result.
+	assert.Equal(cmd.Params.WaitReady, true)
+	assert.Equal(cmd.Params.ReadyTimeout, core.Duration(10*time.Second))
+	if assert.NotNil(cmd.Params.Readiness) {
+		assert.Equal(cmd.Params.Readiness.Type, "exec")
+		assert.Equal(cmd.Params.Readiness.Command[1], "status")
+		assert.Equal(cmd.Params.Readiness.Interval, core.Duration(500*time.Millisecond))
+	}
+	if assert.NotNil(cmd.Params.Iproto) {
+		assert.Equal(cmd.Params.Iproto.User, "admin")
+		assert.Equal(cmd.Params.Iproto.Interval, core.Duration(10*time.Second))
+	}
+	if assert.NotNil(cmd.Params.Liveness) {
+		assert.Equal(cmd.Params.Liveness.Type, "iproto")
+		assert.Equal(cmd.Params.Liveness.FailureThreshold, 5)
+	}
+	if assert.NotNil(cmd.Params.Rlimits) {
+		assert.Equal(core.RlimitValue(65536), *cmd.Params.Rlimits.Nofile.Hard)
+		assert.Equal(core.RlimitValue(^uint64(0)), *cmd.Params.Rlimits.Core.Soft)
+		assert.Nil(cmd.Params.Rlimits.Core.Hard)
+	}
+	if assert.NotNil(cmd.Params.Cgroup) {
+		assert.Equal(core.CgroupLimit(1<<30), *cmd.Params.Cgroup.MemoryMax)
+		assert.Equal(core.CgroupLimit(^uint64(0)), *cmd.Params.Cgroup.MemoryHigh)
+		assert.Equal(1.5, cmd.Params.Cgroup.CPUMax)
+		assert.Equal(200, cmd.Params.Cgroup.CPUWeight)
+		assert.Nil(cmd.Params.Cgroup.PidsMax)
+	}
+	assert.Equal("tarantool", cmd.Params.User)
+	assert.Equal("", cmd.Params.Group)
+	assert.Equal([]string{"adm", "1001"}, cmd.Params.Groups)
+	assert.Equal("/var/lib/tarantool/{name}-{id}", cmd.Params.WorkDir)
+	assert.Equal("0027", cmd.Params.Umask)
+	assert.True(cmd.Params.Session)
+	if assert.Len(cmd.Params.StopSequence, 3) {
+		assert.Equal(core.StopStep{Signal: "SIGINT", After: core.Duration(10 * time.Second)},
+			cmd.Params.StopSequence[1])
+		assert.Equal(core.Duration(30*time.Second), cmd.Params.StopSequence[2].After)
+	}
+	if assert.NotNil(cmd.Params.PreStop) {
+		assert.Equal("box.snapshot()", cmd.Params.PreStop.Eval)
+		assert.Equal(core.Duration(5*time.Second), cmd.Params.PreStop.Timeout)
+	}
 
 	// Stop command parsing check.
 	jsonStop := []byte(`{
@@ -60,9 +167,13 @@ func TestParser(t *testing.T) {
 	parse(t, jsonStop, &cmd)
 	// Check parsing result.
 	assert.Equal(cmd.Name, "stop")
-	assert.Equal(cmd.Params.ID, 1)
+	assert.Equal(cmd.Params.ID, instanceParam{ID: 1})
 	assert.Equal(cmd.Params.Force, false)
 
+	// The Instance is set by the key.
+	parse(t, []byte(`{"command_name": "stop", "params": {"id": "storage-a-1"}}`), &cmd)
+	assert.Equal(cmd.Params.ID, instanceParam{Key: "storage-a-1"})
+
 	// Status command parsing check.
 	jsonStatus := []byte(`{
   "command_name": "status",
@@ -75,7 +186,7 @@ func TestParser(t *testing.T) {
 	parse(t, jsonStatus, &cmd)
 	// Check parsing result.
 	assert.Equal(cmd.Name, "status")
-	assert.Equal(cmd.Params.ID, 1)
+	assert.Equal(cmd.Params.ID, instanceParam{ID: 1})
 
 	// Status command parsing check.
 	jsonList := []byte(`{
@@ -86,6 +197,74 @@ func TestParser(t *testing.T) {
 	parse(t, jsonList, &cmd)
 	// Check parsing result.
 	assert.Equal(cmd.Name, "list")
+
+	// Drift command parsing check.
+	parse(t, []byte(`{"command_name": "drift"}`), &cmd)
+	assert.Equal(cmd.Name, "drift")
+
+	// Reload config command parsing check.
+	parse(t, []byte(`{"command_name": "reload_config"}`), &cmd)
+	assert.Equal(cmd.Name, "reload_config")
+
+	// Logs command parsing check.
+	jsonLogs := []byte(`{
+  "command_name": "logs",
+  "params": {
+    "id": 1,
+    "stream": "stderr",
+    "grep": "error"
+  }
+}
+`)
+
+	parse(t, jsonLogs, &cmd)
+	// Check parsing result.
+	assert.Equal(cmd.Name, "logs")
+	assert.Equal(cmd.Params.ID, instanceParam{ID: 1})
+	assert.Equal(cmd.Params.Lines, 100)
+	assert.Equal(cmd.Params.Stream, "stderr")
+	assert.Equal(cmd.Params.Grep, "error")
+	assert.Equal(cmd.Params.Regex, false)
+
+	// Signal command parsing check (the name and the number of a signal).
+	parse(t, []byte(`{"command_name": "signal", "params": {"id": 1, "signal": "SIGHUP"}}`), &cmd)
+	assert.Equal(cmd.Name, "signal")
+	assert.Equal(cmd.Params.ID, instanceParam{ID: 1})
+	assert.Equal(string(cmd.Params.Signal), "SIGHUP")
+	parse(t, []byte(`{"command_name": "signal", "params": {"id": 1, "signal": 10}}`), &cmd)
+	assert.Equal(string(cmd.Params.Signal), "10")
+
+	// Restart command parsing check.
+	parse(t, []byte(`{"command_name": "restart", "params": {"id": 1}}`), &cmd)
+	assert.Equal(cmd.Name, "restart")
+	assert.Equal(cmd.Params.ID, instanceParam{ID: 1})
+	assert.Equal(cmd.Params.Mode, core.RestartModeGraceful)
+
+	// Rolling restart command parsing check.
+	jsonRollingRestart := []byte(`{
+  "command_name": "rolling_restart",
+  "params": {
+    "ids": [1, "storage-a-1"],
+    "mode": "force",
+    "ready_timeout": 10
+  }
+}
+`)
+
+	parse(t, jsonRollingRestart, &cmd)
+	// Check parsing result.
+	assert.Equal(cmd.Name, "rolling_restart")
+	assert.Equal(cmd.Params.IDs, []instanceParam{{ID: 1}, {Key: "storage-a-1"}})
+	assert.Equal(cmd.Params.Mode, core.RestartModeForce)
+	assert.Equal(cmd.Params.ReadyTimeout, core.Duration(10*time.Second))
+	parse(t, []byte(`{"command_name": "rolling_restart", "params": {"pattern": "app_*"}}`), &cmd)
+	assert.Equal(cmd.Params.Pattern, "app_*")
+	assert.Equal(cmd.Params.ReadyTimeout, core.Duration(60*time.Second))
+
+	// The durations can be set as duration strings.
+	parse(t, []byte(`{"command_name": "rolling_restart",
+  "params": {"pattern": "app_*", "ready_timeout": "1m30s"}}`), &cmd)
+	assert.Equal(cmd.Params.ReadyTimeout, core.Duration(90*time.Second))
 }
 
 // TestParserNegative tests negative cases of command parsing.
@@ -128,4 +307,35 @@ func TestParserNegative(t *testing.T) {
 }
 `)
 	assertParseFails(t, jsonBadCmd)
+
+	// Check the resource limit validation.
+	jsonBadCmd = []byte(`{
+  "command_name": "start",
+  "params": {
+    "name": "test_inst",
+    "rlimits": {"nofile": {"soft": 1.5}}
+  }
+}
+`)
+	assertParseFails(t, jsonBadCmd)
+
+	// Check that the misspelled nested keys are rejected.
+	assertParseFails(t, []byte(`{"command_name": "start", "params": {"name": "test_inst",
+  "readiness": {"type": "file", "path": "/tmp/ready", "timout": 1}}}`))
+	assertParseFails(t, []byte(`{"command_name": "start", "params": {"name": "test_inst",
+  "stop_sequence": [{"signal": "SIGTERM", "aftr": 1}]}}`))
+
+	// Check the duration validation.
+	assertParseFails(t, []byte(`{"command_name": "rolling_restart",
+  "params": {"pattern": "app_*", "ready_timeout": "90"}}`))
+	assertParseFails(t, []byte(`{"command_name": "rolling_restart",
+  "params": {"pattern": "app_*", "ready_timeout": true}}`))
+
+	// Check the Instance validation.
+	assertParseFails(t, []byte(`{"command_name": "status", "params": {"id": 1.5}}`))
+	assertParseFails(t, []byte(`{"command_name": "status", "params": {"id": true}}`))
+
+	// Check the signal validation.
+	assertParseFails(t, []byte(`{"command_name": "signal", "params": {"id": 1, "signal": true}}`))
+	assertParseFails(t, []byte(`{"command_name": "signal", "params": {"id": 1}}`))
 }
@@ -0,0 +1,233 @@
+package supervisorhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tarantool/tvisor/supervisor/core"
+)
+
+// instancesPath is the path to the collection of the Instances.
+const instancesPath = "/instances"
+
+// InstancesHandler provides the resource-oriented API of the Instances:
+//
+// GET /instances - the list of the Instances (see the "list" command);
+// POST /instances - start an Instance, the body is the parameters of the
+// "start" command;
+// GET /instances/{id} - the status of the Instance;
+// POST /instances/{id}/stop - stop the Instance, the body (optional) is
+// the parameters of the "stop" command except "id";
+// DELETE /instances/{id} - stop the Instance (with the force).
+//
+// {id} is the ID or the key of the Instance. The status of the response
+// describes the result: 404 - unknown Instance, 409 - the request conflicts
+// with the state of the Instance (for example, the key is already used),
+// 422 - invalid parameters.
+type InstancesHandler struct {
+	sv *core.Supervisor
+	// api is used to count the requests together with the commands.
+	api *SupervisorHandler
+}
+
+// NewInstancesHandler creates InstancesHandler.
+// api - the handler of the commands used to count the requests.
+func NewInstancesHandler(sv *core.Supervisor, api *SupervisorHandler) *InstancesHandler {
+	return &InstancesHandler{sv: sv, api: api}
+}
+
+// restError describes a failure of the request with the HTTP status.
+type restError struct {
+	status int
+	msg    string
+}
+
+// ServeHTTP routes the request to the Instances or to an Instance.
+func (handler *InstancesHandler) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	var cmdName string
+	var status int
+	var res interface{}
+	var rerr *restError
+
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, instancesPath), "/")
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+	switch {
+	case len(parts) == 0 && req.Method == http.MethodGet:
+		cmdName, status = "list", http.StatusOK
+		res = &listResult{handler.sv.ListInstances()}
+	case len(parts) == 0 && req.Method == http.MethodPost:
+		cmdName, status = "start", http.StatusCreated
+		res, rerr = handler.start(wr, req)
+	case len(parts) == 0:
+		rerr = methodNotAllowed(wr, http.MethodGet, http.MethodPost)
+	case len(parts) == 1 && req.Method == http.MethodGet:
+		cmdName, status = "status", http.StatusOK
+		res, rerr = handler.status(parts[0])
+	case len(parts) == 1 && req.Method == http.MethodDelete:
+		cmdName, status = "stop", http.StatusNoContent
+		rerr = handler.stop(parts[0], nil)
+	case len(parts) == 1:
+		rerr = methodNotAllowed(wr, http.MethodGet, http.MethodDelete)
+	case len(parts) == 2 && parts[1] == "stop" && req.Method == http.MethodPost:
+		cmdName, status = "stop", http.StatusOK
+		res, rerr = &doneResult{true}, handler.stop(parts[0], req.Body)
+	case len(parts) == 2 && parts[1] == "stop":
+		rerr = methodNotAllowed(wr, http.MethodPost)
+	default:
+		rerr = &restError{http.StatusNotFound, `Unknown path "` + req.URL.Path + `".`}
+	}
+
+	if cmdName != "" {
+		handler.api.observe(cmdName, rerr != nil, start)
+	}
+	if rerr != nil {
+		writeJSON(wr, rerr.status, &errorResult{rerr.msg})
+	} else if status == http.StatusNoContent {
+		wr.WriteHeader(status)
+	} else {
+		writeJSON(wr, status, res)
+	}
+}
+
+// methodNotAllowed returns the error of the request
+// with a method that isn't allowed for the resource.
+func methodNotAllowed(wr http.ResponseWriter, methods ...string) *restError {
+	wr.Header().Set("Allow", strings.Join(methods, ", "))
+	return &restError{http.StatusMethodNotAllowed, "Only " +
+		strings.Join(methods, " and ") + " are allowed."}
+}
+
+// parseBody decodes the JSON object of the body (it can be empty)
+// and parses it as the parameters of the command.
+// params - the parameters that aren't passed in the body.
+func parseBody(body io.Reader, cmdName string, params map[string]interface{},
+	cmd *command) *restError {
+	cmdJSON := commandJSON{Name: cmdName}
+	if body != nil {
+		var bodyJSON interface{}
+		err := json.NewDecoder(body).Decode(&bodyJSON)
+		if err != nil && err != io.EOF {
+			return &restError{http.StatusBadRequest, err.Error()}
+		}
+		if err == nil {
+			object, ok := bodyJSON.(map[string]interface{})
+			if !ok {
+				return &restError{http.StatusBadRequest,
+					"The body should be a JSON object."}
+			}
+			cmdJSON.Params = object
+		}
+	}
+	if cmdJSON.Params == nil {
+		cmdJSON.Params = make(map[string]interface{})
+	}
+	for name, value := range params {
+		cmdJSON.Params[name] = value
+	}
+	if err := parseCommandJSON(&cmdJSON, cmd); err != nil {
+		return &restError{http.StatusUnprocessableEntity, err.Error()}
+	}
+	return nil
+}
+
+// findInstance returns the ID of the Instance by the ID or by the key.
+func (handler *InstancesHandler) findInstance(ref string) (int, *restError) {
+	param := instanceParam{Key: ref}
+	if id, err := strconv.Atoi(ref); err == nil {
+		param = instanceParam{ID: id}
+	}
+	id, err := instanceId(handler.sv, param)
+	if err == nil {
+		_, err = handler.sv.GetInstanceStatus(id)
+	}
+	if err != nil {
+		return 0, &restError{http.StatusNotFound, err.Error()}
+	}
+	return id, nil
+}
+
+// start starts the Instance. The URL of the Instance is
+// returned in the "Location" header.
+func (handler *InstancesHandler) start(wr http.ResponseWriter,
+	req *http.Request) (interface{}, *restError) {
+	var cmd command
+	if rerr := parseBody(req.Body, "start", nil, &cmd); rerr != nil {
+		return nil, rerr
+	}
+
+	start := handler.sv.StartInstance
+	if cmd.Params.AllowDuplicate {
+		start = handler.sv.StartDuplicateInstance
+	}
+	id, err := start(newInstanceSpec(&cmd.Params))
+	if err != nil {
+		var keyUsed *core.KeyUsedError
+		var invalidSpec *core.InvalidSpecError
+		status := http.StatusInternalServerError
+		if errors.As(err, &keyUsed) {
+			status = http.StatusConflict
+		} else if errors.As(err, &invalidSpec) {
+			status = http.StatusUnprocessableEntity
+		}
+		return nil, &restError{status, `Can't start an Instance: "` + err.Error() + `"`}
+	}
+	if cmd.Params.WaitReady {
+		err := handler.sv.WaitInstanceReady(id, time.Duration(cmd.Params.ReadyTimeout))
+		if err != nil {
+			// The Instance that isn't ready is useless
+			// for the client, which doesn't know its ID.
+			handler.sv.StopInstance(id, true)
+			return nil, &restError{http.StatusInternalServerError,
+				`The Instance isn't ready: "` + err.Error() + `"`}
+		}
+	}
+
+	status, err := handler.sv.GetInstanceStatus(id)
+	if err != nil {
+		// The Instance has been stopped by another request.
+		return nil, &restError{http.StatusConflict, err.Error()}
+	}
+	wr.Header().Set("Location", instancesPath+"/"+strconv.Itoa(id))
+	return &statusResult{status}, nil
+}
+
+// status returns the status of the Instance.
+func (handler *InstancesHandler) status(ref string) (interface{}, *restError) {
+	id, rerr := handler.findInstance(ref)
+	if rerr != nil {
+		return nil, rerr
+	}
+	status, err := handler.sv.GetInstanceStatus(id)
+	if err != nil {
+		return nil, &restError{http.StatusNotFound, err.Error()}
+	}
+	return &statusResult{status}, nil
+}
+
+// stop stops the Instance.
+// body - the parameters of the "stop" command (nil - the defaults).
+func (handler *InstancesHandler) stop(ref string, body io.Reader) *restError {
+	id, rerr := handler.findInstance(ref)
+	if rerr != nil {
+		return rerr
+	}
+	var cmd command
+	params := map[string]interface{}{"id": float64(id)}
+	if rerr := parseBody(body, "stop", params, &cmd); rerr != nil {
+		return rerr
+	}
+	if err := handler.sv.StopInstance(id, cmd.Params.Force); err != nil {
+		return &restError{http.StatusConflict, `Can't stop the Instance: "` +
+			err.Error() + `"`}
+	}
+	return nil
+}
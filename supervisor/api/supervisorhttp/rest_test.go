@@ -0,0 +1,115 @@
+package supervisorhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tarantool/tvisor/supervisor/core"
+)
+
+// restRequest sends the request to the handler and
+// returns the response with the decoded body (if any).
+func restRequest(t *testing.T, handler http.Handler, method string, path string,
+	body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var res map[string]interface{}
+	if rec.Body.Len() != 0 {
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &res), "Invalid JSON in the response.")
+	}
+	return rec, res
+}
+
+// TestInstancesHandler tests the REST API of the Instances.
+func TestInstancesHandler(t *testing.T) {
+	assert := assert.New(t)
+	cfg := new(core.Cfg)
+	cfg.InstancesDir = "../../../test_instances"
+	cfg.TermTimeout = core.Duration(100 * time.Millisecond)
+	sv := core.NewSupervisor(cfg)
+	t.Cleanup(func() { sv.StopAllInstances() })
+	handler := NewInstancesHandler(sv, NewSupervisorHandler(sv))
+
+	// The test Instance is killed at once to not depend on its signal handlers.
+	startBody := `{"name": "test_instance", "stop_sequence": [{"signal": "SIGKILL"}]}`
+	rec, res := restRequest(t, handler, http.MethodPost, "/instances", startBody)
+	if !assert.Equal(http.StatusCreated, rec.Code, res) {
+		return
+	}
+	assert.Equal("/instances/1", rec.Header().Get("Location"))
+	assert.Equal("test_instance", res["status"].(map[string]interface{})["key"])
+
+	// Conflict and validation errors.
+	rec, res = restRequest(t, handler, http.MethodPost, "/instances", startBody)
+	assert.Equal(http.StatusConflict, rec.Code)
+	assert.Contains(res["err"], `The key "test_instance" is already used`)
+	rec, _ = restRequest(t, handler, http.MethodPost, "/instances", `{"name": "unknown_instance"}`)
+	assert.Equal(http.StatusUnprocessableEntity, rec.Code)
+	rec, _ = restRequest(t, handler, http.MethodPost, "/instances",
+		`{"name": "test_instance", "key_suffix": "a/b"}`)
+	assert.Equal(http.StatusUnprocessableEntity, rec.Code)
+	rec, _ = restRequest(t, handler, http.MethodPost, "/instances", `{"foo": 1}`)
+	assert.Equal(http.StatusUnprocessableEntity, rec.Code)
+	rec, _ = restRequest(t, handler, http.MethodPost, "/instances",
+		`{"name": "test_instance", "readiness": {"type": "file", "paht": "/tmp/ready"}}`)
+	assert.Equal(http.StatusUnprocessableEntity, rec.Code)
+	for _, body := range []string{`{"name": `, `null`, `["test_instance"]`} {
+		rec, _ = restRequest(t, handler, http.MethodPost, "/instances", body)
+		assert.Equal(http.StatusBadRequest, rec.Code, body)
+	}
+
+	// The duplicate is started if it is allowed.
+	rec, res = restRequest(t, handler, http.MethodPost, "/instances",
+		`{"name": "test_instance", "allow_duplicate": true,
+		"stop_sequence": [{"signal": "SIGKILL"}]}`)
+	assert.Equal(http.StatusCreated, rec.Code, res)
+	dupPath := rec.Header().Get("Location")
+	assert.Equal("test_instance-", res["status"].(map[string]interface{})["key"].(string)[:14])
+
+	rec, res = restRequest(t, handler, http.MethodGet, "/instances", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Len(res["instances"], 2)
+
+	// The Instance is found by the ID and by the key.
+	for _, path := range []string{"/instances/1", "/instances/test_instance"} {
+		rec, res = restRequest(t, handler, http.MethodGet, path, "")
+		if assert.Equal(http.StatusOK, rec.Code, path) {
+			assert.Equal(1.0, res["status"].(map[string]interface{})["id"], path)
+		}
+	}
+	for _, path := range []string{"/instances/100", "/instances/unknown"} {
+		rec, _ = restRequest(t, handler, http.MethodGet, path, "")
+		assert.Equal(http.StatusNotFound, rec.Code, path)
+	}
+	rec, _ = restRequest(t, handler, http.MethodPost, "/instances/100/stop", "")
+	assert.Equal(http.StatusNotFound, rec.Code)
+	rec, _ = restRequest(t, handler, http.MethodGet, "/instances/1/foo", "")
+	assert.Equal(http.StatusNotFound, rec.Code)
+	rec, _ = restRequest(t, handler, http.MethodPut, "/instances/1", "")
+	assert.Equal(http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal("GET, DELETE", rec.Header().Get("Allow"))
+
+	// Stop the Instances.
+	rec, _ = restRequest(t, handler, http.MethodPost, "/instances/1/stop", `{"force": "yes"}`)
+	assert.Equal(http.StatusUnprocessableEntity, rec.Code)
+	rec, _ = restRequest(t, handler, http.MethodPost, "/instances/1/stop", `null`)
+	assert.Equal(http.StatusBadRequest, rec.Code)
+	// The stop sequence is only "SIGKILL", so the graceful stop fails.
+	rec, _ = restRequest(t, handler, http.MethodPost, "/instances/1/stop", `{"force": false}`)
+	assert.Equal(http.StatusConflict, rec.Code)
+	rec, res = restRequest(t, handler, http.MethodPost, "/instances/test_instance/stop", "")
+	assert.Equal(http.StatusOK, rec.Code, res)
+	assert.Equal(true, res["done"])
+	rec, _ = restRequest(t, handler, http.MethodDelete, dupPath, "")
+	assert.Equal(http.StatusNoContent, rec.Code)
+	assert.Zero(rec.Body.Len())
+	rec, _ = restRequest(t, handler, http.MethodDelete, dupPath, "")
+	assert.Equal(http.StatusNotFound, rec.Code)
+	assert.Empty(sv.ListInstances())
+}
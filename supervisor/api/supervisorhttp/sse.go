@@ -0,0 +1,67 @@
+package supervisorhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// sseKeepAliveInterval is the interval between comments sent to keep
+// the connection alive and to notice that the client has gone.
+const sseKeepAliveInterval = 15 * time.Second
+
+// sseWriter writes Server-Sent Events to an HTTP response.
+type sseWriter struct {
+	wr      http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newSSEWriter prepares the HTTP response for Server-Sent Events.
+func newSSEWriter(wr http.ResponseWriter) (*sseWriter, error) {
+	flusher, ok := wr.(http.Flusher)
+	if !ok {
+		return nil, errors.New("Streaming is not supported.")
+	}
+	wr.Header().Set("Content-Type", "text/event-stream")
+	wr.Header().Set("Cache-Control", "no-cache")
+	wr.Header().Set("Connection", "keep-alive")
+	wr.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &sseWriter{wr: wr, flusher: flusher}, nil
+}
+
+// send sends an event with JSON-encoded data.
+// id - the event ID (omitted if empty).
+// event - the event type (omitted if empty).
+func (sse *sseWriter) send(id string, event string, data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(sse.wr, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if event != "" {
+		if _, err := fmt.Fprintf(sse.wr, "event: %s\n", event); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(sse.wr, "data: %s\n\n", jsonData); err != nil {
+		return err
+	}
+	sse.flusher.Flush()
+	return nil
+}
+
+// keepAlive sends a comment to keep the connection alive.
+func (sse *sseWriter) keepAlive() error {
+	if _, err := fmt.Fprint(sse.wr, ": keep-alive\n\n"); err != nil {
+		return err
+	}
+	sse.flusher.Flush()
+	return nil
+}
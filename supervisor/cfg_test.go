@@ -3,10 +3,12 @@ package main
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tarantool/tvisor/supervisor/core"
 )
 
 // TestCfgParse checks configuration parsing.
@@ -14,12 +16,15 @@ func TestCfgParse(t *testing.T) {
 	assert := assert.New(t)
 	// Config for the test.
 	cfgStr := `{
-  "instances_dir": "test_instances",
-  "termination_timeout": 1
+  "instances_dir": "../test_instances",
+  "termination_timeout": 1,
+  "restart": {
+    "backoff_initial": 0.5
+  }
 }
 `
 	// Create temporary cfg file.
-	cfgFile, err := ioutil.TempFile("", "cfg")
+	cfgFile, err := ioutil.TempFile("", "cfg*.json")
 	assert.Nilf(err, `Can't create test cfg. Error: "%v".`, err)
 	defer os.Remove(cfgFile.Name())
 
@@ -28,9 +33,256 @@ func TestCfgParse(t *testing.T) {
 	cfgFile.Sync()
 
 	// Parse and check the config.
-	cfg, err := parseCfg(cfgFile.Name())
+	cfg, err := parseCfg(&args{CfgPath: cfgFile.Name()})
 	assert.Nilf(err, `Failed to parse the config. Error: "%v".`, err)
 
-	assert.True(cfg.TermTimeout == 1*time.Second &&
-		cfg.InstancesDir == "test_instances", "Failed to parse the config.")
+	assert.True(cfg.TermTimeout == core.Duration(1*time.Second) &&
+		cfg.InstancesDir == "../test_instances", "Failed to parse the config.")
+
+	// Check the restart settings (some of them are defaults).
+	assert.Equal(core.Duration(500*time.Millisecond), cfg.Restart.BackoffInitial)
+	assert.Equal(core.Duration(time.Minute), cfg.Restart.BackoffMax)
+	assert.Equal(5, cfg.Restart.MaxRestarts)
+}
+
+// writeTestCfg writes the JSON config to a temporary file and returns its path.
+func writeTestCfg(t *testing.T, cfgStr string) string {
+	return writeTestCfgFile(t, "cfg.json", cfgStr)
+}
+
+// writeTestCfgFile writes the config to a temporary
+// file with the name and returns its path.
+func writeTestCfgFile(t *testing.T, name string, cfgStr string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(cfgStr), 0644); err != nil {
+		t.Fatalf(`Can't write test cfg to file: "%v".`, err)
+	}
+	return path
+}
+
+// TestCfgValidation checks that invalid configs are rejected.
+func TestCfgValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		cfg string
+		err string
+	}{
+		{
+			// Unknown field.
+			cfg: "{\n  \"instances_dir\": \"../test_instances\",\n  \"restart\": {\"backof\": 1}\n}",
+			err: `Line 3, column 15: unknown field "restart.backof".`,
+		},
+		{
+			// Invalid type.
+			cfg: `{"termination_timeout": "30"}`,
+			err: `Line 1, column 25: invalid value of "termination_timeout": expected a number of seconds or a duration string`,
+		},
+		{
+			// Not an integer.
+			cfg: `{"instances": [{"name": "app", "log": {"max_size": 1.5}}]}`,
+			err: `Line 1, column 52: invalid value of "instances[0].log.max_size": expected an integer.`,
+		},
+		{
+			// Invalid resource limit.
+			cfg: `{"instances": [{"name": "app", "rlimits": {"nofile": {"soft": "max"}}}]}`,
+			err: `Line 1, column 63: invalid value of "instances[0].rlimits.nofile.soft": ` +
+				`expected a non-negative integer or "unlimited".`,
+		},
+		{
+			cfg: `{"instances_dir": "../test_instances", "instances": [{"name": "app",
+				"rlimits": {"core": {"soft": "unlimited", "hard": 0}}}]}`,
+			err: `The soft limit of "core" is greater than the hard one.`,
+		},
+		{
+			// Credential checks.
+			cfg: `{"instances_dir": "../test_instances", "instances": [{"name": "app",
+				"user": "tvisor-unknown-user"}], "drop_privileges": {"group": "nogroup"}}`,
+			err: `"drop_privileges.group" is set, but "drop_privileges.user" is empty. ` +
+				`Invalid "instances[0]": Unknown user "tvisor-unknown-user".`,
+		},
+		{
+			cfg: `{"instances_dir": "../test_instances", "work_dir": "/var/lib/{app}",
+				"instances": [{"name": "app", "umask": "0999"}]}`,
+			err: `Invalid "work_dir": Unknown placeholder "{app}" in the working directory ` +
+				`"/var/lib/{app}". Invalid "instances[0]": Invalid umask "0999"`,
+		},
+		{
+			cfg: `{"instances_dir": "../test_instances", "allowed_signals": ["SIGHUP", "SIGFOO"]}`,
+			err: `Invalid "allowed_signals": Unknown signal "SIGFOO".`,
+		},
+		{
+			// Syntax error.
+			cfg: "{\n  \"instances_dir\": \"../test_instances\"\n  \"logs_dir\": \"\"\n}",
+			err: `Line 3, column 3: invalid character '"' after object key:value pair.`,
+		},
+		{
+			cfg: `{"instances_dir": "../test_instances"} {}`,
+			err: `Line 1, column 41: unexpected data after the config.`,
+		},
+		{
+			// Range checks.
+			cfg: `{"instances_dir": "../test_instances", "termination_timeout": 0,
+				"restart": {"backoff_initial": 10, "backoff_max": 5}}`,
+			err: `"termination_timeout" should be positive. ` +
+				`"restart.backoff_max" is less than "restart.backoff_initial".`,
+		},
+		{
+			// Directory checks.
+			cfg: `{"instances_dir": "cfg_test.go"}`,
+			err: `Invalid "instances_dir": "cfg_test.go" isn't a directory.`,
+		},
+		{
+			cfg: `{"instances_dir": "../test_instances", "instances": [{"name": "app"},
+				{"name": "app"}, {"name": "", "restart_policy": "sometimes"}]}`,
+			err: `The instance "app" is declared more than once ("instances[1]").`,
+		},
+	}
+	for _, c := range cases {
+		_, err := parseCfg(&args{CfgPath: writeTestCfg(t, c.cfg)})
+		if assert.NotNilf(err, "The config is valid: %v", c.cfg) {
+			assert.Contains(err.Error(), c.err)
+		}
+	}
+
+	// The fractional timeout is allowed.
+	cfg, err := parseCfg(&args{CfgPath: writeTestCfg(t,
+		`{"instances_dir": "../test_instances", "termination_timeout": 0.5}`)})
+	assert.Nilf(err, `Failed to parse the config. Error: "%v".`, err)
+	assert.Equal(core.Duration(500*time.Millisecond), cfg.TermTimeout)
+}
+
+// TestCfgFormats checks that the configs in all the formats are parsed the same way.
+func TestCfgFormats(t *testing.T) {
+	assert := assert.New(t)
+
+	cfgs := map[string]string{
+		"cfg.json": `{
+  "instances_dir": "../test_instances",
+  "termination_timeout": "1m30s",
+  "restart": {"backoff_initial": 0.5, "max_restarts": 3},
+  "log": {"compress": false},
+  "instances": [
+    {"name": "app", "env": ["A=1"], "autostart": false},
+    {"name": "router", "readiness": {"type": "tcp", "address": "127.0.0.1:3301", "interval": "500ms"}}
+  ]
+}`,
+		"cfg.yaml": `
+instances_dir: ../test_instances
+termination_timeout: 1m30s
+restart:
+  backoff_initial: 0.5
+  max_restarts: 3
+log:
+  compress: false
+instances:
+  - name: app
+    env: ["A=1"]
+    autostart: false
+  - name: router
+    readiness:
+      type: tcp
+      address: 127.0.0.1:3301
+      interval: 500ms
+`,
+		"cfg.toml": `
+instances_dir = "../test_instances"
+termination_timeout = "1m30s"
+
+[restart]
+backoff_initial = 0.5
+max_restarts = 3
+
+[log]
+compress = false # Comment.
+
+[[instances]]
+name = "app"
+env = ["A=1"]
+autostart = false
+
+[[instances]]
+name = "router"
+readiness = { type = "tcp", address = "127.0.0.1:3301", interval = "500ms" }
+`,
+	}
+
+	var expected *core.Cfg
+	for name, cfgStr := range cfgs {
+		cfg, err := parseCfg(&args{CfgPath: writeTestCfgFile(t, name, cfgStr)})
+		if !assert.Nilf(err, `Failed to parse "%v". Error: "%v".`, name, err) {
+			continue
+		}
+		assert.Equal(core.Duration(90*time.Second), cfg.TermTimeout)
+		assert.Equal(core.Duration(500*time.Millisecond), cfg.Restart.BackoffInitial)
+		// Defaults.
+		assert.Equal(core.Duration(time.Minute), cfg.Restart.BackoffMax)
+		assert.Equal(10, cfg.Log.MaxBackups)
+		if assert.Len(cfg.Instances, 2) {
+			assert.False(*cfg.Instances[0].Autostart)
+			assert.Equal(core.Duration(500*time.Millisecond),
+				cfg.Instances[1].Readiness.Interval)
+		}
+		if expected == nil {
+			expected = cfg
+		} else {
+			assert.Equalf(expected, cfg, `"%v" is parsed differently.`, name)
+		}
+	}
+
+	// The errors are reported with positions for all the formats.
+	errCfgs := map[string]string{
+		"cfg.yml":  "instances_dir: ../test_instances\nrestart:\n  backof: 1\n",
+		"cfg.toml": "instances_dir = \"../test_instances\"\n[restart]\nbackof = 1\n",
+	}
+	for name, cfgStr := range errCfgs {
+		_, err := parseCfg(&args{CfgPath: writeTestCfgFile(t, name, cfgStr)})
+		if assert.NotNilf(err, `"%v" is valid.`, name) {
+			assert.Contains(err.Error(), `Line 3, column `)
+			assert.Contains(err.Error(), `unknown field "restart.backof".`)
+		}
+	}
+
+	_, err := parseCfg(&args{CfgPath: writeTestCfgFile(t, "cfg.yaml",
+		"termination_timeout: 2x\n")})
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), `Line 1, column 22: invalid value of "termination_timeout"`)
+	}
+	_, err = parseCfg(&args{CfgPath: writeTestCfgFile(t, "cfg.ini", "")})
+	assert.NotNil(err, "Unknown format is accepted.")
+}
+
+// TestCfgLayers checks that the environment variables and
+// the flags override the config file.
+func TestCfgLayers(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("TVISOR_LOGS_DIR", "/env/logs")
+	defer os.Unsetenv("TVISOR_LOGS_DIR")
+	// The path to the config isn't a config field.
+	os.Setenv(cfgPathEnv, "cfg.json")
+	defer os.Unsetenv(cfgPathEnv)
+
+	cfgArgs := &args{
+		CfgPath:  writeTestCfg(t, `{"instances_dir": "../test_instances", "logs_dir": "/file"}`),
+		CfgFlags: map[string]string{"termination_timeout": "45s"},
+	}
+	cfg, sources, err := loadCfg(cfgArgs)
+	if !assert.Nilf(err, `Failed to load the config. Error: "%v".`, err) {
+		return
+	}
+	assert.Equal("/env/logs", cfg.LogsDir)
+	assert.Equal("env TVISOR_LOGS_DIR", sources["logs_dir"])
+	assert.Equal(core.CfgSourceFile, sources["instances_dir"])
+	assert.Equal("flag -termination_timeout", sources["termination_timeout"])
+	assert.Equal(core.CfgSourceDefault, sources["state_file"])
+
+	values, err := cfgValues(cfg)
+	if assert.Nil(err) {
+		assert.Equal(`"/env/logs"`, values["logs_dir"])
+		assert.Equal(`45`, values["termination_timeout"])
+		assert.Equal(`5`, values["restart.max_restarts"])
+		assert.Equal(`true`, values["log.compress"])
+		assert.Len(values, len(core.CfgFields()))
+	}
 }
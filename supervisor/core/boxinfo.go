@@ -0,0 +1,317 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/tarantool/tvisor/supervisor/iproto"
+)
+
+// Default settings of the "box.info" polling.
+const (
+	defaultBoxInfoInterval = 5 * time.Second
+	defaultBoxInfoTimeout  = time.Second
+)
+
+// boxInfoExpr returns the part of "box.info" exposed in the Instance status.
+const boxInfoExpr = `
+local info = box.info
+local replication = {}
+for _, replica in pairs(info.replication or {}) do
+    local upstream = replica.upstream or {}
+    table.insert(replication, {
+        id = replica.id,
+        uuid = replica.uuid,
+        upstream_status = upstream.status,
+        lag = upstream.lag,
+    })
+end
+return {
+    status = info.status,
+    ro = info.ro,
+    id = info.id,
+    uuid = info.uuid,
+    version = info.version,
+    vclock = info.vclock,
+    replication = replication,
+}
+`
+
+// IprotoSpec describes the connection to the Instance over iproto.
+type IprotoSpec struct {
+	// Address - "host:port" of the Instance.
+	Address string `json:"address,omitempty"`
+	// Path - the path to the unix socket of the Instance
+	// (used instead of the address if set).
+	Path string `json:"path,omitempty"`
+	// User - the user name ("guest" if empty).
+	User string `json:"user,omitempty"`
+	// Password - the password of the user.
+	Password string `json:"password,omitempty"`
+	// Interval - the interval between "box.info" queries. Default: 5s.
+	Interval Duration `json:"interval,omitempty"`
+	// Timeout - the timeout of a request. Default: 1s.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// validate checks the iproto settings.
+func (spec *IprotoSpec) validate() error {
+	if spec.Address == "" && spec.Path == "" {
+		return errors.New("The iproto address of the instance is empty.")
+	}
+	if spec.Interval < 0 || spec.Timeout < 0 {
+		return errors.New("The iproto interval and timeout can't be negative.")
+	}
+	return nil
+}
+
+// interval returns the interval between "box.info" queries.
+func (spec *IprotoSpec) interval() time.Duration {
+	if spec.Interval == 0 {
+		return defaultBoxInfoInterval
+	}
+	return time.Duration(spec.Interval)
+}
+
+// connect connects to the Instance and authenticates the user.
+func (spec *IprotoSpec) connect() (*iproto.Conn, error) {
+	timeout := time.Duration(spec.Timeout)
+	if timeout == 0 {
+		timeout = defaultBoxInfoTimeout
+	}
+	network, address := "tcp", spec.Address
+	if spec.Path != "" {
+		network, address = "unix", spec.Path
+	}
+	conn, err := iproto.Dial(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if spec.User != "" && spec.User != "guest" {
+		if err := conn.Auth(spec.User, spec.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// ReplicaInfo describes a replica from "box.info.replication".
+type ReplicaInfo struct {
+	// ID is the replica ID.
+	ID int64 `json:"id"`
+	// UUID is the replica UUID.
+	UUID string `json:"uuid"`
+	// UpstreamStatus is the status of the replication
+	// from the replica (empty for the Instance itself).
+	UpstreamStatus string `json:"upstream_status,omitempty"`
+	// Lag is the replication lag (in seconds).
+	Lag float64 `json:"lag,omitempty"`
+}
+
+// BoxInfo describes the part of "box.info" of the Instance.
+type BoxInfo struct {
+	// UpdateTime is the time of the last successful query.
+	UpdateTime time.Time `json:"update_time"`
+	// Error is the error of the last query (if it has failed).
+	Error string `json:"error,omitempty"`
+	// Status is the status of the Instance ("running", "orphan", ...).
+	Status string `json:"status"`
+	// RO indicates that the Instance is read-only.
+	RO bool `json:"ro"`
+	// ID is the Instance ID in the replica set.
+	ID int64 `json:"id"`
+	// UUID is the Instance UUID.
+	UUID string `json:"uuid"`
+	// Version is the Tarantool version.
+	Version string `json:"version"`
+	// Vclock is a map of the replica ID to its LSN.
+	Vclock map[int64]int64 `json:"vclock"`
+	// Replication describes the replicas of the replica set.
+	Replication []ReplicaInfo `json:"replication"`
+}
+
+// toInt64 converts a decoded MessagePack number to int64.
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int64:
+		return v
+	case uint64:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// toFloat64 converts a decoded MessagePack number to float64.
+func toFloat64(value interface{}) float64 {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// parseBoxInfo parses the result of "boxInfoExpr".
+func parseBoxInfo(data []interface{}) (*BoxInfo, error) {
+	if len(data) == 0 {
+		return nil, errors.New("The box.info is empty.")
+	}
+	fields, ok := data[0].(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("Invalid format of the box.info.")
+	}
+
+	info := BoxInfo{UpdateTime: time.Now(), Vclock: make(map[int64]int64)}
+	info.Status, _ = fields["status"].(string)
+	info.RO, _ = fields["ro"].(bool)
+	info.ID = toInt64(fields["id"])
+	info.UUID, _ = fields["uuid"].(string)
+	info.Version, _ = fields["version"].(string)
+
+	// A Lua table is encoded as an array if its keys
+	// are 1..n, otherwise it is encoded as a map.
+	switch vclock := fields["vclock"].(type) {
+	case []interface{}:
+		for i, lsn := range vclock {
+			info.Vclock[int64(i+1)] = toInt64(lsn)
+		}
+	case map[interface{}]interface{}:
+		for id, lsn := range vclock {
+			info.Vclock[toInt64(id)] = toInt64(lsn)
+		}
+	}
+
+	var replicas []interface{}
+	switch replication := fields["replication"].(type) {
+	case []interface{}:
+		replicas = replication
+	case map[interface{}]interface{}:
+		// An empty Lua table is encoded as a map.
+		for _, replica := range replication {
+			replicas = append(replicas, replica)
+		}
+	}
+	for _, replicaValue := range replicas {
+		replica, ok := replicaValue.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		replicaInfo := ReplicaInfo{ID: toInt64(replica["id"]), Lag: toFloat64(replica["lag"])}
+		replicaInfo.UUID, _ = replica["uuid"].(string)
+		replicaInfo.UpstreamStatus, _ = replica["upstream_status"].(string)
+		info.Replication = append(info.Replication, replicaInfo)
+	}
+	sort.Slice(info.Replication, func(i, j int) bool {
+		return info.Replication[i].ID < info.Replication[j].ID
+	})
+	return &info, nil
+}
+
+// setBoxInfo saves the result of the "box.info" query of the process.
+// If the query has failed, the previous "box.info" is kept with the error.
+// Returns false if the process isn't the current one anymore.
+func (inst *Instance) setBoxInfo(process *os.Process, info *BoxInfo, err error) bool {
+	inst.infoMutex.Lock()
+	defer inst.infoMutex.Unlock()
+	if inst.Cmd.Process != process {
+		return false
+	}
+	if err == nil {
+		inst.boxInfo = info
+		return true
+	}
+	failedInfo := BoxInfo{}
+	if inst.boxInfo != nil {
+		failedInfo = *inst.boxInfo
+	}
+	failedInfo.Error = err.Error()
+	inst.boxInfo = &failedInfo
+	return true
+}
+
+// waitProcessReady waits for the process to become ready.
+// Returns false if the process has been terminated.
+func waitProcessReady(process *os.Process, readyCh chan struct{}) bool {
+	ticker := time.NewTicker(readinessPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-readyCh:
+			return true
+		case <-ticker.C:
+			if process.Signal(syscall.Signal(0)) != nil {
+				return false
+			}
+		}
+	}
+}
+
+// pollBoxInfo periodically queries "box.info" of the current Instance
+// process after it becomes ready. The connection is kept open between
+// the queries.
+func (sv *Supervisor) pollBoxInfo(id int, inst *Instance) {
+	spec := inst.Spec.Iproto
+	if spec == nil {
+		return
+	}
+	process, readyCh := inst.readiness()
+	if !waitProcessReady(process, readyCh) {
+		return
+	}
+
+	var conn *iproto.Conn
+	var lastErr string
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	ticker := time.NewTicker(spec.interval())
+	defer ticker.Stop()
+	for {
+		if process.Signal(syscall.Signal(0)) != nil {
+			return
+		}
+
+		var info *BoxInfo
+		var err error
+		if conn == nil {
+			conn, err = spec.connect()
+		}
+		if err == nil {
+			var data []interface{}
+			if data, err = conn.Eval(boxInfoExpr); err == nil {
+				info, err = parseBoxInfo(data)
+			}
+		}
+		// Reconnect on the next query.
+		if err != nil && conn != nil {
+			conn.Close()
+			conn = nil
+		}
+		if !inst.setBoxInfo(process, info, err) {
+			return
+		}
+		// Don't flood the log with the same error.
+		if err != nil && err.Error() != lastErr {
+			log.Printf(`Can't get the box.info of the Instance. ID: %v. Error: "%v"`,
+				id, err)
+		}
+		lastErr = ""
+		if err != nil {
+			lastErr = err.Error()
+		}
+
+		<-ticker.C
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tarantool/tvisor/supervisor/iproto"
+	"github.com/tarantool/tvisor/supervisor/iproto/iprototest"
+)
+
+// testBoxInfo returns "box.info" as it is returned by Tarantool.
+func testBoxInfo(vclock interface{}) []interface{} {
+	return []interface{}{map[interface{}]interface{}{
+		"status":  "running",
+		"ro":      true,
+		"id":      int64(2),
+		"uuid":    "5a5ef21c-9ee8-4e4d-b4d3-ddeaf5e87b53",
+		"version": "2.8.2-0-g4d8eac1",
+		"vclock":  vclock,
+		"replication": []interface{}{
+			map[interface{}]interface{}{
+				"id":   int64(2),
+				"uuid": "5a5ef21c-9ee8-4e4d-b4d3-ddeaf5e87b53",
+			},
+			map[interface{}]interface{}{
+				"id":              int64(1),
+				"uuid":            "d1b9a1d4-1b0e-4cba-86c4-2ba7ba96cc5c",
+				"upstream_status": "follow",
+				"lag":             0.25,
+			},
+		},
+	}}
+}
+
+// TestParseBoxInfo checks parsing of "box.info".
+func TestParseBoxInfo(t *testing.T) {
+	assert := assert.New(t)
+	info, err := parseBoxInfo(testBoxInfo([]interface{}{int64(10), int64(3)}))
+	if !assert.Nilf(err, `Can't parse the box.info. Error: "%v"`, err) {
+		return
+	}
+	assert.Equal("running", info.Status)
+	assert.True(info.RO)
+	assert.Equal(int64(2), info.ID)
+	assert.Equal("2.8.2-0-g4d8eac1", info.Version)
+	assert.Equal(map[int64]int64{1: 10, 2: 3}, info.Vclock)
+	if assert.Equal(2, len(info.Replication)) {
+		assert.Equal(int64(1), info.Replication[0].ID)
+		assert.Equal("follow", info.Replication[0].UpstreamStatus)
+		assert.Equal(0.25, info.Replication[0].Lag)
+	}
+
+	// The vclock with the local component is encoded as a map.
+	info, err = parseBoxInfo(testBoxInfo(map[interface{}]interface{}{
+		int64(0): int64(5), int64(1): int64(10)}))
+	assert.Nil(err)
+	assert.Equal(map[int64]int64{0: 5, 1: 10}, info.Vclock)
+
+	_, err = parseBoxInfo([]interface{}{"running"})
+	assert.NotNil(err, "Invalid box.info is parsed.")
+}
+
+// Test querying "box.info" of the Instance.
+func TestSupervisorBoxInfo(t *testing.T) {
+	assert := assert.New(t)
+	srv, err := iprototest.NewServer(func(req *iprototest.Request) ([]interface{}, error) {
+		if req.Type != iprototest.TypeEval || req.User != "admin" {
+			return nil, &iproto.Error{Code: iprototest.ErrAccessDenied, Msg: "Access denied"}
+		}
+		return testBoxInfo([]interface{}{int64(10)}), nil
+	}, map[string]string{"admin": "secret"})
+	if !assert.Nilf(err, `Can't start the server. Error: "%v"`, err) {
+		return
+	}
+	defer srv.Close()
+
+	sv := newTestSupervisor(t, nil)
+
+	id, err := sv.StartInstance(&InstanceSpec{Name: "test_instance",
+		RestartPolicy: RestartNever,
+		Iproto: &IprotoSpec{Address: srv.Addr, User: "admin", Password: "secret",
+			Interval: Duration(10 * time.Millisecond)}})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+
+	// waitBoxInfo waits for the box.info matching the condition.
+	waitBoxInfo := func(cond func(info *BoxInfo) bool) *BoxInfo {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			status, _ := sv.GetInstanceStatus(id)
+			if status.BoxInfo != nil && cond(status.BoxInfo) {
+				return status.BoxInfo
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Error("The box.info hasn't been received.")
+		return nil
+	}
+
+	info := waitBoxInfo(func(info *BoxInfo) bool { return info.Error == "" })
+	if info != nil {
+		assert.Equal("running", info.Status)
+		assert.Equal(map[int64]int64{1: 10}, info.Vclock)
+	}
+
+	// The last box.info is kept with the error.
+	srv.Close()
+	info = waitBoxInfo(func(info *BoxInfo) bool { return info.Error != "" })
+	if info != nil {
+		assert.Equal("running", info.Status)
+	}
+}
@@ -1,9 +1,73 @@
 package core
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Duration is a time duration that is set in the config in seconds
+// or as a duration string (for example: "30s", "1m30s", see
+// time.ParseDuration).
+type Duration time.Duration
+
+// UnmarshalJSON decodes the duration from a number of seconds
+// or a duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		duration, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		*d = Duration(duration)
+		return nil
+	}
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return err
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// MarshalJSON encodes the duration as a number of seconds.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatFloat(time.Duration(d).Seconds(), 'f', -1, 64)), nil
+}
+
+// RestartCfg describes the restart settings of Instances.
+type RestartCfg struct {
+	// BackoffInitial - the delay before the first restart of
+	// a terminated Instance. The delay is doubled on each
+	// following restart within the Window.
+	BackoffInitial Duration `json:"backoff_initial"`
+	// BackoffMax - the maximum delay before a restart.
+	BackoffMax Duration `json:"backoff_max"`
+	// MaxRestarts - the maximum number of restarts within the
+	// Window. If the limit is exceeded, the Instance is considered
+	// failed and won't be restarted anymore. 0 means no limit.
+	MaxRestarts int `json:"max_restarts"`
+	// Window - the period of time during which restarts are counted.
+	Window Duration `json:"window"`
+}
+
+// PrivilegesCfg describes the user and the group to which the
+// Supervisor switches after it binds the HTTP listener.
+type PrivilegesCfg struct {
+	// User - the name or ID of the user. An empty value
+	// disables the dropping of the privileges.
+	User string `json:"user"`
+	// Group - the name or ID of the group. Default: the
+	// primary group of the user.
+	Group string `json:"group"`
+}
+
 // Cfg stores Supervisor settings.
 type Cfg struct {
 	// InstancesDir - directory that stores
@@ -14,5 +78,176 @@ type Cfg struct {
 	// the SIGKILL signal will be used to stop the
 	// instance if the force option is true, else an
 	// error will be returned.
-	TermTimeout time.Duration `json:"termination_timeout"`
+	TermTimeout Duration `json:"termination_timeout"`
+	// StateFile - path to the file used to persist the state of
+	// the Supervisor (the list of running Instances). It allows
+	// to re-adopt running Instances after the Supervisor restart.
+	// An empty value disables the persistence.
+	StateFile string `json:"state_file"`
+	// Restart - the restart settings of Instances.
+	Restart RestartCfg `json:"restart"`
+	// ExitHistorySize - the number of last exit statuses
+	// stored for each Instance.
+	ExitHistorySize int `json:"exit_history_size"`
+	// LogsDir - directory to store the output (stdout / stderr)
+	// of Instances: "<LogsDir>/<name>.log". An empty value disables
+	// the output capturing.
+	LogsDir string `json:"logs_dir"`
+	// Log - the rotation settings of the Instance logs.
+	Log LogCfg `json:"log"`
+	// OutputBufferLines - the number of the last lines of the
+	// Instance output kept in memory to be read over the API.
+	// 0 disables the buffer.
+	OutputBufferLines int `json:"output_buffer_lines"`
+	// EventHistorySize - the number of the last Instance lifecycle
+	// events kept in memory to allow clients to resume the event
+	// stream after reconnecting.
+	EventHistorySize int `json:"event_history_size"`
+	// Instances - the Instances that should be run by the Supervisor
+	// (see DeclaredInstance). The Instances are started, stopped and
+	// restarted by the reconciliation to match the config.
+	Instances []DeclaredInstance `json:"instances"`
+	// ReconcileInterval - the period of the reconciliation of the
+	// declared Instances. 0 disables the periodic reconciliation.
+	ReconcileInterval Duration `json:"reconcile_interval"`
+	// CgroupParent - the path to the parent cgroup (cgroup v2) in
+	// which a cgroup is created for each Instance: "<name>-<id>".
+	// If the cgroup can't be created, the Instance is started without
+	// it (unless it has the cgroup limits). An empty value disables
+	// the cgroups.
+	CgroupParent string `json:"cgroup_parent"`
+	// DropPrivileges - the user and the group of the Supervisor after
+	// the start. The privileges aren't dropped if any of the Instances
+	// needs them (is run by another user or group). The "logs_dir",
+	// the "state_file" and the "cgroup_parent" should be writable by
+	// the user.
+	DropPrivileges PrivilegesCfg `json:"drop_privileges"`
+	// WorkDir - the template of the working directory of an Instance,
+	// for example, "/var/lib/tarantool/{name}". The placeholders are
+	// "{name}" and "{id}". The directory and its "run", "log" and "data"
+	// subdirectories are created before the start. An empty value - the
+	// Instances are run in the working directory of the Supervisor.
+	WorkDir string `json:"work_dir"`
+	// KillOnExit - kill the Instances with "SIGKILL" when the Supervisor
+	// terminates, even if it is killed (see PR_SET_PDEATHSIG in prctl(2)).
+	// The Instances can't be re-adopted after the Supervisor restart then
+	// (see StateFile).
+	KillOnExit bool `json:"kill_on_exit"`
+	// AllowedSignals - the signals (the names or the numbers) that
+	// can be sent to the Instances by the clients (see SignalInstance).
+	AllowedSignals []string `json:"allowed_signals"`
+}
+
+// Validate checks the Supervisor settings: the ranges of the values,
+// the directories and the declared Instances. All the found problems
+// are described in the returned error.
+func (cfg *Cfg) Validate() error {
+	var problems []string
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	if cfg.InstancesDir == "" {
+		problems = append(problems, `"instances_dir" is empty.`)
+	} else if err := checkDir(cfg.InstancesDir, true); err != nil {
+		problems = append(problems, `Invalid "instances_dir": `+err.Error())
+	}
+	if cfg.LogsDir != "" {
+		if err := checkDir(cfg.LogsDir, false); err != nil {
+			problems = append(problems, `Invalid "logs_dir": `+err.Error())
+		}
+	}
+	if cfg.StateFile != "" {
+		if err := checkDir(filepath.Dir(cfg.StateFile), false); err != nil {
+			problems = append(problems, `Invalid "state_file": `+err.Error())
+		}
+	}
+
+	check(cfg.TermTimeout > 0, `"termination_timeout" should be positive.`)
+	check(cfg.Restart.BackoffInitial >= 0, `"restart.backoff_initial" can't be negative.`)
+	check(cfg.Restart.BackoffMax >= 0, `"restart.backoff_max" can't be negative.`)
+	check(cfg.Restart.BackoffMax == 0 || cfg.Restart.BackoffMax >= cfg.Restart.BackoffInitial,
+		`"restart.backoff_max" is less than "restart.backoff_initial".`)
+	check(cfg.Restart.MaxRestarts >= 0, `"restart.max_restarts" can't be negative.`)
+	check(cfg.Restart.Window >= 0, `"restart.window" can't be negative.`)
+	check(cfg.Restart.MaxRestarts == 0 || cfg.Restart.Window > 0,
+		`"restart.window" should be positive if "restart.max_restarts" is set.`)
+	check(cfg.ExitHistorySize >= 0, `"exit_history_size" can't be negative.`)
+	if err := cfg.Log.validate(); err != nil {
+		problems = append(problems, `Invalid "log": `+err.Error())
+	}
+	check(cfg.OutputBufferLines >= 0, `"output_buffer_lines" can't be negative.`)
+	check(cfg.EventHistorySize >= 0, `"event_history_size" can't be negative.`)
+	check(cfg.ReconcileInterval >= 0, `"reconcile_interval" can't be negative.`)
+	check(cfg.CgroupParent == "" || filepath.IsAbs(cfg.CgroupParent),
+		`"cgroup_parent" should be an absolute path.`)
+
+	if cfg.WorkDir != "" {
+		if err := checkWorkDir(cfg.WorkDir); err != nil {
+			problems = append(problems, `Invalid "work_dir": `+err.Error())
+		}
+	}
+	for _, sig := range cfg.AllowedSignals {
+		if _, err := parseSignal(sig); err != nil {
+			problems = append(problems, `Invalid "allowed_signals": `+err.Error())
+		}
+	}
+	check(cfg.DropPrivileges.User != "" || cfg.DropPrivileges.Group == "",
+		`"drop_privileges.group" is set, but "drop_privileges.user" is empty.`)
+	if cfg.DropPrivileges.User != "" {
+		if _, err := resolveCredential(cfg.DropPrivileges.User,
+			cfg.DropPrivileges.Group, nil); err != nil {
+			problems = append(problems, `Invalid "drop_privileges": `+err.Error())
+		}
+	}
+
+	keys := make(map[string]bool)
+	for i := range cfg.Instances {
+		decl := &cfg.Instances[i]
+		field := `"instances[` + strconv.Itoa(i) + `]"`
+		if err := decl.validate(); err != nil {
+			problems = append(problems, "Invalid "+field+": "+err.Error())
+		}
+		if decl.Cgroup != nil && cfg.CgroupParent == "" {
+			problems = append(problems, `The cgroup limits of `+field+
+				` are set, but "cgroup_parent" is empty.`)
+		}
+		key := decl.key()
+		if decl.Name != "" && keys[key] {
+			problems = append(problems, `The instance "`+key+
+				`" is declared more than once (`+field+`).`)
+		}
+		keys[key] = true
+	}
+
+	if len(problems) != 0 {
+		return errors.New(strings.Join(problems, " "))
+	}
+	return nil
+}
+
+// checkDir checks that the path is a directory. If mustExist is false,
+// the path can be absent (it will be created by the Supervisor).
+func checkDir(path string, mustExist bool) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) && !mustExist {
+		return nil
+	} else if err != nil {
+		return errors.New(err.Error() + ".")
+	}
+	if !info.IsDir() {
+		return errors.New(`"` + path + `" isn't a directory.`)
+	}
+	// Check that the directory is readable.
+	dir, err := os.Open(path)
+	if err != nil {
+		return errors.New(err.Error() + ".")
+	}
+	defer dir.Close()
+	if _, err := dir.Readdirnames(1); err != nil && err != io.EOF {
+		return errors.New(err.Error() + ".")
+	}
+	return nil
 }
@@ -0,0 +1,450 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config formats.
+const (
+	// CfgFormatJSON - JSON (".json").
+	CfgFormatJSON = "json"
+	// CfgFormatYAML - YAML (".yaml" / ".yml").
+	CfgFormatYAML = "yaml"
+	// CfgFormatTOML - TOML (".toml").
+	CfgFormatTOML = "toml"
+)
+
+// CfgFormatByPath returns the format of the config file by its extension.
+func CfgFormatByPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return CfgFormatJSON, nil
+	case ".yaml", ".yml":
+		return CfgFormatYAML, nil
+	case ".toml":
+		return CfgFormatTOML, nil
+	}
+	return "", errors.New(`Unknown format of the config "` + path +
+		`": the extension should be ".json", ".yaml", ".yml" or ".toml".`)
+}
+
+// CfgError describes an error in the config file.
+type CfgError struct {
+	// Line is the line number of the error (starting from 1).
+	Line int
+	// Column is the column number of the error (starting from 1).
+	Column int
+	// Msg describes the error.
+	Msg string
+}
+
+// Error returns the description of the error with its position.
+func (err *CfgError) Error() string {
+	return "Line " + strconv.Itoa(err.Line) + ", column " +
+		strconv.Itoa(err.Column) + ": " + err.Msg
+}
+
+// offsetPosition returns the line and the column of the offset of the data.
+func offsetPosition(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	} else if offset < 0 {
+		offset = 0
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// newCfgError creates a CfgError for the offset of the data.
+func newCfgError(data []byte, offset int64, msg string) *CfgError {
+	line, column := offsetPosition(data, offset)
+	return &CfgError{Line: line, Column: column, Msg: msg}
+}
+
+// Kinds of config nodes.
+const (
+	nodeNull = iota
+	nodeObject
+	nodeArray
+	nodeString
+	nodeNumber
+	nodeBool
+)
+
+// cfgNode is a value of the config with its position. The configs of all
+// the formats are parsed to nodes, so they are checked and decoded the
+// same way.
+type cfgNode struct {
+	// kind is the kind of the node. See kinds of config nodes.
+	kind int
+	// scalar is the value of the scalar node: string (nodeString),
+	// json.Number (nodeNumber) or bool (nodeBool).
+	scalar interface{}
+	// fields are the fields of the object (in the order of the config).
+	fields []*cfgField
+	// items are the items of the array.
+	items []*cfgNode
+	// line is the line of the node in the config.
+	line int
+	// column is the column of the node in the config.
+	column int
+}
+
+// cfgField is a field of the object node.
+type cfgField struct {
+	// name is the name of the field.
+	name string
+	// line is the line of the field name in the config.
+	line int
+	// column is the column of the field name in the config.
+	column int
+	// value is the value of the field.
+	value *cfgNode
+}
+
+// error creates a CfgError at the position of the node.
+func (node *cfgNode) error(msg string) *CfgError {
+	return &CfgError{Line: node.line, Column: node.column, Msg: msg}
+}
+
+// field returns the field of the object by name (nil if there is no field).
+func (node *cfgNode) field(name string) *cfgField {
+	for _, field := range node.fields {
+		if field.name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// toJSON converts the node to a value that can be encoded to JSON.
+func (node *cfgNode) toJSON() interface{} {
+	switch node.kind {
+	case nodeObject:
+		obj := make(map[string]interface{}, len(node.fields))
+		for _, field := range node.fields {
+			obj[field.name] = field.value.toJSON()
+		}
+		return obj
+	case nodeArray:
+		arr := make([]interface{}, len(node.items))
+		for i, item := range node.items {
+			arr[i] = item.toJSON()
+		}
+		return arr
+	}
+	return node.scalar
+}
+
+// DecodeCfg decodes the config of the format (see config formats) into the
+// cfg. The fields that aren't set in the config keep their values
+// (defaults). Unknown fields and values of wrong types are rejected with
+// a CfgError that describes the position of the error.
+func DecodeCfg(data []byte, format string, cfg *Cfg) error {
+	_, err := decodeCfg(data, format, cfg)
+	return err
+}
+
+// decodeCfg decodes the config into the cfg (see DecodeCfg).
+// Returns the root node of the config.
+func decodeCfg(data []byte, format string, cfg *Cfg) (*cfgNode, error) {
+	var root *cfgNode
+	var err error
+	switch format {
+	case CfgFormatJSON:
+		root, err = parseJSONCfg(data)
+	case CfgFormatYAML:
+		root, err = parseYAMLCfg(data)
+	case CfgFormatTOML:
+		root, err = parseTOMLCfg(data)
+	default:
+		err = errors.New(`Unknown config format: "` + format + `".`)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkCfgNode(root, reflect.TypeOf(cfg).Elem(), ""); err != nil {
+		return nil, err
+	}
+	return root, decodeCfgNode(root, cfg)
+}
+
+// decodeCfgNode decodes the checked node into the cfg. The nodes
+// are decoded the same way for all the formats.
+func decodeCfgNode(root *cfgNode, cfg *Cfg) error {
+	jsonData, err := json.Marshal(root.toJSON())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, cfg)
+}
+
+// jsonCfgParser parses the JSON config to nodes.
+type jsonCfgParser struct {
+	// data is the config.
+	data []byte
+	// dec is used to read the config tokens.
+	dec *json.Decoder
+}
+
+// parseJSONCfg parses the JSON config.
+func parseJSONCfg(data []byte) (*cfgNode, error) {
+	parser := jsonCfgParser{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	parser.dec.UseNumber()
+	root, err := parser.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parser.dec.Token(); err != io.EOF {
+		return nil, newCfgError(data, parser.dec.InputOffset(),
+			"unexpected data after the config.")
+	}
+	return root, nil
+}
+
+// next reads the next token and returns it with its offset.
+func (parser *jsonCfgParser) next() (json.Token, int64, error) {
+	offset := parser.dec.InputOffset()
+	tok, err := parser.dec.Token()
+	if err != nil {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			// The offset is after the invalid character.
+			return nil, 0, newCfgError(parser.data, syntaxErr.Offset-1,
+				syntaxErr.Error()+".")
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, newCfgError(parser.data, int64(len(parser.data)), err.Error()+".")
+	}
+	// Skip the separators before the token.
+	for offset < int64(len(parser.data)) &&
+		strings.IndexByte(" \t\r\n,:", parser.data[offset]) >= 0 {
+		offset++
+	}
+	return tok, offset, nil
+}
+
+// parseValue reads the next value.
+func (parser *jsonCfgParser) parseValue() (*cfgNode, error) {
+	tok, offset, err := parser.next()
+	if err != nil {
+		return nil, err
+	}
+	node := &cfgNode{}
+	node.line, node.column = offsetPosition(parser.data, offset)
+
+	switch tok := tok.(type) {
+	case nil:
+		node.kind = nodeNull
+	case string:
+		node.kind, node.scalar = nodeString, tok
+	case json.Number:
+		node.kind, node.scalar = nodeNumber, tok
+	case bool:
+		node.kind, node.scalar = nodeBool, tok
+	case json.Delim:
+		if tok == '{' {
+			node.kind = nodeObject
+			for parser.dec.More() {
+				key, offset, err := parser.next()
+				if err != nil {
+					return nil, err
+				}
+				field := &cfgField{name: key.(string)}
+				field.line, field.column = offsetPosition(parser.data, offset)
+				if field.value, err = parser.parseValue(); err != nil {
+					return nil, err
+				}
+				node.fields = append(node.fields, field)
+			}
+		} else {
+			node.kind = nodeArray
+			for parser.dec.More() {
+				item, err := parser.parseValue()
+				if err != nil {
+					return nil, err
+				}
+				node.items = append(node.items, item)
+			}
+		}
+		// Read the end of the object / array.
+		if _, _, err := parser.next(); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// fieldName returns the name of the field in the path.
+func fieldName(path string) string {
+	if path == "" {
+		return "the config"
+	}
+	return `"` + path + `"`
+}
+
+// durationType is checked separately, because it is decoded by UnmarshalJSON.
+var durationType = reflect.TypeOf(Duration(0))
+
+// limitTypes is a map of the types of the limits decoded by UnmarshalJSON
+// to the name of the unlimited value.
+var limitTypes = map[reflect.Type]string{
+	reflect.TypeOf(RlimitValue(0)): rlimitUnlimited,
+	reflect.TypeOf(CgroupLimit(0)): cgroupMax,
+}
+
+// checkCfgNode checks that the node matches the type.
+func checkCfgNode(node *cfgNode, t reflect.Type, path string) error {
+	invalid := func(expected string) error {
+		return node.error("invalid value of " + fieldName(path) +
+			": expected " + expected + ".")
+	}
+
+	if node.kind == nodeNull {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			return nil
+		}
+		return node.error(fieldName(path) + " can't be null.")
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == durationType {
+		if node.kind == nodeString {
+			if _, err := time.ParseDuration(node.scalar.(string)); err == nil {
+				return nil
+			}
+		}
+		if node.kind != nodeNumber {
+			return invalid(`a number of seconds or a duration string ("30s", "2m")`)
+		}
+		return nil
+	}
+	if unlimited, ok := limitTypes[t]; ok {
+		if node.kind == nodeString && node.scalar.(string) == unlimited {
+			return nil
+		}
+		expected := `a non-negative integer or "` + unlimited + `"`
+		if node.kind != nodeNumber {
+			return invalid(expected)
+		}
+		number := node.scalar.(json.Number)
+		if _, err := strconv.ParseUint(string(number), 10, 64); err != nil {
+			return invalid(expected)
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.kind != nodeObject {
+			return invalid("an object")
+		}
+		fields := jsonFields(t)
+		for _, field := range node.fields {
+			fieldPath := joinPath(path, field.name)
+			fieldType, ok := fields[field.name]
+			if !ok {
+				return &CfgError{Line: field.line, Column: field.column,
+					Msg: "unknown field " + fieldName(fieldPath) + "."}
+			}
+			if err := checkCfgNode(field.value, fieldType, fieldPath); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if node.kind != nodeObject {
+			return invalid("an object")
+		}
+		for _, field := range node.fields {
+			err := checkCfgNode(field.value, t.Elem(), joinPath(path, field.name))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if node.kind != nodeArray {
+			return invalid("an array")
+		}
+		for i, item := range node.items {
+			err := checkCfgNode(item, t.Elem(), path+"["+strconv.Itoa(i)+"]")
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		if node.kind != nodeString {
+			return invalid("a string")
+		}
+	case reflect.Bool:
+		if node.kind != nodeBool {
+			return invalid("a boolean")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if node.kind != nodeNumber {
+			return invalid("an integer")
+		}
+		number := node.scalar.(json.Number)
+		if _, err := strconv.ParseInt(string(number), 10, t.Bits()); err != nil {
+			return invalid("an integer")
+		}
+	case reflect.Float32, reflect.Float64:
+		if node.kind != nodeNumber {
+			return invalid("a number")
+		}
+	case reflect.Interface:
+	default:
+		return errors.New("Unsupported type of " + fieldName(path) + ".")
+	}
+	return nil
+}
+
+// joinPath returns the path of the field of the object.
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// jsonFields returns a map of the JSON names of the struct fields
+// (including the fields of the embedded structs) to their types.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for name, fieldType := range jsonFields(field.Type) {
+				fields[name] = fieldType
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			// Unexported field.
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
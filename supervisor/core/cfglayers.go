@@ -0,0 +1,236 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Config sources.
+const (
+	// CfgSourceDefault - the default value.
+	CfgSourceDefault = "default"
+	// CfgSourceFile - the config file.
+	CfgSourceFile = "file"
+	// CfgSourceEnv - an environment variable.
+	CfgSourceEnv = "env"
+	// CfgSourceFlag - a command-line flag.
+	CfgSourceFlag = "flag"
+)
+
+// CfgEnvPrefix is the prefix of the environment
+// variables that override the config fields.
+const CfgEnvPrefix = "TVISOR_"
+
+// CfgField describes a field of the config that can be set in
+// every layer (see LoadCfg). The fields of the nested objects
+// ("restart", "log") are separate fields.
+type CfgField struct {
+	// Path is the path of the field in the config
+	// (for example: "restart.backoff_initial").
+	Path string
+	// Env is the name of the environment variable that overrides
+	// the field (for example: "TVISOR_RESTART_BACKOFF_INITIAL").
+	Env string
+	// IsBool indicates that the field is a boolean.
+	IsBool bool
+	// fieldType is the type of the field.
+	fieldType reflect.Type
+}
+
+// CfgFields returns the fields of the config in the order of the Cfg.
+func CfgFields() []CfgField {
+	return cfgFields(reflect.TypeOf(Cfg{}), "")
+}
+
+// cfgFields returns the fields of the struct.
+func cfgFields(t reflect.Type, prefix string) []CfgField {
+	var fields []CfgField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		path := joinPath(prefix, name)
+		if field.Type.Kind() == reflect.Struct {
+			fields = append(fields, cfgFields(field.Type, path)...)
+			continue
+		}
+		fields = append(fields, CfgField{
+			Path:      path,
+			Env:       CfgEnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_")),
+			IsBool:    field.Type.Kind() == reflect.Bool,
+			fieldType: field.Type,
+		})
+	}
+	return fields
+}
+
+// parse converts the value of the environment variable or the flag to
+// the config node. Strings are used as is, arrays and objects are set
+// in JSON.
+func (field *CfgField) parse(value string) (*cfgNode, error) {
+	node := &cfgNode{line: 1, column: 1}
+	t := field.fieldType
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		node.kind, node.scalar = nodeString, value
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("expected a boolean.")
+		}
+		node.kind, node.scalar = nodeBool, b
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Float32, reflect.Float64:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			// A duration string.
+			node.kind, node.scalar = nodeString, value
+		} else {
+			node.kind, node.scalar = nodeNumber, json.Number(value)
+		}
+	default:
+		return parseJSONCfg([]byte(value))
+	}
+	return node, nil
+}
+
+// set decodes the value of the field into the cfg.
+func (field *CfgField) set(cfg *Cfg, value string) error {
+	valueNode, err := field.parse(value)
+	if err != nil {
+		return err
+	}
+
+	// Wrap the value by the objects of the path.
+	node := valueNode
+	parts := strings.Split(field.Path, ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		node = &cfgNode{kind: nodeObject, line: 1, column: 1,
+			fields: []*cfgField{{name: parts[i], line: 1, column: 1, value: node}}}
+	}
+	if err := checkCfgNode(node, reflect.TypeOf(cfg).Elem(), ""); err != nil {
+		// The position is useful only for the values set in JSON.
+		if cfgErr, ok := err.(*CfgError); ok && valueNode.kind != nodeObject &&
+			valueNode.kind != nodeArray {
+			return errors.New(cfgErr.Msg)
+		}
+		return err
+	}
+	return decodeCfgNode(node, cfg)
+}
+
+// CfgSources is a map of a path of the config field to
+// the source of its value (see config sources). The name
+// of the variable or the flag is added to the source:
+// "env TVISOR_LOGS_DIR", "flag -logs_dir".
+type CfgSources map[string]string
+
+// CfgLayers describes the layers of the config. Each layer
+// overrides the values of the previous ones.
+type CfgLayers struct {
+	// Defaults - the default values.
+	Defaults Cfg
+	// Path - the path to the config file. An empty value
+	// means that there is no config file.
+	Path string
+	// Env - the environment in the "key=value" form. Only the
+	// variables with the CfgEnvPrefix are used.
+	Env []string
+	// Flags - a map of the path of the field to its value.
+	Flags map[string]string
+}
+
+// LoadCfg loads the config from the layers: defaults, the config file,
+// the environment variables and the flags. Returns the loaded config
+// and the sources of the values. The config isn't validated (see
+// Cfg.Validate).
+func LoadCfg(layers *CfgLayers) (*Cfg, CfgSources, error) {
+	cfg := layers.Defaults
+	fields := CfgFields()
+	sources := make(CfgSources)
+	for _, field := range fields {
+		sources[field.Path] = CfgSourceDefault
+	}
+
+	if layers.Path != "" {
+		format, err := CfgFormatByPath(layers.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := ioutil.ReadFile(layers.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		root, err := decodeCfg(data, format, &cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, field := range fields {
+			if root.lookup(field.Path) != nil {
+				sources[field.Path] = CfgSourceFile
+			}
+		}
+	}
+
+	byEnv := make(map[string]*CfgField)
+	for i := range fields {
+		byEnv[fields[i].Env] = &fields[i]
+	}
+	for _, env := range layers.Env {
+		parts := strings.SplitN(env, "=", 2)
+		if !strings.HasPrefix(parts[0], CfgEnvPrefix) || len(parts) != 2 {
+			continue
+		}
+		field, ok := byEnv[parts[0]]
+		if !ok {
+			return nil, nil, errors.New(`Unknown environment variable "` + parts[0] + `".`)
+		}
+		if err := field.set(&cfg, parts[1]); err != nil {
+			return nil, nil, errors.New(`Invalid value of the environment variable "` +
+				parts[0] + `": ` + err.Error())
+		}
+		sources[field.Path] = CfgSourceEnv + " " + parts[0]
+	}
+
+	for _, field := range fields {
+		value, ok := layers.Flags[field.Path]
+		if !ok {
+			continue
+		}
+		if err := field.set(&cfg, value); err != nil {
+			return nil, nil, errors.New(`Invalid value of the flag "-` +
+				field.Path + `": ` + err.Error())
+		}
+		sources[field.Path] = CfgSourceFlag + " -" + field.Path
+	}
+	for path := range layers.Flags {
+		if _, ok := sources[path]; !ok {
+			return nil, nil, errors.New(`Unknown config field "` + path + `".`)
+		}
+	}
+
+	return &cfg, sources, nil
+}
+
+// lookup returns the node by the path of the field (nil if there is no node).
+func (node *cfgNode) lookup(path string) *cfgNode {
+	for _, name := range strings.Split(path, ".") {
+		if node.kind != nodeObject {
+			return nil
+		}
+		field := node.field(name)
+		if field == nil {
+			return nil
+		}
+		node = field.value
+	}
+	return node
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadCfg checks that the layers of the config override each other.
+func TestLoadCfg(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "cfg.yaml")
+	cfgStr := "logs_dir: /file/logs\nrestart:\n  max_restarts: 3\n  window: 1m\n" +
+		"log:\n  max_size: 5\n"
+	if err := ioutil.WriteFile(path, []byte(cfgStr), 0644); err != nil {
+		t.Fatalf(`Can't write test cfg to file: "%v".`, err)
+	}
+
+	layers := CfgLayers{
+		Defaults: Cfg{
+			InstancesDir: "/default/instances",
+			LogsDir:      "/default/logs",
+			TermTimeout:  Duration(time.Second),
+			Log:          LogCfg{MaxSize: 100, Compress: true},
+		},
+		Path: path,
+		Env: []string{
+			"HOME=/root",
+			"TVISOR_TERMINATION_TIMEOUT=2m",
+			"TVISOR_RESTART_MAX_RESTARTS=7",
+			"TVISOR_LOG_MAX_SIZE=10",
+			`TVISOR_INSTANCES=[{"name": "app", "env": ["A=1"]}]`,
+		},
+		Flags: map[string]string{
+			"log.max_size": "20",
+			"log.compress": "false",
+		},
+	}
+	cfg, sources, err := LoadCfg(&layers)
+	if !assert.Nilf(err, `Failed to load the config. Error: "%v".`, err) {
+		return
+	}
+
+	assert.Equal("/default/instances", cfg.InstancesDir)
+	assert.Equal(CfgSourceDefault, sources["instances_dir"])
+	assert.Equal("/file/logs", cfg.LogsDir)
+	assert.Equal(CfgSourceFile, sources["logs_dir"])
+	assert.Equal(Duration(time.Minute), cfg.Restart.Window)
+	assert.Equal(CfgSourceFile, sources["restart.window"])
+	assert.Equal(Duration(2*time.Minute), cfg.TermTimeout)
+	assert.Equal("env TVISOR_TERMINATION_TIMEOUT", sources["termination_timeout"])
+	assert.Equal(7, cfg.Restart.MaxRestarts)
+	assert.Equal("env TVISOR_RESTART_MAX_RESTARTS", sources["restart.max_restarts"])
+	assert.Equal(20, cfg.Log.MaxSize)
+	assert.Equal("flag -log.max_size", sources["log.max_size"])
+	assert.False(cfg.Log.Compress)
+	if assert.Len(cfg.Instances, 1) {
+		assert.Equal("app", cfg.Instances[0].Name)
+		assert.Equal([]string{"A=1"}, cfg.Instances[0].Env)
+	}
+	assert.Equal("env TVISOR_INSTANCES", sources["instances"])
+
+	// Each field is reachable in every layer.
+	for _, field := range CfgFields() {
+		assert.Contains(sources, field.Path)
+	}
+	// The defaults aren't changed.
+	assert.Equal(100, layers.Defaults.Log.MaxSize)
+
+	// The config file is optional.
+	layers.Path = ""
+	cfg, sources, err = LoadCfg(&layers)
+	if assert.Nil(err) {
+		assert.Equal("/default/logs", cfg.LogsDir)
+		assert.Equal(CfgSourceDefault, sources["logs_dir"])
+	}
+
+	// Invalid values.
+	errs := []struct {
+		env   string
+		flags map[string]string
+		err   string
+	}{
+		{env: "TVISOR_UNKNOWN=1", err: `Unknown environment variable "TVISOR_UNKNOWN".`},
+		{env: "TVISOR_LOG_MAX_SIZE=big",
+			err: `Invalid value of the environment variable "TVISOR_LOG_MAX_SIZE": ` +
+				`invalid value of "log.max_size": expected an integer.`},
+		{env: "TVISOR_INSTANCES={",
+			err: `Invalid value of the environment variable "TVISOR_INSTANCES": Line 1`},
+		{flags: map[string]string{"termination_timeout": "soon"},
+			err: `Invalid value of the flag "-termination_timeout": invalid value ` +
+				`of "termination_timeout": expected a number of seconds or a duration string`},
+		{flags: map[string]string{"log.compress": "maybe"},
+			err: `Invalid value of the flag "-log.compress": expected a boolean.`},
+		{flags: map[string]string{"restart": "{}"},
+			err: `Unknown config field "restart".`},
+	}
+	for _, c := range errs {
+		layers := CfgLayers{Flags: c.flags}
+		if c.env != "" {
+			layers.Env = []string{c.env}
+		}
+		_, _, err := LoadCfg(&layers)
+		if assert.NotNilf(err, "The layers are valid: %v %v", c.env, c.flags) {
+			assert.Contains(err.Error(), c.err)
+		}
+	}
+}
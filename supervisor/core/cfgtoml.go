@@ -0,0 +1,256 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+)
+
+// tomlPositions describes the offsets of the keys and the values of the
+// TOML config by their paths (see tomlPath). The decoded config doesn't
+// contain the positions, so they are found by the parser of the library.
+type tomlPositions struct {
+	// keys are the offsets of the keys (the first definition).
+	keys map[string]int
+	// values are the offsets of the values.
+	values map[string]int
+	// arrays are the numbers of the tables of the arrays
+	// defined by "[[table]]" headers.
+	arrays map[string]int
+}
+
+// tomlPath returns the path of the field or the item (by its index)
+// of the value with the parent path. The path of the root is "".
+func tomlPath(parent string, name string) string {
+	return parent + "\x00" + name
+}
+
+// parseTOMLCfg parses the TOML config. The config is decoded by the library
+// that checks it, then it is converted to the nodes with the positions.
+func parseTOMLCfg(data []byte) (*cfgNode, error) {
+	var doc map[string]interface{}
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		var line, column int
+		var decodeErr *toml.DecodeError
+		if errors.As(err, &decodeErr) {
+			line, column = decodeErr.Position()
+		} else {
+			line, column = tomlErrorPosition(data)
+		}
+		return nil, &CfgError{Line: line, Column: column,
+			Msg: "invalid TOML: " + strings.TrimPrefix(err.Error(), "toml: ") + "."}
+	}
+	positions, err := findTOMLPositions(data)
+	if err != nil {
+		return nil, errors.New("Invalid TOML: " + err.Error())
+	}
+	return positions.toCfgNode(data, "", doc)
+}
+
+// tomlErrorPosition returns the position of the expression at which the
+// config can't be decoded. The errors of the structure of the config (for
+// example, a key defined twice) are reported by the library without the
+// position, so the config is decoded up to each expression in turn.
+func tomlErrorPosition(data []byte) (int, int) {
+	var parser unstable.Parser
+	parser.Reset(data)
+	var starts []int
+	for parser.NextExpression() {
+		expr := parser.Expression()
+		if expr.Kind == unstable.KeyValue || expr.Kind == unstable.Table ||
+			expr.Kind == unstable.ArrayTable {
+			keys := expr.Key()
+			keys.Next()
+			starts = append(starts, int(keys.Node().Raw.Offset))
+		}
+	}
+	for i, start := range starts {
+		// Each expression starts on a new line.
+		end := len(data)
+		if i+1 < len(starts) {
+			end = bytes.LastIndexByte(data[:starts[i+1]], '\n') + 1
+		}
+		var doc map[string]interface{}
+		if err := toml.Unmarshal(data[:end], &doc); err != nil {
+			return offsetPosition(data, int64(start))
+		}
+	}
+	return 1, 1
+}
+
+// findTOMLPositions finds the positions of the keys and the values.
+func findTOMLPositions(data []byte) (*tomlPositions, error) {
+	positions := tomlPositions{keys: make(map[string]int),
+		values: make(map[string]int), arrays: make(map[string]int)}
+	var parser unstable.Parser
+	parser.Reset(data)
+	table := ""
+	for parser.NextExpression() {
+		expr := parser.Expression()
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			table = ""
+			for keys := expr.Key(); keys.Next(); {
+				key := keys.Node()
+				table = tomlPath(table, string(key.Data))
+				positions.setFirst(table, int(key.Raw.Offset))
+				if keys.IsLast() && expr.Kind == unstable.ArrayTable {
+					// The header of an array adds a new table to it.
+					index := positions.arrays[table]
+					positions.arrays[table]++
+					table = tomlPath(table, strconv.Itoa(index))
+					positions.values[table] = int(key.Raw.Offset)
+				} else {
+					table = positions.resolveArray(table)
+				}
+			}
+		case unstable.KeyValue:
+			positions.addKeyValue(&parser, table, expr)
+		}
+	}
+	return &positions, parser.Error()
+}
+
+// setFirst sets the position of the key and its value if it
+// hasn't been defined before (for example, by a dotted key).
+func (positions *tomlPositions) setFirst(path string, offset int) {
+	if _, ok := positions.keys[path]; !ok {
+		positions.keys[path] = offset
+		positions.values[path] = offset
+	}
+}
+
+// resolveArray returns the path of the last table of the array of tables
+// (the path itself if it isn't an array of tables).
+func (positions *tomlPositions) resolveArray(path string) string {
+	if count := positions.arrays[path]; count != 0 {
+		return tomlPath(path, strconv.Itoa(count-1))
+	}
+	return path
+}
+
+// addKeyValue adds the positions of the "key = value" pair of the table.
+func (positions *tomlPositions) addKeyValue(parser *unstable.Parser, table string,
+	expr *unstable.Node) {
+	path := table
+	keyEnd := 0
+	for keys := expr.Key(); keys.Next(); {
+		key := keys.Node()
+		path = tomlPath(path, string(key.Data))
+		positions.setFirst(path, int(key.Raw.Offset))
+		keyEnd = int(key.Raw.Offset + key.Raw.Length)
+	}
+	// Not all the values have the position, but they follow "=".
+	data := parser.Data()
+	offset := len(data) - len(bytes.TrimLeft(data[keyEnd:], " \t="))
+	positions.addValue(parser, path, expr.Value(), offset)
+}
+
+// addValue adds the positions of the value and its items or fields.
+// offset - the offset of the value if the node doesn't describe it.
+func (positions *tomlPositions) addValue(parser *unstable.Parser, path string,
+	value *unstable.Node, offset int) {
+	if value.Raw.Length != 0 {
+		offset = int(value.Raw.Offset)
+	} else if value.Kind == unstable.Bool {
+		offset = int(parser.Range(value.Data).Offset)
+	}
+	positions.values[path] = offset
+
+	switch value.Kind {
+	case unstable.Array:
+		index := 0
+		for items := value.Children(); items.Next(); {
+			if item := items.Node(); item.Kind != unstable.Comment {
+				positions.addValue(parser, tomlPath(path, strconv.Itoa(index)), item, offset)
+				index++
+			}
+		}
+	case unstable.InlineTable:
+		for fields := value.Children(); fields.Next(); {
+			if field := fields.Node(); field.Kind == unstable.KeyValue {
+				positions.addKeyValue(parser, path, field)
+			}
+		}
+	}
+}
+
+// tomlPosition returns the line and the column of the offset (the first
+// column of the config if the offset is unknown).
+func tomlPosition(data []byte, offset int, ok bool) (int, int) {
+	if !ok {
+		return 1, 1
+	}
+	return offsetPosition(data, int64(offset))
+}
+
+// toCfgNode converts the decoded TOML value to the config node.
+// path - the path of the value.
+func (positions *tomlPositions) toCfgNode(data []byte, path string,
+	value interface{}) (*cfgNode, error) {
+	node := &cfgNode{}
+	offset, ok := positions.values[path]
+	node.line, node.column = tomlPosition(data, offset, ok)
+	switch value := value.(type) {
+	case nil:
+		// An empty config.
+		node.kind = nodeObject
+	case map[string]interface{}:
+		node.kind = nodeObject
+		for name, fieldValue := range value {
+			fieldPath := tomlPath(path, name)
+			offset, ok := positions.keys[fieldPath]
+			field := &cfgField{name: name}
+			field.line, field.column = tomlPosition(data, offset, ok)
+			var err error
+			if field.value, err = positions.toCfgNode(data, fieldPath, fieldValue); err != nil {
+				return nil, err
+			}
+			node.fields = append(node.fields, field)
+		}
+		// The fields are in the order of the config.
+		sort.Slice(node.fields, func(i, j int) bool {
+			a, b := node.fields[i], node.fields[j]
+			return a.line < b.line || a.line == b.line && a.column < b.column
+		})
+	case []interface{}:
+		node.kind = nodeArray
+		for i, itemValue := range value {
+			item, err := positions.toCfgNode(data, tomlPath(path, strconv.Itoa(i)), itemValue)
+			if err != nil {
+				return nil, err
+			}
+			node.items = append(node.items, item)
+		}
+	case string:
+		node.kind, node.scalar = nodeString, value
+	case bool:
+		node.kind, node.scalar = nodeBool, value
+	case int64:
+		node.kind, node.scalar = nodeNumber, json.Number(strconv.FormatInt(value, 10))
+	case float64:
+		if math.IsInf(value, 0) || math.IsNaN(value) {
+			return nil, node.error("infinity and NaN aren't supported.")
+		}
+		node.kind = nodeNumber
+		node.scalar = json.Number(strconv.FormatFloat(value, 'g', -1, 64))
+	case time.Time:
+		// Dates and times are strings as in YAML.
+		node.kind, node.scalar = nodeString, value.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		// Local dates and times.
+		node.kind, node.scalar = nodeString, value.String()
+	default:
+		return nil, node.error("unsupported TOML value.")
+	}
+	return node, nil
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseTOMLCfg checks the parsing of TOML.
+func TestParseTOMLCfg(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		toml string
+		json string
+	}{
+		{`a = "x\ty\u00e9\"" # Comment.`, `{"a": "x\ty\u00e9\""}`},
+		{`a = 'C:\path'`, `{"a": "C:\\path"}`},
+		{"a = \"\"\"\none\n  two\\\n    three\"\"\"", `{"a": "one\n  twothree"}`},
+		{"a = '''\nraw\\n'''", `{"a": "raw\\n"}`},
+		{`a = [1_000, -2, 0x1f, 0o7, 0b11, +3.5, 1e3, true, false]`,
+			`{"a": [1000, -2, 31, 7, 3, 3.5, 1000, true, false]}`},
+		{"a = [\n  1, # One.\n  2,\n]", `{"a": [1, 2]}`},
+		{`a.b."c.d" = 1`, `{"a": {"b": {"c.d": 1}}}`},
+		{`a = {b = 1, c.d = "x"}`, `{"a": {"b": 1, "c": {"d": "x"}}}`},
+		{"[a.b]\nc = 1\n[a]\nd = 2", `{"a": {"b": {"c": 1}, "d": 2}}`},
+		{"[[a]]\nb = 1\n[a.c]\nd = 2\n[[a]]\nb = 3", `{"a": [{"b": 1, "c": {"d": 2}}, {"b": 3}]}`},
+		{"\r\na = 1\r\n", `{"a": 1}`},
+		{"a = 1979-05-27T07:32:00Z\nb = 1979-05-27", `{"a": "1979-05-27T07:32:00Z", "b": "1979-05-27"}`},
+		{"", `{}`},
+	}
+	for _, c := range cases {
+		root, err := parseTOMLCfg([]byte(c.toml))
+		if !assert.Nilf(err, `Can't parse "%v". Error: "%v"`, c.toml, err) {
+			continue
+		}
+		data, err := json.Marshal(root.toJSON())
+		assert.Nil(err)
+		assert.JSONEq(c.json, string(data), c.toml)
+	}
+
+	// The errors of the syntax and of the structure have positions.
+	errCases := []struct {
+		toml string
+		err  string
+	}{
+		{"a = 1\na = 2", `Line 2, column 1: invalid TOML: key a is already defined.`},
+		{"[a]\n[a]", `Line 2, column 2: invalid TOML: table a already exists.`},
+		{"a = {b = 1}\na.c = 1", `Line 2, column 1: invalid TOML: ` +
+			`expected a to be a table, not a value.`},
+		{"a = 1 b = 2", `Line 1, column 7: invalid TOML: expected newline but got U+0062 'b'.`},
+		{"a = 01", `Line 1, column 5: invalid TOML: leading zero not allowed on decimal number.`},
+		{"a = inf", `Line 1, column 5: infinity and NaN aren't supported.`},
+		{"a = [1 2]", `Line 1, column 8: invalid TOML: array elements must be separated by commas.`},
+	}
+	for _, c := range errCases {
+		_, err := parseTOMLCfg([]byte(c.toml))
+		if assert.NotNilf(err, `"%v" is parsed.`, c.toml) {
+			assert.Equal(c.err, err.Error(), c.toml)
+		}
+	}
+}
+
+// TestTOMLPositions checks the positions of the TOML nodes.
+func TestTOMLPositions(t *testing.T) {
+	assert := assert.New(t)
+	root, err := parseTOMLCfg([]byte(`b = true
+a.c = [1, [2]]
+
+[[d]]
+e = {f = "x"}
+[d.g]
+h = 1
+[[d]]
+e = 2
+`))
+	if !assert.Nilf(err, `Can't parse the TOML. Error: "%v"`, err) {
+		return
+	}
+	pos := func(node *cfgNode) [2]int { return [2]int{node.line, node.column} }
+	value := func(node *cfgNode, name string) *cfgNode { return node.field(name).value }
+
+	// The fields are in the order of the config.
+	var names []string
+	for _, field := range root.fields {
+		names = append(names, field.name)
+	}
+	assert.Equal([]string{"b", "a", "d"}, names)
+	assert.Equal([2]int{1, 5}, pos(value(root, "b")))
+	assert.Equal(2, root.field("a").line)
+	assert.Equal(3, root.field("a").value.field("c").column)
+	c := value(value(root, "a"), "c")
+	assert.Equal([2]int{2, 7}, pos(c))
+	assert.Equal([2]int{2, 8}, pos(c.items[0]))
+
+	d := value(root, "d")
+	if assert.Len(d.items, 2) {
+		assert.Equal([2]int{4, 3}, pos(d.items[0]))
+		assert.Equal([2]int{5, 5}, pos(value(d.items[0], "e")))
+		assert.Equal([2]int{5, 10}, pos(value(value(d.items[0], "e"), "f")))
+		assert.Equal([2]int{7, 5}, pos(value(value(d.items[0], "g"), "h")))
+		assert.Equal([2]int{8, 3}, pos(d.items[1]))
+		assert.Equal([2]int{9, 5}, pos(value(d.items[1], "e")))
+	}
+}
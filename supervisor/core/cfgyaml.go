@@ -0,0 +1,125 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlMergeKey is the key used to merge mappings ("<<: *anchor").
+const yamlMergeKey = "<<"
+
+// parseYAMLCfg parses the YAML config. An empty config is an empty object.
+func parseYAMLCfg(data []byte) (*cfgNode, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.New("Invalid YAML: " + err.Error())
+	}
+	if len(doc.Content) == 0 {
+		return &cfgNode{kind: nodeObject, line: 1, column: 1}, nil
+	}
+	return yamlToCfgNode(doc.Content[0])
+}
+
+// yamlToCfgNode converts the YAML node to the config node.
+func yamlToCfgNode(yamlNode *yaml.Node) (*cfgNode, error) {
+	node := &cfgNode{line: yamlNode.Line, column: yamlNode.Column}
+	switch yamlNode.Kind {
+	case yaml.AliasNode:
+		return yamlToCfgNode(yamlNode.Alias)
+	case yaml.MappingNode:
+		node.kind = nodeObject
+		// The merged fields are overridden by the fields of the mapping.
+		var fields []*cfgField
+		for i := 0; i+1 < len(yamlNode.Content); i += 2 {
+			key, value := yamlNode.Content[i], yamlNode.Content[i+1]
+			if key.Kind != yaml.ScalarNode {
+				return nil, node.error("the key of a mapping should be a scalar.")
+			}
+			if key.Value == yamlMergeKey && key.ShortTag() == "!!merge" {
+				merged, err := yamlMergedFields(value)
+				if err != nil {
+					return nil, err
+				}
+				node.fields = append(node.fields, merged...)
+				continue
+			}
+			field := &cfgField{name: key.Value, line: key.Line, column: key.Column}
+			var err error
+			if field.value, err = yamlToCfgNode(value); err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+		}
+		node.fields = append(node.fields, fields...)
+	case yaml.SequenceNode:
+		node.kind = nodeArray
+		for _, yamlItem := range yamlNode.Content {
+			item, err := yamlToCfgNode(yamlItem)
+			if err != nil {
+				return nil, err
+			}
+			node.items = append(node.items, item)
+		}
+	case yaml.ScalarNode:
+		switch yamlNode.ShortTag() {
+		case "!!null":
+			node.kind = nodeNull
+		case "!!bool":
+			var value bool
+			if err := yamlNode.Decode(&value); err != nil {
+				return nil, node.error(err.Error() + ".")
+			}
+			node.kind, node.scalar = nodeBool, value
+		case "!!int":
+			var value int64
+			if err := yamlNode.Decode(&value); err != nil {
+				return nil, node.error(err.Error() + ".")
+			}
+			node.kind, node.scalar = nodeNumber, json.Number(strconv.FormatInt(value, 10))
+		case "!!float":
+			var value float64
+			if err := yamlNode.Decode(&value); err != nil {
+				return nil, node.error(err.Error() + ".")
+			}
+			if math.IsInf(value, 0) || math.IsNaN(value) {
+				return nil, node.error("infinity and NaN aren't supported.")
+			}
+			node.kind = nodeNumber
+			node.scalar = json.Number(strconv.FormatFloat(value, 'g', -1, 64))
+		default:
+			// Strings, timestamps, binary data.
+			node.kind, node.scalar = nodeString, yamlNode.Value
+		}
+	default:
+		return nil, node.error("unsupported YAML node.")
+	}
+	return node, nil
+}
+
+// yamlMergedFields returns the fields of the mapping (or the sequence
+// of mappings) merged by the "<<" key.
+func yamlMergedFields(yamlNode *yaml.Node) ([]*cfgField, error) {
+	node, err := yamlToCfgNode(yamlNode)
+	if err != nil {
+		return nil, err
+	}
+	if node.kind == nodeObject {
+		return node.fields, nil
+	}
+	if node.kind != nodeArray {
+		return nil, node.error("only mappings can be merged.")
+	}
+	// The first mapping of the sequence has the highest priority.
+	var fields []*cfgField
+	for i := len(node.items) - 1; i >= 0; i-- {
+		if node.items[i].kind != nodeObject {
+			return nil, node.items[i].error("only mappings can be merged.")
+		}
+		fields = append(fields, node.items[i].fields...)
+	}
+	return fields, nil
+}
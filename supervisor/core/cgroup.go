@@ -0,0 +1,280 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupMax is the value of an unlimited resource in cgroup v2.
+const cgroupMax = "max"
+
+// cgroupCPUPeriod is the period of the CPU bandwidth limit (in microseconds).
+const cgroupCPUPeriod = 100000
+
+// cgroupControllers are the controllers enabled for the cgroups of the Instances.
+var cgroupControllers = []string{"memory", "cpu", "pids"}
+
+// CgroupLimit is a limit of a cgroup resource. It is set
+// as a number or "max" (both in JSON).
+type CgroupLimit uint64
+
+// UnmarshalJSON decodes the limit from a number or "max".
+func (limit *CgroupLimit) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		if str != cgroupMax {
+			return errors.New(`Invalid cgroup limit: "` + str + `".`)
+		}
+		*limit = CgroupLimit(^uint64(0))
+		return nil
+	}
+	var number uint64
+	if err := json.Unmarshal(data, &number); err != nil {
+		return errors.New("Invalid cgroup limit: " + string(data) + ".")
+	}
+	*limit = CgroupLimit(number)
+	return nil
+}
+
+// MarshalJSON encodes the limit as a number or "max".
+func (limit CgroupLimit) MarshalJSON() ([]byte, error) {
+	if uint64(limit) == ^uint64(0) {
+		return json.Marshal(cgroupMax)
+	}
+	return json.Marshal(uint64(limit))
+}
+
+// String returns the value of the limit for the cgroup interface file.
+func (limit *CgroupLimit) String() string {
+	if limit == nil || uint64(*limit) == ^uint64(0) {
+		return cgroupMax
+	}
+	return strconv.FormatUint(uint64(*limit), 10)
+}
+
+// CgroupSpec describes the limits of the cgroup of an Instance.
+// The limits that aren't set are "max".
+type CgroupSpec struct {
+	// MemoryMax - the memory usage hard limit in bytes ("memory.max").
+	// The processes of the cgroup are killed by the OOM killer if it
+	// is exceeded.
+	MemoryMax *CgroupLimit `json:"memory_max,omitempty" mapstructure:"memory_max"`
+	// MemoryHigh - the memory usage throttle limit in bytes ("memory.high").
+	MemoryHigh *CgroupLimit `json:"memory_high,omitempty" mapstructure:"memory_high"`
+	// CPUMax - the maximum CPU bandwidth in CPUs, for example,
+	// 1.5 ("cpu.max"). 0 - unlimited.
+	CPUMax float64 `json:"cpu_max,omitempty" mapstructure:"cpu_max"`
+	// CPUWeight - the relative share of the CPU time in the range
+	// [1, 10000] ("cpu.weight"). 0 - the default (100).
+	CPUWeight int `json:"cpu_weight,omitempty" mapstructure:"cpu_weight"`
+	// PidsMax - the maximum number of processes ("pids.max").
+	PidsMax *CgroupLimit `json:"pids_max,omitempty" mapstructure:"pids_max"`
+}
+
+// validate checks the cgroup limits.
+func (spec *CgroupSpec) validate() error {
+	if spec.CPUMax < 0 {
+		return errors.New(`The "cpu_max" of the cgroup can't be negative.`)
+	}
+	if spec.CPUMax > 0 && spec.CPUMax*cgroupCPUPeriod < 1000 {
+		// The minimum quota is 1ms.
+		return errors.New(`The "cpu_max" of the cgroup is too small.`)
+	}
+	if spec.CPUWeight < 0 || spec.CPUWeight > 10000 {
+		return errors.New(`The "cpu_weight" of the cgroup should be in the range [1, 10000].`)
+	}
+	return nil
+}
+
+// files returns a map of the cgroup interface file to its value.
+func (spec *CgroupSpec) files() map[string]string {
+	if spec == nil {
+		spec = &CgroupSpec{}
+	}
+	cpuMax := cgroupMax
+	if spec.CPUMax > 0 {
+		cpuMax = strconv.Itoa(int(spec.CPUMax*cgroupCPUPeriod)) + " " +
+			strconv.Itoa(cgroupCPUPeriod)
+	}
+	cpuWeight := 100
+	if spec.CPUWeight > 0 {
+		cpuWeight = spec.CPUWeight
+	}
+	return map[string]string{
+		"memory.max":  spec.MemoryMax.String(),
+		"memory.high": spec.MemoryHigh.String(),
+		"cpu.max":     cpuMax,
+		"cpu.weight":  strconv.Itoa(cpuWeight),
+		"pids.max":    spec.PidsMax.String(),
+	}
+}
+
+// CgroupStatus describes the cgroup of an Instance.
+type CgroupStatus struct {
+	// Path is the path to the cgroup.
+	Path string `json:"path"`
+	// Error describes why the Instance isn't placed in the cgroup
+	// or why its usage can't be read.
+	Error string `json:"error,omitempty"`
+	// MemoryCurrent is the current memory usage in bytes.
+	MemoryCurrent int64 `json:"memory_current"`
+	// CPUUsage is the total CPU time in seconds.
+	CPUUsage float64 `json:"cpu_usage"`
+	// PidsCurrent is the current number of processes.
+	PidsCurrent int64 `json:"pids_current"`
+	// OOMKills is the number of processes killed by the OOM killer.
+	OOMKills int64 `json:"oom_kills"`
+}
+
+// writeCgroupFile writes the value to the cgroup interface file.
+func writeCgroupFile(path string, name string, value string) error {
+	err := ioutil.WriteFile(filepath.Join(path, name), []byte(value), 0644)
+	if err != nil {
+		return errors.New(`Can't write "` + value + `" to "` + name + `": ` +
+			err.Error() + ".")
+	}
+	return nil
+}
+
+// prepareCgroup creates the cgroup (if it doesn't exist) and applies the
+// limits. The parent of the cgroup should be a cgroup v2 directory.
+func prepareCgroup(path string, spec *CgroupSpec) error {
+	parent := filepath.Dir(path)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	// This file exists only in cgroup v2.
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); err != nil {
+		return errors.New(`"` + parent + `" isn't a cgroup v2 directory.`)
+	}
+	controllers := "+" + strings.Join(cgroupControllers, " +")
+	if err := writeCgroupFile(parent, "cgroup.subtree_control", controllers); err != nil {
+		return err
+	}
+	if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	// The cgroup could be used by the previous process with another spec.
+	for name, value := range spec.files() {
+		if err := writeCgroupFile(path, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enterCgroup moves the current process to the cgroup.
+func enterCgroup(path string) error {
+	return writeCgroupFile(path, "cgroup.procs", strconv.Itoa(os.Getpid()))
+}
+
+// readCgroupValue reads the number from the cgroup interface file.
+// Returns 0 if the file doesn't exist (the controller isn't enabled).
+func readCgroupValue(path string, name string) (int64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, name))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readCgroupKey reads the value of the key from the flat keyed cgroup
+// interface file ("key value" lines). Returns 0 if there is no such key.
+func readCgroupKey(path string, name string, key string) (int64, error) {
+	file, err := os.Open(filepath.Join(path, name))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// readCgroupStatus reads the current usage of the cgroup.
+func readCgroupStatus(path string, status *CgroupStatus) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	var err error
+	if status.MemoryCurrent, err = readCgroupValue(path, "memory.current"); err != nil {
+		return err
+	}
+	if status.PidsCurrent, err = readCgroupValue(path, "pids.current"); err != nil {
+		return err
+	}
+	if status.OOMKills, err = readCgroupKey(path, "memory.events", "oom_kill"); err != nil {
+		return err
+	}
+	usage, err := readCgroupKey(path, "cpu.stat", "usage_usec")
+	if err != nil {
+		return err
+	}
+	status.CPUUsage = float64(usage) / 1e6
+	return nil
+}
+
+// setupCgroup prepares the cgroup of the Instance (see Cfg.CgroupParent).
+// Returns the path to the cgroup in which the process should be placed
+// ("" - none). If the cgroup can't be prepared, the process is started
+// without it, unless the Instance has the cgroup limits. The "infoMutex"
+// should be locked.
+func (inst *Instance) setupCgroup() (string, error) {
+	inst.cgroupErr = ""
+	if inst.cgroupPath == "" {
+		if inst.Spec.Cgroup != nil {
+			return "", errors.New(`The cgroup limits are set, but the cgroups ` +
+				`are disabled (see "cgroup_parent").`)
+		}
+		return "", nil
+	}
+	if err := prepareCgroup(inst.cgroupPath, inst.Spec.Cgroup); err != nil {
+		err = errors.New(`Can't set up the cgroup "` + inst.cgroupPath + `": ` +
+			err.Error())
+		if inst.Spec.Cgroup != nil {
+			return "", err
+		}
+		inst.cgroupErr = err.Error()
+		return "", nil
+	}
+	return inst.cgroupPath, nil
+}
+
+// removeCgroup removes the cgroup of the stopped Instance. The
+// cgroup can't be removed while it contains processes.
+func (inst *Instance) removeCgroup() {
+	if inst.cgroupPath != "" {
+		os.Remove(inst.cgroupPath)
+	}
+}
+
+// cgroupStatus returns the status of the cgroup of the Instance
+// (nil if the cgroups are disabled).
+func (inst *Instance) cgroupStatus(cgroupErr string) *CgroupStatus {
+	if inst.cgroupPath == "" {
+		return nil
+	}
+	status := CgroupStatus{Path: inst.cgroupPath, Error: cgroupErr}
+	if cgroupErr == "" {
+		if err := readCgroupStatus(inst.cgroupPath, &status); err != nil {
+			status.Error = "Can't read the usage of the cgroup: " + err.Error()
+		}
+	}
+	return &status
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCgroupSpec checks the values written to the cgroup interface files.
+func TestCgroupSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	memory, pids := CgroupLimit(1<<30), CgroupLimit(^uint64(0))
+	spec := CgroupSpec{MemoryMax: &memory, CPUMax: 1.5, CPUWeight: 200, PidsMax: &pids}
+	assert.Nil(spec.validate())
+	assert.Equal(map[string]string{
+		"memory.max":  "1073741824",
+		"memory.high": "max",
+		"cpu.max":     "150000 100000",
+		"cpu.weight":  "200",
+		"pids.max":    "max",
+	}, spec.files())
+
+	// The limits of the previous process are reset.
+	var empty *CgroupSpec
+	assert.Equal("max", empty.files()["cpu.max"])
+	assert.Equal("100", empty.files()["cpu.weight"])
+
+	assert.NotNil((&CgroupSpec{CPUWeight: 10001}).validate())
+	assert.NotNil((&CgroupSpec{CPUMax: -1}).validate())
+	assert.NotNil((&CgroupSpec{CPUMax: 0.001}).validate())
+}
+
+// readTestFile returns the content of the file.
+func readTestFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf(`Can't read "%v". Error: "%v"`, path, err)
+	}
+	return string(data)
+}
+
+// Test the cgroups of the Instances. A regular directory is used
+// as the parent cgroup, so the kernel doesn't apply the limits.
+func TestSupervisorCgroup(t *testing.T) {
+	assert := assert.New(t)
+	parent := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(parent, "cgroup.controllers"),
+		[]byte("cpu memory pids\n"), 0644); err != nil {
+		t.Fatalf(`Can't create the test cgroup. Error: "%v"`, err)
+	}
+
+	sv := newTestSupervisor(t, func(cfg *Cfg) { cfg.CgroupParent = parent })
+
+	memory := CgroupLimit(1 << 30)
+	id, err := sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance",
+		Cgroup: &CgroupSpec{MemoryMax: &memory, CPUMax: 0.5}})
+	if !assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err) {
+		return
+	}
+	pid := sv.getInstance(id).Pid()
+	waitExec(t, pid, testInstPath)
+
+	path := filepath.Join(parent, "test_instance-"+strconv.Itoa(id))
+	assert.Equal("+memory +cpu +pids", readTestFile(t,
+		filepath.Join(parent, "cgroup.subtree_control")))
+	assert.Equal(strconv.Itoa(pid), readTestFile(t, filepath.Join(path, "cgroup.procs")))
+	assert.Equal("1073741824", readTestFile(t, filepath.Join(path, "memory.max")))
+	assert.Equal("50000 100000", readTestFile(t, filepath.Join(path, "cpu.max")))
+	assert.Equal("max", readTestFile(t, filepath.Join(path, "pids.max")))
+
+	// The usage is read from the cgroup.
+	usage := map[string]string{
+		"memory.current": "4096\n",
+		"pids.current":   "2\n",
+		"memory.events":  "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+		"cpu.stat":       "usage_usec 1500000\nuser_usec 1000000\n",
+	}
+	for name, value := range usage {
+		ioutil.WriteFile(filepath.Join(path, name), []byte(value), 0644)
+	}
+	status, err := sv.GetInstanceStatus(id)
+	if assert.Nil(err) && assert.NotNil(status.Cgroup) {
+		assert.Equal(CgroupStatus{Path: path, MemoryCurrent: 4096, CPUUsage: 1.5,
+			PidsCurrent: 2, OOMKills: 1}, *status.Cgroup)
+	}
+
+	// The config isn't changed, it is replaced.
+	setCgroupParent := func(parent string) {
+		cfg := *sv.config()
+		cfg.CgroupParent = parent
+		sv.cfgMutex.Lock()
+		sv.cfg = &cfg
+		sv.cfgMutex.Unlock()
+	}
+
+	// The Instance can't be started if the cgroup
+	// can't be set up and it has the cgroup limits.
+	setCgroupParent(filepath.Join(t.TempDir(), "tvisor"))
+	_, err = sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance",
+		Cgroup: &CgroupSpec{CPUWeight: 10}})
+	if assert.NotNil(err, "The Instance has been started without the cgroup.") {
+		assert.Contains(err.Error(), "isn't a cgroup v2 directory.")
+	}
+
+	// Otherwise, it is started without the cgroup.
+	id, err = sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance"})
+	if assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err) {
+		status, _ := sv.GetInstanceStatus(id)
+		if assert.NotNil(status.Cgroup) {
+			assert.True(strings.HasPrefix(status.Cgroup.Error, "Can't set up the cgroup"),
+				"Unexpected error: %v", status.Cgroup.Error)
+		}
+		waitSignalHandlers(t, status.Pid)
+	}
+
+	// The cgroups are disabled.
+	setCgroupParent("")
+	_, err = sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance",
+		Cgroup: &CgroupSpec{CPUWeight: 10}})
+	assert.NotNil(err, "The Instance has been started without the cgroup.")
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// childSetupName is the name (argv[0]) with which the executable of
+// the Supervisor is run to set up the process of an Instance.
+const childSetupName = "tvisor-child-setup"
+
+// childSetupExitCode is the exit code of the process
+// if it can't be set up or the Instance can't be executed.
+const childSetupExitCode = 126
+
+// The capabilities required to apply the settings (see capabilities(7)).
+const (
+	capSetgid      = 6
+	capSetuid      = 7
+	capSysResource = 24
+)
+
+// selfExePath is the path to the executable of the current process.
+const selfExePath = "/proc/self/exe"
+
+// childSetup describes the settings applied in the child process before
+// the Instance is executed. Go doesn't allow to run code between fork and
+// exec, so the executable of the Supervisor is run in the child process
+// first: it applies the settings and replaces itself with the Instance
+// (the PID isn't changed).
+type childSetup struct {
+	// Rlimits describes the resource limits of the process.
+	Rlimits *RlimitsSpec `json:"rlimits,omitempty"`
+	// Cgroup is the path to the cgroup in which the process is placed.
+	Cgroup string `json:"cgroup,omitempty"`
+	// Credential is the user and the groups of the process. They are
+	// changed after the other settings are applied, as it could require
+	// the privileges.
+	Credential *syscall.Credential `json:"credential,omitempty"`
+	// Umask is the file mode creation mask of the process.
+	Umask *int `json:"umask,omitempty"`
+	// DeathSignal is the signal sent to the process when the Supervisor
+	// terminates (0 - none). It is reset by the change of the credential,
+	// so it is set again.
+	DeathSignal syscall.Signal `json:"death_signal,omitempty"`
+}
+
+// newChildSetup returns the settings of the Instance that should be
+// applied in the child process (nil if there is nothing to apply).
+// cgroup - the path to the cgroup of the Instance ("" - none).
+// cred - the credential of the Instance process (nil - inherited).
+// deathSignal - the signal sent to the process when the Supervisor
+// terminates (0 - none).
+func newChildSetup(spec *InstanceSpec, cgroup string, cred *syscall.Credential,
+	deathSignal syscall.Signal) *childSetup {
+	if spec.Rlimits == nil && cgroup == "" && spec.Umask == "" {
+		return nil
+	}
+	setup := childSetup{Rlimits: spec.Rlimits, Cgroup: cgroup, Credential: cred}
+	if cred != nil {
+		setup.DeathSignal = deathSignal
+	}
+	if spec.Umask != "" {
+		// The umask is checked by the validation of the spec.
+		umask, _ := parseUmask(spec.Umask)
+		setup.Umask = &umask
+	}
+	return &setup
+}
+
+// check verifies that the settings can be applied by the Supervisor
+// process with the capabilities. The child process can report its
+// errors only by the exit code, so the errors that can be found in
+// advance are reported on the start.
+func (setup *childSetup) check(capabilities uint64) error {
+	hasCapability := func(capability uint) bool {
+		return capabilities&(1<<capability) != 0
+	}
+	if setup.Rlimits != nil {
+		if err := setup.Rlimits.check(hasCapability(capSysResource)); err != nil {
+			return err
+		}
+	}
+	if cred := setup.Credential; cred != nil {
+		// The supplementary groups are always set.
+		if !hasCapability(capSetgid) {
+			return errors.New("The Supervisor can't change the groups of the " +
+				"Instance process without CAP_SETGID.")
+		}
+		if cred.Uid != uint32(os.Getuid()) && !hasCapability(capSetuid) {
+			return errors.New("The Supervisor can't change the user of the " +
+				"Instance process without CAP_SETUID.")
+		}
+	}
+	return nil
+}
+
+// wrapCmd makes the command apply the settings before executing the
+// Instance. The arguments are: childSetupName, the settings in JSON,
+// the path to the Instance and its original arguments.
+func (setup *childSetup) wrapCmd(cmd *exec.Cmd) error {
+	// The errors of the path are reported on the start
+	// instead of the exit code of the child process.
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return err
+	}
+	data, err := json.Marshal(setup)
+	if err != nil {
+		return err
+	}
+	cmd.Args = append([]string{childSetupName, string(data), cmd.Path}, cmd.Args...)
+	cmd.Path = selfExePath
+	return nil
+}
+
+// apply applies the settings to the current process.
+func (setup *childSetup) apply() error {
+	// The process is placed in the cgroup before it allocates anything.
+	if setup.Cgroup != "" {
+		if err := enterCgroup(setup.Cgroup); err != nil {
+			return err
+		}
+	}
+	if setup.Rlimits != nil {
+		if err := setup.Rlimits.apply(); err != nil {
+			return err
+		}
+	}
+	if setup.Umask != nil {
+		syscall.Umask(*setup.Umask)
+	}
+	if setup.Credential != nil {
+		// The credentials are changed only for the current thread,
+		// which then executes the Instance.
+		runtime.LockOSThread()
+		if err := dropThreadPrivileges(setup.Credential); err != nil {
+			return err
+		}
+		if setup.DeathSignal != 0 {
+			return setDeathSignal(setup.DeathSignal)
+		}
+	}
+	return nil
+}
+
+// runChildSetup applies the settings passed in the arguments
+// and executes the Instance. It never returns.
+func runChildSetup() {
+	var setup childSetup
+	err := json.Unmarshal([]byte(os.Args[1]), &setup)
+	if err == nil {
+		err = setup.apply()
+	}
+	if err == nil {
+		err = syscall.Exec(os.Args[2], os.Args[3:], os.Environ())
+	}
+	// The output of the process is written to the Instance log.
+	fmt.Fprintf(os.Stderr, "tvisor: can't start the Instance: %v\n", err)
+	os.Exit(childSetupExitCode)
+}
+
+// RunChildSetupIfRequested runs the child setup if the executable has been
+// run for it (see wrapCmd): applies the settings and executes the Instance,
+// so it never returns then. Otherwise it does nothing. It should be called
+// at the start of main() of the executable that runs the Instances.
+func RunChildSetupIfRequested() {
+	if len(os.Args) >= 4 && os.Args[0] == childSetupName {
+		runChildSetup()
+	}
+}
@@ -0,0 +1,191 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// lookupUser returns the user by name or ID.
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if _, convErr := strconv.Atoi(name); convErr == nil {
+			u, err = user.LookupId(name)
+		}
+	}
+	if err != nil {
+		return nil, errors.New(`Unknown user "` + name + `".`)
+	}
+	return u, nil
+}
+
+// lookupGroupId returns the ID of the group by name or ID.
+func lookupGroupId(name string) (uint32, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		if _, convErr := strconv.Atoi(name); convErr == nil {
+			g, err = user.LookupGroupId(name)
+		}
+	}
+	if err != nil {
+		return 0, errors.New(`Unknown group "` + name + `".`)
+	}
+	return parseId(g.Gid)
+}
+
+// parseId parses the user or group ID.
+func parseId(id string) (uint32, error) {
+	value, err := strconv.ParseUint(id, 10, 32)
+	return uint32(value), err
+}
+
+// resolveCredential returns the credential of the user and the groups.
+// If the group isn't set, the primary group of the user is used. The
+// user can be empty if the group is set (the user isn't changed then).
+func resolveCredential(userName string, group string, groups []string) (*syscall.Credential, error) {
+	cred := syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	if userName != "" {
+		u, err := lookupUser(userName)
+		if err != nil {
+			return nil, err
+		}
+		if cred.Uid, err = parseId(u.Uid); err != nil {
+			return nil, err
+		}
+		if cred.Gid, err = parseId(u.Gid); err != nil {
+			return nil, err
+		}
+	}
+	if group != "" {
+		gid, err := lookupGroupId(group)
+		if err != nil {
+			return nil, err
+		}
+		cred.Gid = gid
+	}
+	for _, name := range groups {
+		gid, err := lookupGroupId(name)
+		if err != nil {
+			return nil, err
+		}
+		cred.Groups = append(cred.Groups, gid)
+	}
+	return &cred, nil
+}
+
+// credential returns the credential of the Instance process
+// (nil if the user and the groups aren't set).
+func (spec *InstanceSpec) credential() (*syscall.Credential, error) {
+	if spec.User == "" && spec.Group == "" && len(spec.Groups) == 0 {
+		return nil, nil
+	}
+	return resolveCredential(spec.User, spec.Group, spec.Groups)
+}
+
+// dropThreadPrivileges switches the current thread to the credential.
+// The thread should be locked (runtime.LockOSThread). The credentials
+// are per-thread on Linux, so it is used only right before execve(2)
+// that makes the credentials of the thread the credentials of the
+// process.
+func dropThreadPrivileges(cred *syscall.Credential) error {
+	var groups unsafe.Pointer
+	if len(cred.Groups) != 0 {
+		groups = unsafe.Pointer(&cred.Groups[0])
+	}
+	calls := []struct {
+		name string
+		trap uintptr
+		a1   uintptr
+		a2   uintptr
+	}{
+		{"setgroups", syscall.SYS_SETGROUPS, uintptr(len(cred.Groups)), uintptr(groups)},
+		{"setgid", syscall.SYS_SETGID, uintptr(cred.Gid), 0},
+		{"setuid", syscall.SYS_SETUID, uintptr(cred.Uid), 0},
+	}
+	for _, call := range calls {
+		if _, _, errno := syscall.RawSyscall(call.trap, call.a1, call.a2, 0); errno != 0 {
+			return errors.New("Can't " + call.name + ": " + errno.Error() + ".")
+		}
+	}
+	runtime.KeepAlive(cred)
+	return nil
+}
+
+// setDeathSignal sets the signal sent to the current
+// process when its parent terminates.
+func setDeathSignal(sig syscall.Signal) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_PDEATHSIG,
+		uintptr(sig), 0)
+	if errno != 0 {
+		return errors.New("Can't set the parent death signal: " + errno.Error() + ".")
+	}
+	return nil
+}
+
+// needsPrivileges checks whether the Instance can't be started by
+// the process with the credential: the Instance is run by another
+// user / group or by root.
+func (spec *InstanceSpec) needsPrivileges(cred *syscall.Credential) bool {
+	instCred, err := spec.credential()
+	if err != nil || instCred == nil {
+		return err != nil
+	}
+	if instCred.Uid == 0 || instCred.Uid != cred.Uid || instCred.Gid != cred.Gid {
+		return true
+	}
+	return len(instCred.Groups) != 0
+}
+
+// DropPrivileges switches the Supervisor process to the user and the group
+// from the config (see Cfg.DropPrivileges). It should be called after the
+// privileged resources (the HTTP listener) are acquired, but before the
+// Instances are restored or started: otherwise the Instances run by root
+// can't be stopped then. The privileges aren't dropped if any of the
+// running or declared Instances needs them.
+// Returns false if there is nothing to drop.
+func (sv *Supervisor) DropPrivileges() (bool, error) {
+	cfg := sv.config()
+	if cfg.DropPrivileges.User == "" {
+		return false, nil
+	}
+	cred, err := resolveCredential(cfg.DropPrivileges.User, cfg.DropPrivileges.Group, nil)
+	if err != nil {
+		return false, err
+	}
+	if cred.Uid == uint32(os.Getuid()) && cred.Gid == uint32(os.Getgid()) {
+		return false, nil
+	}
+
+	var specs []*InstanceSpec
+	sv.instMapMutex.RLock()
+	for _, inst := range sv.instancesById {
+		specs = append(specs, &inst.Spec)
+	}
+	sv.instMapMutex.RUnlock()
+	for i := range cfg.Instances {
+		specs = append(specs, cfg.Instances[i].spec())
+	}
+	for _, spec := range specs {
+		if spec.needsPrivileges(cred) {
+			return false, errors.New(`The Instance "` + spec.Name +
+				`" needs the privileges to change its user or group.`)
+		}
+	}
+
+	// All the threads are switched (see syscall.AllThreadsSyscall).
+	if err := syscall.Setgroups([]int{}); err != nil {
+		return false, errors.New("Can't reset the supplementary groups: " + err.Error() + ".")
+	}
+	if err := syscall.Setgid(int(cred.Gid)); err != nil {
+		return false, errors.New("Can't change the group: " + err.Error() + ".")
+	}
+	if err := syscall.Setuid(int(cred.Uid)); err != nil {
+		return false, errors.New("Can't change the user: " + err.Error() + ".")
+	}
+	return true, nil
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestResolveCredential checks the resolving of the users and the groups.
+func TestResolveCredential(t *testing.T) {
+	assert := assert.New(t)
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+
+	// The user is set by ID.
+	cred, err := resolveCredential(strconv.Itoa(int(uid)), "", nil)
+	if assert.Nil(err) {
+		assert.Equal(uid, cred.Uid)
+		assert.Empty(cred.Groups)
+	}
+	// Only the group is set.
+	cred, err = resolveCredential("", strconv.Itoa(int(gid)), []string{strconv.Itoa(int(gid))})
+	if assert.Nil(err) {
+		assert.Equal(syscall.Credential{Uid: uid, Gid: gid, Groups: []uint32{gid}}, *cred)
+	}
+
+	_, err = resolveCredential("tvisor-unknown-user", "", nil)
+	assert.EqualError(err, `Unknown user "tvisor-unknown-user".`)
+	_, err = resolveCredential("", "", []string{"tvisor-unknown-group"})
+	assert.EqualError(err, `Unknown group "tvisor-unknown-group".`)
+	assert.NotNil((&InstanceSpec{Name: "test", Group: "tvisor-unknown-group"}).validate())
+
+	// An Instance run by the same user doesn't need the privileges.
+	self := &syscall.Credential{Uid: uid, Gid: gid}
+	assert.False((&InstanceSpec{Name: "test"}).needsPrivileges(self))
+	spec := &InstanceSpec{Name: "test", Groups: []string{strconv.Itoa(int(gid))}}
+	assert.True(spec.needsPrivileges(self), "The supplementary groups are changed.")
+	spec = &InstanceSpec{Name: "test", User: strconv.Itoa(int(uid))}
+	assert.Equal(uid == 0, spec.needsPrivileges(self))
+	assert.True(spec.needsPrivileges(&syscall.Credential{Uid: uid + 1, Gid: gid}))
+}
+
+// procStatus returns the values of the fields
+// of /proc/<pid>/status (without extra spaces).
+func procStatus(pid int, names ...string) (map[string]string, error) {
+	file, err := os.Open(procPath(pid, "status"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	res := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		for _, name := range names {
+			if fields[0] == name {
+				res[name] = strings.Join(strings.Fields(fields[1]), " ")
+			}
+		}
+	}
+	return res, scanner.Err()
+}
+
+// Test the Instances run by another user.
+func TestSupervisorCredential(t *testing.T) {
+	assert := assert.New(t)
+	if os.Getuid() != 0 {
+		t.Skip("The test requires the privileges.")
+	}
+
+	// The Instance should be accessible by the user.
+	dir := t.TempDir()
+	for _, path := range []string{filepath.Dir(dir), dir} {
+		if err := os.Chmod(path, 0755); err != nil {
+			t.Fatalf(`Can't change the mode of the directory. Error: "%v"`, err)
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join("../../test_instances", testInstName))
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, testInstName), data, 0755)
+	}
+	if err != nil {
+		t.Fatalf(`Can't copy the test instance. Error: "%v"`, err)
+	}
+
+	sv := newTestSupervisor(t, func(cfg *Cfg) {
+		cfg.InstancesDir = dir
+		cfg.WorkDir = filepath.Join(dir, "{name}-{id}")
+	})
+
+	nobody, err := lookupUser("nobody")
+	if err != nil {
+		t.Skip(`The "nobody" user doesn't exist.`)
+	}
+	zero := RlimitValue(0)
+	for _, rlimits := range []*RlimitsSpec{nil, {Core: &RlimitSpec{Soft: &zero}}} {
+		// The credential is applied by the child setup if there are the limits.
+		id, err := sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance", User: "nobody",
+			Groups: []string{"0"}, Rlimits: rlimits})
+		if !assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err) {
+			continue
+		}
+		pid := sv.getInstance(id).Pid()
+		waitExec(t, pid, dir)
+		cred, err := procStatus(pid, "Uid", "Gid", "Groups")
+		if assert.Nil(err) {
+			ids := func(id string) string { return strings.Repeat(id+" ", 3) + id }
+			assert.Equal(map[string]string{"Uid": ids(nobody.Uid), "Gid": ids(nobody.Gid),
+				"Groups": "0"}, cred)
+		}
+		// The working directory is owned by the user.
+		workDir := sv.getInstance(id).workDir
+		if info, err := os.Stat(filepath.Join(workDir, "data")); assert.Nil(err) {
+			stat := info.Sys().(*syscall.Stat_t)
+			assert.Equal(nobody.Uid, strconv.Itoa(int(stat.Uid)))
+			assert.Equal(nobody.Gid, strconv.Itoa(int(stat.Gid)))
+		}
+	}
+
+	// The privileges aren't dropped while the Instances need them.
+	cfg := &Cfg{InstancesDir: dir, TermTimeout: sv.config().TermTimeout}
+	cfg.DropPrivileges = PrivilegesCfg{User: "nobody"}
+	sv.cfgMutex.Lock()
+	sv.cfg = cfg
+	sv.cfgMutex.Unlock()
+	dropped, err := sv.DropPrivileges()
+	assert.False(dropped)
+	assert.EqualError(err, `The Instance "test_instance" needs the privileges `+
+		`to change its user or group.`)
+}
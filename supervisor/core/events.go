@@ -0,0 +1,228 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	// EventStarted - the Instance has been started.
+	EventStarted = "started"
+	// EventReady - the readiness probe of the Instance has succeeded.
+	EventReady = "ready"
+	// EventUnhealthy - the liveness probe of the Instance has failed,
+	// the Instance will be restarted.
+	EventUnhealthy = "unhealthy"
+	// EventStopping - the Instance is being stopped by a client.
+	EventStopping = "stopping"
+	// EventStopped - the Instance has been stopped and removed.
+	EventStopped = "stopped"
+	// EventExited - the Instance process has been terminated unexpectedly.
+	EventExited = "exited"
+	// EventRestarted - the Instance has been restarted after termination,
+	// by a client (see Supervisor.RestartInstance) or because its declared
+	// settings have been changed.
+	EventRestarted = "restarted"
+	// EventRestartGivenUp - the Instance won't be restarted anymore
+	// (see RestartCfg.MaxRestarts).
+	EventRestartGivenUp = "restart_given_up"
+	// EventAdopted - the Instance started before the Supervisor
+	// restart has been re-adopted.
+	EventAdopted = "adopted"
+)
+
+// Event describes a change of the Instance lifecycle.
+type Event struct {
+	// Seq is the sequence number of the event. Sequence numbers are
+	// monotonically increasing and start from 1. If the state file is
+	// used (see Cfg.StateFile), the sequence number saved with the state
+	// is continued after the Supervisor restart.
+	Seq uint64 `json:"seq"`
+	// Time is the time at which the event has occurred.
+	Time time.Time `json:"time"`
+	// Type is the event type. See event types.
+	Type string `json:"type"`
+	// ID is the Instance ID.
+	ID int `json:"id"`
+	// Key is the Instance key.
+	Key string `json:"key"`
+	// Name is the Instance name.
+	Name string `json:"name"`
+	// Pid is the process ID (0 if there is no process).
+	Pid int `json:"pid,omitempty"`
+	// OldPid is the process ID before the restart ("restarted" events only).
+	OldPid int `json:"old_pid,omitempty"`
+	// Exit is the exit status of the process ("exited" and "stopped"
+	// events only, if it is known).
+	Exit *ExitStatus `json:"exit,omitempty"`
+	// Err describes the reason of the event (if any).
+	Err string `json:"err,omitempty"`
+}
+
+// eventBus keeps the last events and passes new events to the subscribers.
+type eventBus struct {
+	// mutex is used to work with the events and the subscribers.
+	mutex sync.Mutex
+	// seq is the sequence number of the last event.
+	seq uint64
+	// history is a ring buffer of the last events.
+	history []Event
+	// next is the position of the next event in the history.
+	next int
+	// full indicates that the history is full.
+	full bool
+	// subscribers is a set of channels receiving new events.
+	subscribers map[chan Event]struct{}
+}
+
+// newEventBus creates an eventBus that keeps "size" last events.
+func newEventBus(size int) *eventBus {
+	return &eventBus{
+		history:     make([]Event, size),
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// publish assigns the sequence number and the time to the event,
+// stores it in the history and passes it to the subscribers.
+func (bus *eventBus) publish(event Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.seq++
+	event.Seq = bus.seq
+	event.Time = time.Now()
+
+	if len(bus.history) != 0 {
+		bus.history[bus.next] = event
+		bus.next = (bus.next + 1) % len(bus.history)
+		bus.full = bus.full || bus.next == 0
+	}
+
+	for subscriber := range bus.subscribers {
+		// A slow subscriber shouldn't block the Supervisor.
+		// It will notice the gap by the sequence numbers.
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// lastSeq returns the sequence number of the last event.
+func (bus *eventBus) lastSeq() uint64 {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.seq
+}
+
+// continueSeq continues the sequence of the events published
+// before the restart: seq - the sequence number of the last one.
+func (bus *eventBus) continueSeq(seq uint64) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if seq > bus.seq {
+		bus.seq = seq
+	}
+}
+
+// subscribe returns the events from the history with the sequence number
+// greater than "after" (the oldest first) and a channel receiving new
+// events. The channel is closed by unsubscribe.
+func (bus *eventBus) subscribe(after uint64) ([]Event, chan Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	events := []Event{}
+	for _, event := range bus.last() {
+		if event.Seq > after {
+			events = append(events, event)
+		}
+	}
+
+	subscriber := make(chan Event, followBufferSize)
+	bus.subscribers[subscriber] = struct{}{}
+	return events, subscriber
+}
+
+// last returns the events from the history (the oldest first).
+// The "mutex" should be locked.
+func (bus *eventBus) last() []Event {
+	count, first := bus.next, 0
+	if bus.full {
+		count, first = len(bus.history), bus.next
+	}
+	events := make([]Event, count)
+	for i := range events {
+		events[i] = bus.history[(first+i)%len(bus.history)]
+	}
+	return events
+}
+
+// resize changes the number of the kept events. The last events are kept.
+func (bus *eventBus) resize(size int) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if size == len(bus.history) {
+		return
+	}
+
+	events := bus.last()
+	if len(events) > size {
+		events = events[len(events)-size:]
+	}
+	bus.history = make([]Event, size)
+	count := copy(bus.history, events)
+	bus.next, bus.full = 0, false
+	if size != 0 {
+		bus.next = count % size
+		bus.full = count == size
+	}
+}
+
+// unsubscribe stops passing new events to the subscriber.
+func (bus *eventBus) unsubscribe(subscriber chan Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if _, ok := bus.subscribers[subscriber]; ok {
+		delete(bus.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// publishEvent publishes the event of the Instance. The sequence number
+// isn't saved here: it is saved with the state of the Instances after
+// their start / stop (see persistState), so a burst of events doesn't
+// cause a write of the state file per event.
+func (sv *Supervisor) publishEvent(eventType string, id int, inst *Instance,
+	exit *ExitStatus, err error) {
+	event := Event{Type: eventType, ID: id, Key: inst.key, Name: inst.Spec.Name,
+		Pid: inst.Pid(), Exit: exit}
+	if exit != nil {
+		event.Pid = exit.Pid
+	}
+	if err != nil {
+		event.Err = err.Error()
+	}
+	sv.events.publish(event)
+}
+
+// publishRestartEvent publishes the "restarted" event of the Instance.
+// oldPid - the process ID before the restart.
+func (sv *Supervisor) publishRestartEvent(id int, inst *Instance, oldPid int) {
+	sv.events.publish(Event{Type: EventRestarted, ID: id, Key: inst.key,
+		Name: inst.Spec.Name, Pid: inst.Pid(), OldPid: oldPid})
+}
+
+// SubscribeEvents returns the kept events with the sequence number greater
+// than "after" and a channel receiving new events. It allows a client to
+// resume the event stream after reconnecting: the client should pass the
+// sequence number of the last received event. A gap in the sequence
+// numbers means that some events have been lost (the client is too slow
+// or the events aren't kept anymore, see Cfg.EventHistorySize).
+// The returned cancel function should be called to unsubscribe.
+func (sv *Supervisor) SubscribeEvents(after uint64) ([]Event, <-chan Event, func()) {
+	events, subscriber := sv.events.subscribe(after)
+	return events, subscriber, func() { sv.events.unsubscribe(subscriber) }
+}
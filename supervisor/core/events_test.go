@@ -0,0 +1,124 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEventBus checks the sequence numbers and the resume of the events.
+func TestEventBus(t *testing.T) {
+	assert := assert.New(t)
+	bus := newEventBus(2)
+
+	bus.publish(Event{Type: EventStarted, ID: 1})
+	bus.publish(Event{Type: EventStopping, ID: 1})
+	bus.publish(Event{Type: EventStopped, ID: 1})
+
+	// Only 2 last events are kept.
+	events, subscriber := bus.subscribe(0)
+	if assert.Equal(2, len(events), "Unexpected number of events.") {
+		assert.Equal(uint64(2), events[0].Seq)
+		assert.Equal(EventStopped, events[1].Type)
+	}
+
+	// Resume after the last received event.
+	events, _ = bus.subscribe(2)
+	if assert.Equal(1, len(events), "Unexpected number of events.") {
+		assert.Equal(uint64(3), events[0].Seq)
+	}
+
+	bus.publish(Event{Type: EventStarted, ID: 2})
+	select {
+	case event := <-subscriber:
+		assert.Equal(uint64(4), event.Seq)
+		assert.Equal(2, event.ID)
+	case <-time.After(time.Second):
+		t.Error("The event hasn't been passed to the subscriber.")
+	}
+
+	bus.unsubscribe(subscriber)
+	_, ok := <-subscriber
+	assert.False(ok, "The subscriber hasn't been closed.")
+
+	// The last events are kept on resize.
+	bus.resize(3)
+	bus.publish(Event{Type: EventStopping, ID: 2})
+	events, _ = bus.subscribe(0)
+	if assert.Equal(3, len(events), "Unexpected number of events.") {
+		assert.Equal(uint64(3), events[0].Seq)
+		assert.Equal(uint64(5), events[2].Seq)
+	}
+	bus.resize(1)
+	events, _ = bus.subscribe(0)
+	if assert.Equal(1, len(events), "Unexpected number of events.") {
+		assert.Equal(uint64(5), events[0].Seq)
+	}
+}
+
+// waitEvent waits for the next event and checks its type.
+func waitEvent(t *testing.T, events <-chan Event, eventType string) *Event {
+	select {
+	case event := <-events:
+		assert.Equal(t, eventType, event.Type, "Unexpected event.")
+		return &event
+	case <-time.After(5 * time.Second):
+		t.Errorf(`The "%v" event hasn't been received.`, eventType)
+		return nil
+	}
+}
+
+// Test the events of the Instance lifecycle.
+func TestSupervisorEvents(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, func(cfg *Cfg) {
+		cfg.Restart = RestartCfg{MaxRestarts: 1, Window: Duration(time.Minute)}
+		cfg.EventHistorySize = 100
+	})
+	_, events, cancel := sv.SubscribeEvents(0)
+	defer cancel()
+
+	id, err := sv.StartInstance(&InstanceSpec{Name: "test_instance",
+		RestartPolicy: RestartOnFailure})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+	event := waitEvent(t, events, EventStarted)
+	if event != nil {
+		assert.Equal(id, event.ID)
+		assert.Equal("test_instance", event.Name)
+		assert.Equal(sv.getInstance(id).Pid(), event.Pid)
+	}
+
+	// Crash and restart.
+	inst := sv.getInstance(id)
+	pid := inst.Pid()
+	sv.RestartAfterTermInstance(pid, killTestInstance(t, inst))
+	if event := waitEvent(t, events, EventExited); event != nil {
+		assert.Equal(pid, event.Pid)
+		assert.Equal("SIGKILL", event.Exit.Signal)
+	}
+	if event := waitEvent(t, events, EventRestarted); event != nil {
+		assert.Equal(pid, event.OldPid)
+		assert.Equal(sv.getInstance(id).Pid(), event.Pid)
+	}
+
+	// Crash loop.
+	inst = sv.getInstance(id)
+	waitSignalHandlers(t, inst.Pid())
+	sv.RestartAfterTermInstance(inst.Pid(), killTestInstance(t, inst))
+	waitEvent(t, events, EventExited)
+	waitEvent(t, events, EventRestartGivenUp)
+
+	assert.Nil(sv.StopInstance(id, true))
+	waitEvent(t, events, EventStopping)
+	waitEvent(t, events, EventStopped)
+
+	// All the events are kept and can be read again.
+	history, _, cancelHistory := sv.SubscribeEvents(0)
+	defer cancelHistory()
+	if assert.Equal(7, len(history), "Unexpected number of events.") {
+		for i := range history {
+			assert.Equal(uint64(i+1), history[i].Seq)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// ExitStatus describes the termination of an Instance process.
+type ExitStatus struct {
+	// Time is the time at which the termination has been handled.
+	Time time.Time `json:"time"`
+	// Pid is an ID of the terminated process.
+	Pid int `json:"pid"`
+	// ExitCode is the exit code of the process
+	// (-1 if the process has been terminated by a signal).
+	ExitCode int `json:"exit_code"`
+	// Signal is the name of the signal that terminated the process.
+	Signal string `json:"signal,omitempty"`
+	// StopSignal is the name of the last signal sent to the process
+	// by the stop sequence before the termination (if the process has
+	// been stopped by the Supervisor).
+	StopSignal string `json:"stop_signal,omitempty"`
+	// CoreDumped indicates that the process has produced a core dump.
+	CoreDumped bool `json:"core_dumped"`
+	// UserTime is the user CPU time (in seconds) used by the process.
+	UserTime float64 `json:"user_time"`
+	// SysTime is the system CPU time (in seconds) used by the process.
+	SysTime float64 `json:"sys_time"`
+	// MaxRSS is the maximum resident set size (in kilobytes).
+	MaxRSS int64 `json:"max_rss"`
+	// StartError is the error of the start of a new process
+	// after the termination (if it has failed).
+	StartError string `json:"start_error,omitempty"`
+}
+
+// NewExitStatus creates an ExitStatus from the results of "wait4".
+// rusage can be nil if the resource usage is unknown.
+func NewExitStatus(pid int, status syscall.WaitStatus, rusage *syscall.Rusage) *ExitStatus {
+	res := ExitStatus{
+		Time:       time.Now(),
+		Pid:        pid,
+		ExitCode:   status.ExitStatus(),
+		CoreDumped: status.CoreDump(),
+	}
+	if status.Signaled() {
+		res.Signal = signalName(status.Signal())
+	}
+	if rusage != nil {
+		res.UserTime = time.Duration(rusage.Utime.Nano()).Seconds()
+		res.SysTime = time.Duration(rusage.Stime.Nano()).Seconds()
+		res.MaxRSS = rusage.Maxrss
+	}
+	return &res
+}
+
+// exitStatusFromState creates an ExitStatus from the state
+// of a process that has been waited by the os package.
+func exitStatusFromState(pid int, state *os.ProcessState) *ExitStatus {
+	status, _ := state.Sys().(syscall.WaitStatus)
+	rusage, _ := state.SysUsage().(*syscall.Rusage)
+	return NewExitStatus(pid, status, rusage)
+}
+
+// isFailure checks whether the process has been terminated
+// abnormally (by a signal or with a non-zero exit code).
+func (status *ExitStatus) isFailure() bool {
+	return status.Signal != "" || status.ExitCode != 0
+}
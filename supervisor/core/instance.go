@@ -2,6 +2,7 @@ package core
 
 import (
 	"errors"
+	"io"
 	"os"
 	"os/exec"
 	"sync"
@@ -12,29 +13,114 @@ import (
 // Instance states.
 const (
 	stateTerminated = "terminated"
+	stateStarting   = "starting"
 	stateRunning    = "running"
+	stateUnhealthy  = "unhealthy"
+	stateFailed     = "failed"
 )
 
 // Instance describes a running process.
 type Instance struct {
-	// Name is the name of the Instance.
-	Name string
+	// Spec describes the settings used to start the Instance.
+	Spec InstanceSpec
 	// Cmd represents an external command being prepared or run.
 	Cmd *exec.Cmd
-	// Restartable indicates whether to restart the instance in
-	// case of failure or not.
-	Restartable bool
-	// Env describes the environment settled by a client.
-	Env []string
+	// StartTime is the time at which the process has been started.
+	StartTime time.Time
+	// path is the path to the executable file of the Instance.
+	path string
+	// id is the ID of the Instance.
+	id int
+	// key is the unique key of the Instance (see InstanceSpec.KeySuffix).
+	key string
+	// cgroupPath is the path to the cgroup of the Instance
+	// ("" if the cgroups are disabled).
+	cgroupPath string
+	// cgroupErr describes why the current process
+	// isn't placed in the cgroup.
+	cgroupErr string
+	// workDir is the working directory of the Instance
+	// ("" - the working directory of the Supervisor).
+	workDir string
+	// deathSignal is the signal sent to the process when the
+	// Supervisor terminates (0 - none, see Cfg.KillOnExit).
+	deathSignal syscall.Signal
+	// pgid is the process group of the current process (0 if the
+	// process isn't a leader of its group, it could be so for an
+	// adopted process).
+	pgid int
+	// stopSignal is the last signal sent to the current process by
+	// the stop sequence (0 if the process isn't being stopped).
+	stopSignal syscall.Signal
+	// adopted indicates that the process has been started by another
+	// Supervisor (for example, before tvisor restart) and it isn't a
+	// child process of the current one.
+	adopted bool
+	// declared indicates that the Instance is declared in the
+	// config and is managed by the reconciliation.
+	declared bool
+	// stopped indicates that a stop command was received for the
+	// Instance, so it shouldn't be restarted anymore.
+	stopped bool
+	// failed indicates that the Instance has been restarted too many
+	// times and the Supervisor has given up restarting it.
+	failed bool
+	// exitHandled indicates that the termination of the current
+	// process has already been handled by scheduleRestart.
+	exitHandled bool
+	// restartCount is the total number of restarts of the Instance.
+	restartCount int
+	// restarts stores the times of restarts within the restart window.
+	restarts []time.Time
+	// nextRestart is the time of the scheduled restart (if any).
+	nextRestart time.Time
+	// restartTimer is used to restart the Instance after a delay.
+	restartTimer *time.Timer
+	// lastExit describes the last termination of the Instance process.
+	lastExit *ExitStatus
+	// exitHistory describes the last terminations of the Instance
+	// process (the oldest first).
+	exitHistory []*ExitStatus
+	// ready indicates that the readiness probe of the
+	// current process has succeeded (or isn't set).
+	ready bool
+	// readyCh is closed when the current process becomes ready.
+	readyCh chan struct{}
+	// readinessErr is the last error of the readiness probe.
+	readinessErr string
+	// unhealthy indicates that the liveness probe of the current
+	// process has failed and the process is being restarted.
+	unhealthy bool
+	// livenessErr is the last error of the liveness probe.
+	livenessErr string
+	// startErr is the error of the last failed start of a new
+	// process (see Supervisor.replaceInstance).
+	startErr string
+	// boxInfo is the last "box.info" of the current process.
+	boxInfo *BoxInfo
 	// mutex is used to prevent prevent multiple goroutines
 	// from trying to stop an instance at the same time.
 	mutex sync.Mutex
+	// infoMutex protects the information about the current process
+	// and restarts, which can be read without locking the "mutex"
+	// (for example, to get the status during a termination).
+	infoMutex sync.RWMutex
 	// done channel used to wait for a process termination.
 	done chan error
+	// log is the file to which the output of the process
+	// is written (nil if the output isn't written to a file).
+	log *logFile
+	// output stores the last lines of the process output
+	// (nil if the output isn't captured).
+	output *outputBuffer
 }
 
 // InstanceStatus describes the status of the Instance.
 type InstanceStatus struct {
+	// ID is the ID of the Instance.
+	ID int `json:"id"`
+	// Key is the unique key of the Instance.
+	Key string `json:"key"`
 	// Name is the name of the Instance.
 	Name string `json:"name"`
 	// State describes the state of the Instance.
@@ -42,26 +128,260 @@ type InstanceStatus struct {
 	State string `json:"state"`
 	// Pid is a process ID.
 	Pid int `json:"pid"`
+	// Declared indicates that the Instance is declared in the config.
+	Declared bool `json:"declared"`
 	// Restartable indicates whether to restart the instance in
 	// case of failure or not.
 	Restartable bool `json:"restartable"`
+	// RestartPolicy describes when the Instance should be
+	// restarted after termination.
+	RestartPolicy string `json:"restart_policy"`
+	// RestartCount is the total number of restarts of the Instance.
+	RestartCount int `json:"restart_count"`
+	// NextRestart is the time of the scheduled restart (if any).
+	NextRestart *time.Time `json:"next_restart,omitempty"`
 	// Env describes the environment settled by a client.
 	Env []string `json:"env"`
+	// StartTime is the time at which the process has been started.
+	StartTime time.Time `json:"start_time"`
+	// LastExit describes the last termination of the Instance process.
+	LastExit *ExitStatus `json:"last_exit,omitempty"`
+	// ExitHistory describes the last terminations of the Instance
+	// process (the oldest first).
+	ExitHistory []*ExitStatus `json:"exit_history,omitempty"`
+	// ReadinessError is the last error of the readiness
+	// probe (while the Instance is starting).
+	ReadinessError string `json:"readiness_error,omitempty"`
+	// LivenessError is the last error of the liveness probe.
+	LivenessError string `json:"liveness_error,omitempty"`
+	// StartError is the error of the last failed start of a new
+	// process by the restart (until the Instance is started).
+	StartError string `json:"start_error,omitempty"`
+	// BoxInfo describes the last "box.info" of the Instance
+	// (see InstanceSpec.Iproto).
+	BoxInfo *BoxInfo `json:"box_info,omitempty"`
+	// Rlimits describes the current resource limits of the
+	// process (see InstanceSpec.Rlimits).
+	Rlimits *RlimitsSpec `json:"rlimits,omitempty"`
+	// Cgroup describes the cgroup of the Instance and its
+	// usage (see Cfg.CgroupParent).
+	Cgroup *CgroupStatus `json:"cgroup,omitempty"`
+	// WorkDir is the working directory of the Instance
+	// (see Cfg.WorkDir).
+	WorkDir string `json:"work_dir,omitempty"`
 }
 
 // NewInstance creates an Instance.
-func NewInstance(name string, cmd *exec.Cmd, env []string, restart bool) *Instance {
-	return &Instance{Name: name, Cmd: cmd, Env: env, Restartable: restart}
+func NewInstance(spec *InstanceSpec, cmd *exec.Cmd) *Instance {
+	return &Instance{Spec: *spec, Cmd: cmd, path: cmd.Path}
+}
+
+// adoptInstance creates an Instance for the already running process
+// that isn't a child of the current process.
+func adoptInstance(spec *InstanceSpec, instPath string, pid int,
+	startTime time.Time) (*Instance, error) {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	cmd := newInstanceCmd(instPath, spec.Env)
+	cmd.Process = process
+	inst := NewInstance(spec, cmd)
+	inst.StartTime = startTime
+	inst.adopted = true
+	if isGroupLeader(pid) {
+		inst.pgid = pid
+	}
+	// The process has been started long ago.
+	inst.resetProcessState(true)
+	return inst, nil
 }
 
 // IsAlive verifies that the Instance is alive by sending a "0" signal.
 func (inst *Instance) IsAlive() bool {
-	return inst.Cmd.Process.Signal(syscall.Signal(0)) == nil
+	return inst.process().Signal(syscall.Signal(0)) == nil
+}
+
+// process returns the current process of the Instance.
+func (inst *Instance) process() *os.Process {
+	inst.infoMutex.RLock()
+	defer inst.infoMutex.RUnlock()
+	return inst.Cmd.Process
+}
+
+// Pid returns the process ID of the Instance.
+func (inst *Instance) Pid() int {
+	return inst.process().Pid
 }
 
 // Start runs the Instatnce.
 func (inst *Instance) Start() error {
-	return inst.Cmd.Start()
+	inst.infoMutex.Lock()
+	defer inst.infoMutex.Unlock()
+	return inst.startCmd()
+}
+
+// startCmd starts the command of the Instance and redirects the output
+// of the process to the Instance log and output buffer (if any). The
+// output is passed through pipes, so the log file can be rotated by
+// the Supervisor.
+func (inst *Instance) startCmd() error {
+	cgroup, err := inst.setupCgroup()
+	if err != nil {
+		return err
+	}
+	cred, err := inst.Spec.credential()
+	if err != nil {
+		return err
+	}
+	if err := inst.prepareWorkDir(cred); err != nil {
+		return err
+	}
+	if setup := newChildSetup(&inst.Spec, cgroup, cred, inst.deathSignal); setup != nil {
+		capabilities, err := procCapabilities(os.Getpid())
+		if err != nil {
+			return err
+		}
+		if err := setup.check(capabilities); err != nil {
+			return err
+		}
+		if err := setup.wrapCmd(inst.Cmd); err != nil {
+			return err
+		}
+		// The credential is changed by the child setup.
+		cred = nil
+	}
+	inst.Cmd.SysProcAttr = inst.sysProcAttr(cred)
+
+	var readers []*os.File
+	if inst.log != nil || inst.output != nil {
+		stdoutReader, stdoutWriter, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		stderrReader, stderrWriter, err := os.Pipe()
+		if err != nil {
+			stdoutReader.Close()
+			stdoutWriter.Close()
+			return err
+		}
+		// The write ends of the pipes are used only by the child process.
+		defer stdoutWriter.Close()
+		defer stderrWriter.Close()
+		inst.Cmd.Stdout = stdoutWriter
+		inst.Cmd.Stderr = stderrWriter
+		readers = []*os.File{stdoutReader, stderrReader}
+	}
+
+	if err := inst.Cmd.Start(); err != nil {
+		for _, reader := range readers {
+			reader.Close()
+		}
+		return err
+	}
+	inst.StartTime = time.Now()
+	inst.pgid = inst.Cmd.Process.Pid
+	inst.resetProcessState(inst.Spec.Readiness == nil)
+
+	var log io.Writer
+	if inst.log != nil {
+		log = inst.log
+	}
+	if len(readers) != 0 {
+		go copyOutput(log, inst.output, StreamStdout, readers[0])
+		go copyOutput(log, inst.output, StreamStderr, readers[1])
+	}
+	return nil
+}
+
+// resetProcessState resets the information about the
+// previous process (readiness, health, "box.info").
+func (inst *Instance) resetProcessState(ready bool) {
+	inst.ready = ready
+	inst.readinessErr = ""
+	inst.unhealthy = false
+	inst.livenessErr = ""
+	inst.boxInfo = nil
+	inst.stopSignal = 0
+	inst.readyCh = make(chan struct{})
+	if ready {
+		close(inst.readyCh)
+	}
+}
+
+// readiness returns the current process and the channel
+// that is closed when the process becomes ready.
+func (inst *Instance) readiness() (*os.Process, chan struct{}) {
+	inst.infoMutex.RLock()
+	defer inst.infoMutex.RUnlock()
+	return inst.Cmd.Process, inst.readyCh
+}
+
+// setReadiness saves the result of the readiness probe of the process.
+// Returns false if the process isn't the current one anymore.
+func (inst *Instance) setReadiness(process *os.Process, err error) bool {
+	inst.infoMutex.Lock()
+	defer inst.infoMutex.Unlock()
+	if inst.Cmd.Process != process {
+		return false
+	}
+	if err != nil {
+		inst.readinessErr = err.Error()
+		return true
+	}
+	if !inst.ready {
+		inst.ready = true
+		inst.readinessErr = ""
+		close(inst.readyCh)
+	}
+	return true
+}
+
+// setLiveness saves the result of the liveness probe of the process.
+// unhealthy - the failure threshold has been reached.
+// Returns false if the process isn't the current one anymore.
+func (inst *Instance) setLiveness(process *os.Process, err error, unhealthy bool) bool {
+	inst.infoMutex.Lock()
+	defer inst.infoMutex.Unlock()
+	if inst.Cmd.Process != process {
+		return false
+	}
+	inst.livenessErr = ""
+	if err != nil {
+		inst.livenessErr = err.Error()
+	}
+	inst.unhealthy = unhealthy
+	return true
+}
+
+// isUnhealthy checks whether the liveness probe of the current process
+// has failed.
+func (inst *Instance) isUnhealthy() bool {
+	inst.infoMutex.RLock()
+	defer inst.infoMutex.RUnlock()
+	return inst.unhealthy
+}
+
+// IsAdopted returns true if the Instance has been adopted
+// and its process isn't a child of the Supervisor.
+func (inst *Instance) IsAdopted() bool {
+	inst.infoMutex.RLock()
+	defer inst.infoMutex.RUnlock()
+	return inst.adopted
+}
+
+// wait waits for the process to terminate.
+func (inst *Instance) wait() error {
+	if !inst.adopted {
+		return inst.Cmd.Wait()
+	}
+
+	// It is impossible to wait for a process that isn't a child,
+	// so let's just check periodically whether it is alive.
+	for inst.IsAlive() {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
 }
 
 // Restart restarts the Instance.
@@ -71,20 +391,147 @@ func (inst *Instance) Restart() error {
 	inst.mutex.Lock()
 	defer inst.mutex.Unlock()
 
+	if inst.stopped {
+		return errors.New("The Instance has been stopped.")
+	}
+
 	// Restart the Instance.
-	cmd := exec.Command(inst.Cmd.Path)
-	cmd.Env = append(os.Environ(), inst.Env...)
-	inst.Cmd = cmd
-	return inst.Cmd.Start()
+	inst.infoMutex.Lock()
+	defer inst.infoMutex.Unlock()
+	// Kill the processes left by the terminated one.
+	sweepGroup(inst.pgid, 0)
+	prevCmd := inst.Cmd
+	inst.Cmd = newInstanceCmd(inst.path, inst.Spec.Env)
+	if err := inst.startCmd(); err != nil {
+		// Keep the terminated process to not
+		// lose the information about it.
+		inst.Cmd = prevCmd
+		return err
+	}
+	inst.adopted = false
+	inst.done = nil
+	inst.exitHandled = false
+	inst.nextRestart = time.Time{}
+	inst.startErr = ""
+	return nil
 }
 
-// Stop terminates the Instance.
+// scheduleRestart schedules a restart of the terminated Instance
+// according to its restart policy. The restart function will be called
+// after the backoff delay.
 //
-// timeout - the time that was provided to the process
-// to terminate correctly befor the "SIGKILL" signal is used.
+// failure - the process has been terminated by a signal or with a
+// non-zero exit code (or its exit status is unknown).
+//
+// Returns the delay before the restart.
+func (inst *Instance) scheduleRestart(cfg *RestartCfg, failure bool,
+	restart func()) (time.Duration, error) {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+
+	inst.exitHandled = true
+	if inst.stopped {
+		return 0, errors.New("The Instance has been stopped.")
+	}
+	if !needRestart(inst.Spec.RestartPolicy, failure) {
+		return 0, errors.New(`The restart policy "` + inst.Spec.RestartPolicy +
+			`" doesn't require a restart.`)
+	}
+
+	// Forget the restarts out of the window.
+	now := time.Now()
+	var restarts []time.Time
+	for _, restartTime := range inst.restarts {
+		if now.Sub(restartTime) < time.Duration(cfg.Window) {
+			restarts = append(restarts, restartTime)
+		}
+	}
+	inst.restarts = restarts
+
+	inst.infoMutex.Lock()
+	defer inst.infoMutex.Unlock()
+
+	// Seems like the Instance is in a crash loop.
+	if cfg.MaxRestarts > 0 && len(inst.restarts) >= cfg.MaxRestarts {
+		inst.failed = true
+		return 0, errRestartGivenUp
+	}
+
+	delay := restartBackoff(cfg, len(inst.restarts))
+	inst.restarts = append(inst.restarts, now)
+	inst.restartCount++
+	inst.nextRestart = now.Add(delay)
+	inst.restartTimer = time.AfterFunc(delay, restart)
+
+	return delay, nil
+}
+
+// recordExit saves the exit status of the Instance process.
+// historySize - the maximum number of exit statuses in the history.
+func (inst *Instance) recordExit(status *ExitStatus, historySize int) {
+	inst.infoMutex.Lock()
+	defer inst.infoMutex.Unlock()
+	if inst.stopSignal != 0 && status.Pid == inst.Cmd.Process.Pid {
+		status.StopSignal = signalName(inst.stopSignal)
+	}
+	inst.lastExit = status
+	if historySize <= 0 {
+		return
+	}
+	inst.exitHistory = append(inst.exitHistory, status)
+	if len(inst.exitHistory) > historySize {
+		inst.exitHistory = inst.exitHistory[len(inst.exitHistory)-historySize:]
+	}
+}
+
+// recordStartError saves the error of the start of a new process
+// after the termination of the previous one.
+// exit - the exit status of the previous process (can be nil).
+func (inst *Instance) recordStartError(exit *ExitStatus, err error) {
+	inst.infoMutex.Lock()
+	defer inst.infoMutex.Unlock()
+	inst.startErr = err.Error()
+	if exit != nil {
+		exit.StartError = inst.startErr
+	}
+}
+
+// isStopped checks whether the Instance has been stopped by a client.
+func (inst *Instance) isStopped() bool {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	return inst.stopped
+}
+
+// markExitHandled marks the termination of the current process as handled.
+// Returns false if it has already been handled.
+func (inst *Instance) markExitHandled() bool {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	if inst.exitHandled {
+		return false
+	}
+	inst.exitHandled = true
+	return true
+}
+
+// isExitHandled checks whether the termination of the
+// current process of the Instance has already been handled.
+func (inst *Instance) isExitHandled() bool {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	return inst.exitHandled
+}
+
+// Stop terminates the Instance by using its stop sequence
+// (see InstanceSpec.StopSequence).
+//
+// timeout - the time that was provided to the process to terminate
+// correctly after the last step of the sequence before the "SIGKILL"
+// signal is used.
 //
 // force - if force is "true" the "SIGKILL" signal will be
-// sent to the process in case of using "SIGINT" doesn't
+// sent to the process in case of the stop sequence doesn't
 // terminate the process.
 func (inst *Instance) Stop(timeout time.Duration, force bool) error {
 	// Attempt to stop the same process from several goroutines
@@ -94,58 +541,87 @@ func (inst *Instance) Stop(timeout time.Duration, force bool) error {
 	defer inst.mutex.Unlock()
 
 	// Instance shouldnёt be restarted if a stop command was received for it
-	inst.Restartable = false
-
-	// Check if the process is running by sending a signal "0".
-	if !inst.IsAlive() {
-		return nil
+	inst.stopped = true
+	if inst.restartTimer != nil {
+		inst.restartTimer.Stop()
+		inst.infoMutex.Lock()
+		inst.nextRestart = time.Time{}
+		inst.infoMutex.Unlock()
 	}
 
-	// First of all start wait for the process to terminate.
-	// The inst.done channel is initialized on the first
-	// attempt to terminate the Instance.
-	if inst.done == nil {
-		inst.done = make(chan error, 1)
-		go func() {
-			inst.done <- inst.Cmd.Wait()
-		}()
-	}
+	return inst.terminate(timeout, force)
+}
 
-	// Trying to terminate the process by using a "SIGINT" signal.
-	// In case of failure and if the force is "true", a "SIGKILL"
-	// signal will be used.
-	if err := inst.Cmd.Process.Signal(os.Interrupt); err != nil {
-		return err
-	}
+// cancelStop cancels the effect of the failed Stop: the Instance
+// will be restarted according to its restart policy again.
+func (inst *Instance) cancelStop() {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	inst.stopped = false
+}
 
-	select {
-	case <-time.After(timeout):
-		if !force {
-			return errors.New("The process couldn't be terminated correctly.")
-		}
-		// Send "SIGKILL" signal
-		if err := inst.Cmd.Process.Kill(); err != nil {
-			return err
-		} else {
-			// Wait for the process to terminate.
-			_ = <-inst.done
-			return nil
-		}
-	case err := <-inst.done:
-		return err
+// terminateUnhealthy terminates the unhealthy Instance. Unlike Stop,
+// the Instance will be restarted according to its restart policy.
+func (inst *Instance) terminateUnhealthy(timeout time.Duration) error {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+
+	// The Instance is being stopped by a client.
+	if inst.stopped {
+		return nil
 	}
+	return inst.terminate(timeout, true)
 }
 
 // Status returns the current status of the Instance.
 func (inst *Instance) Status() *InstanceStatus {
+	inst.infoMutex.RLock()
 	res := InstanceStatus{
-		Name:        inst.Name,
-		Pid:         inst.Cmd.Process.Pid,
-		Restartable: inst.Restartable,
-		Env:         inst.Env,
+		ID:             inst.id,
+		Key:            inst.key,
+		Name:           inst.Spec.Name,
+		Pid:            inst.Cmd.Process.Pid,
+		Declared:       inst.declared,
+		Restartable:    inst.Spec.isRestartable(),
+		RestartPolicy:  inst.Spec.RestartPolicy,
+		RestartCount:   inst.restartCount,
+		Env:            inst.Spec.Env,
+		StartTime:      inst.StartTime,
+		LastExit:       inst.lastExit,
+		ReadinessError: inst.readinessErr,
+		LivenessError:  inst.livenessErr,
+		StartError:     inst.startErr,
+		BoxInfo:        inst.boxInfo,
+		WorkDir:        inst.workDir,
+	}
+	if len(inst.exitHistory) != 0 {
+		res.ExitHistory = make([]*ExitStatus, len(inst.exitHistory))
+		copy(res.ExitHistory, inst.exitHistory)
+	}
+	if !inst.nextRestart.IsZero() {
+		nextRestart := inst.nextRestart
+		res.NextRestart = &nextRestart
+	}
+	failed := inst.failed
+	cgroupErr := inst.cgroupErr
+	ready := inst.ready
+	unhealthy := inst.unhealthy
+	inst.infoMutex.RUnlock()
+
+	alive := inst.IsAlive()
+	if alive {
+		// The limits are unknown if the process has just been terminated.
+		res.Rlimits, _ = procRlimits(res.Pid)
 	}
-	if inst.IsAlive() {
+	res.Cgroup = inst.cgroupStatus(cgroupErr)
+	if failed && !alive {
+		res.State = stateFailed
+	} else if unhealthy {
+		res.State = stateUnhealthy
+	} else if alive && ready {
 		res.State = stateRunning
+	} else if alive {
+		res.State = stateStarting
 	} else {
 		res.State = stateTerminated
 	}
@@ -1,9 +1,13 @@
 package core
 
 import (
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -28,7 +32,7 @@ func startTestInstance(t *testing.T, isSignalIgnore bool) *Instance {
 		env = append(env, "INSTSIGIGNORE=true")
 	}
 	cmd.Env = append(os.Environ(), env...)
-	inst := NewInstance(testInstName, cmd, env, false)
+	inst := NewInstance(&InstanceSpec{Name: testInstName, Env: env}, cmd)
 
 	// Start the Instance.
 	err = inst.Start()
@@ -39,12 +43,37 @@ func startTestInstance(t *testing.T, isSignalIgnore bool) *Instance {
 		"The Instance is not running.")
 
 	// We need to wait for the new process to set handlers.
-	// It is necessary to update for more correct synchronization.
-	time.Sleep(100 * time.Millisecond)
+	waitSignalHandlers(t, inst.Cmd.Process.Pid)
 
 	return inst
 }
 
+// waitSignalHandlers waits for the process to set the "SIGINT" and
+// "SIGTERM" handlers. The process start can take a while (the interpreter
+// can be run through a wrapper script), so a fixed delay isn't enough.
+func waitSignalHandlers(t *testing.T, pid int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		data, err := ioutil.ReadFile(procPath(pid, "status"))
+		if err != nil {
+			break
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if !strings.HasPrefix(line, "SigCgt:") {
+				continue
+			}
+			mask, err := strconv.ParseUint(strings.TrimSpace(line[7:]), 16, 64)
+			// The bit of the signal N is N - 1.
+			handlers := uint64(1<<(syscall.SIGINT-1) | 1<<(syscall.SIGTERM-1))
+			if err == nil && mask&handlers == handlers {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("The process %v hasn't set the signal handlers.", pid)
+}
+
 // cleanupTestInstances sends a SIGKILL signal to all test
 // Instances that remain alive after the test done.
 func cleanupTestInstances(insts []*Instance) {
@@ -101,3 +130,24 @@ func TestSignalIgnoreInstance(t *testing.T) {
 	assert.Equal(inst.Status().State, stateTerminated,
 		"The Instance hasn't been terminated.")
 }
+
+// A test in which the Instance can't be restarted.
+func TestFailedRestartInstance(t *testing.T) {
+	assert := assert.New(t)
+	var insts []*Instance
+	t.Cleanup(func() { cleanupTestInstances(insts) })
+
+	inst := startTestInstance(t, false)
+	insts = append(insts, inst)
+	pid := inst.Pid()
+	inst.Cmd.Process.Kill()
+	inst.Cmd.Wait()
+
+	// The executable file has gone.
+	inst.path = path.Join(t.TempDir(), testInstName)
+	assert.NotNil(inst.Restart(), "The Instance has been restarted.")
+
+	// The information about the terminated process is kept.
+	assert.Equal(pid, inst.Pid())
+	assert.Equal(stateTerminated, inst.Status().State)
+}
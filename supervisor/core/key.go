@@ -0,0 +1,78 @@
+package core
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+)
+
+// keySuffixRe matches a valid suffix of the Instance key.
+var keySuffixRe = regexp.MustCompile(`^[A-Za-z0-9_.-]*$`)
+
+// validateKeySuffix checks the suffix of the Instance key.
+func validateKeySuffix(suffix string) error {
+	if !keySuffixRe.MatchString(suffix) {
+		return errors.New(`Invalid key suffix "` + suffix + `": only letters, digits, ` +
+			`"_", "." and "-" are allowed.`)
+	}
+	return nil
+}
+
+// key returns the key of the Instance: the name of the
+// Instance with the suffix (if any) separated by "-".
+func (spec *InstanceSpec) key() string {
+	if spec.KeySuffix == "" {
+		return spec.Name
+	}
+	return spec.Name + "-" + spec.KeySuffix
+}
+
+// KeyUsedError is returned if the key of a new
+// Instance is already used by another Instance.
+type KeyUsedError struct {
+	// Key is the key of the new Instance.
+	Key string
+	// ID is the ID of the Instance that uses the key.
+	ID int
+}
+
+// Error returns the description of the error.
+func (err *KeyUsedError) Error() string {
+	return `The key "` + err.Key + `" is already used by the Instance with id ` +
+		strconv.Itoa(err.ID) + "."
+}
+
+// reserveKey reserves the key for the Instance with the ID. If the key
+// is used by another Instance and allowDuplicate is "true", the ID is
+// appended to the key ("<key>-<id>"). Returns the reserved key.
+// The "instMapMutex" should be locked.
+func (sv *Supervisor) reserveKey(key string, id int, allowDuplicate bool) (string, error) {
+	if _, ok := sv.idsByKey[key]; ok && allowDuplicate {
+		key += "-" + strconv.Itoa(id)
+	}
+	if usedBy, ok := sv.idsByKey[key]; ok {
+		return "", &KeyUsedError{Key: key, ID: usedBy}
+	}
+	sv.idsByKey[key] = id
+	return key, nil
+}
+
+// releaseKey releases the key reserved by the Instance with the ID.
+// The "instMapMutex" should be locked.
+func (sv *Supervisor) releaseKey(key string, id int) {
+	if sv.idsByKey[key] == id {
+		delete(sv.idsByKey, key)
+	}
+}
+
+// InstanceId returns the ID of the Instance by the key.
+func (sv *Supervisor) InstanceId(key string) (int, error) {
+	sv.instMapMutex.RLock()
+	defer sv.instMapMutex.RUnlock()
+	id, ok := sv.idsByKey[key]
+	// The key could be reserved by an Instance that is being started.
+	if !ok || sv.instancesById[id] == nil {
+		return 0, errors.New(`Unknown instance with key "` + key + `"`)
+	}
+	return id, nil
+}
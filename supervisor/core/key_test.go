@@ -0,0 +1,82 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInstanceKey checks the keys of the Instances.
+func TestInstanceKey(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("storage", (&InstanceSpec{Name: "storage"}).key())
+	assert.Equal("storage-a-1", (&InstanceSpec{Name: "storage", KeySuffix: "a-1"}).key())
+
+	for _, suffix := range []string{"", "a-1", "replica_2.b"} {
+		assert.Nilf(validateKeySuffix(suffix), "The suffix %q is invalid.", suffix)
+	}
+	for _, suffix := range []string{"a/b", "a b", "ключ"} {
+		assert.NotNilf(validateKeySuffix(suffix), "The suffix %q is valid.", suffix)
+	}
+	assert.NotNil((&InstanceSpec{Name: "storage", KeySuffix: "../a"}).validate())
+}
+
+// Test the lookup of the Instances by the key.
+func TestSupervisorInstanceKey(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, nil)
+
+	id, err := sv.StartInstance(&InstanceSpec{Name: "test_instance"})
+	if !assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err) {
+		return
+	}
+	waitSignalHandlers(t, sv.getInstance(id).Pid())
+	suffixId, err := sv.StartInstance(&InstanceSpec{Name: "test_instance", KeySuffix: "a"})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+
+	// The duplicate start is rejected unless it is allowed.
+	_, err = sv.StartInstance(&InstanceSpec{Name: "test_instance"})
+	assert.EqualError(err, `The key "test_instance" is already used by the Instance with id 1.`)
+	var keyUsed *KeyUsedError
+	if assert.True(errors.As(err, &keyUsed)) {
+		assert.Equal(&KeyUsedError{Key: "test_instance", ID: id}, keyUsed)
+	}
+	dupId, err := sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance"})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+	assert.Equal(3, dupId, "The ID of the rejected Instance has been reserved.")
+
+	// The key of the Instance that has failed to start is released.
+	_, err = sv.StartInstance(&InstanceSpec{Name: "unknown_instance"})
+	var invalidSpec *InvalidSpecError
+	assert.True(errors.As(err, &invalidSpec), "The unknown Instance isn't a validation error.")
+	_, err = sv.InstanceId("unknown_instance")
+	assert.EqualError(err, `Unknown instance with key "unknown_instance"`)
+
+	keys := map[string]int{"test_instance": id, "test_instance-a": suffixId,
+		"test_instance-3": dupId}
+	instances := sv.ListInstances()
+	assert.Len(instances, len(keys))
+	for key, keyId := range keys {
+		foundId, err := sv.InstanceId(key)
+		assert.Nil(err)
+		assert.Equal(keyId, foundId)
+		if assert.Contains(instances, key) {
+			assert.Equal(keyId, instances[key].ID)
+			assert.Equal(key, instances[key].Key)
+		}
+	}
+
+	// The key is kept on the restart and released on the stop.
+	assert.Nil(sv.RestartInstance(id, RestartModeForce))
+	foundId, err := sv.InstanceId("test_instance")
+	assert.Nil(err)
+	assert.Equal(id, foundId)
+	waitSignalHandlers(t, sv.getInstance(id).Pid())
+	assert.Nil(sv.StopInstance(id, true))
+	_, err = sv.InstanceId("test_instance")
+	assert.NotNil(err)
+	_, err = sv.StartInstance(&InstanceSpec{Name: "test_instance"})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// startProbes starts the readiness and the liveness probes
+// and the "box.info" polling of the current Instance process.
+func (sv *Supervisor) startProbes(id int, inst *Instance) {
+	go sv.probeReadiness(id, inst)
+	go sv.probeLiveness(id, inst)
+	go sv.pollBoxInfo(id, inst)
+}
+
+// probeLiveness periodically checks the health of the current Instance
+// process after it becomes ready. If the check fails "FailureThreshold"
+// times in a row, the Instance is considered unhealthy and restarted.
+func (sv *Supervisor) probeLiveness(id int, inst *Instance) {
+	spec := inst.Spec.Liveness
+	if spec == nil {
+		return
+	}
+	process, readyCh := inst.readiness()
+	env := append(os.Environ(), inst.Spec.Env...)
+
+	// The Instance can't be healthy until it is ready.
+	if !waitProcessReady(process, readyCh) {
+		return
+	}
+
+	failures := 0
+	ticker := time.NewTicker(spec.interval())
+	defer ticker.Stop()
+	for range ticker.C {
+		if process.Signal(syscall.Signal(0)) != nil {
+			return
+		}
+		err := spec.check(env)
+		if err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+		unhealthy := failures >= spec.failureThreshold()
+		if !inst.setLiveness(process, err, unhealthy) {
+			return
+		}
+		if unhealthy {
+			sv.restartUnhealthyInstance(id, inst, process, err)
+			return
+		}
+	}
+}
+
+// restartUnhealthyInstance terminates the unhealthy Instance process
+// and restarts it as a failed one (see RestartAfterTermInstance).
+func (sv *Supervisor) restartUnhealthyInstance(id int, inst *Instance,
+	process *os.Process, reason error) {
+	log.Printf(`The Instance is unhealthy and will be restarted. ID: %v. Error: "%v"`,
+		id, reason)
+	sv.publishEvent(EventUnhealthy, id, inst, nil, reason)
+
+	if err := inst.terminateUnhealthy(time.Duration(sv.config().TermTimeout)); err != nil {
+		log.Printf(`Can't terminate the unhealthy Instance. ID: %v. Error: "%v"`,
+			id, err)
+		return
+	}
+
+	// If the process has been reaped by the "SIGCHLD" handler, it
+	// will handle the termination. The termination of an adopted
+	// process can't be noticed by it, so let's handle it here.
+	inst.infoMutex.RLock()
+	var status *ExitStatus
+	if inst.Cmd.Process == process && inst.Cmd.ProcessState != nil {
+		status = exitStatusFromState(process.Pid, inst.Cmd.ProcessState)
+	}
+	adopted := inst.adopted
+	inst.infoMutex.RUnlock()
+	if status == nil && !adopted {
+		return
+	}
+
+	if _, delay, err := sv.RestartAfterTermInstance(process.Pid, status); err != nil {
+		log.Printf(`The Instance won't be restarted. ID: %v. Error: "%v"`, id, err)
+	} else {
+		log.Printf("The Instance restart has been scheduled. ID: %v. Delay: %v",
+			id, delay)
+	}
+}
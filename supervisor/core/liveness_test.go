@@ -0,0 +1,60 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the restart of the unhealthy Instance.
+func TestSupervisorLiveness(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, func(cfg *Cfg) {
+		cfg.Restart = RestartCfg{
+			BackoffInitial: Duration(10 * time.Millisecond),
+			BackoffMax:     Duration(10 * time.Millisecond),
+			Window:         Duration(time.Minute),
+		}
+		cfg.EventHistorySize = 100
+	})
+	_, events, cancel := sv.SubscribeEvents(0)
+	defer cancel()
+
+	healthPath := filepath.Join(t.TempDir(), "healthy")
+	assert.Nil(ioutil.WriteFile(healthPath, nil, 0644))
+	// The Instance terminated by the Supervisor is considered
+	// failed, so it should be restarted.
+	id, err := sv.StartInstance(&InstanceSpec{Name: "test_instance",
+		RestartPolicy: RestartOnFailure,
+		Liveness: &ProbeSpec{Type: ProbeFile, Path: healthPath,
+			Interval: Duration(10 * time.Millisecond), FailureThreshold: 2}})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+	waitEvent(t, events, EventStarted)
+	pid := sv.getInstance(id).Pid()
+	waitSignalHandlers(t, pid)
+
+	// The Instance is healthy.
+	time.Sleep(50 * time.Millisecond)
+	status, _ := sv.GetInstanceStatus(id)
+	assert.Equal(stateRunning, status.State)
+
+	// And now it hangs.
+	assert.Nil(os.Remove(healthPath))
+	if event := waitEvent(t, events, EventUnhealthy); event != nil {
+		assert.Equal(pid, event.Pid)
+		assert.NotEmpty(event.Err)
+	}
+	waitEvent(t, events, EventExited)
+	waitEvent(t, events, EventRestarted)
+
+	status, _ = sv.GetInstanceStatus(id)
+	assert.NotEqual(pid, status.Pid, "The Instance hasn't been restarted.")
+	assert.Equal(1, status.RestartCount)
+	if assert.NotNil(status.LastExit) {
+		assert.Equal(pid, status.LastExit.Pid)
+	}
+}
@@ -0,0 +1,348 @@
+package core
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the format of the time in names of rotated log files.
+// The names are sorted in chronological order.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// LogCfg describes the settings of the Instance output logs.
+type LogCfg struct {
+	// MaxSize - the maximum size of a log file (in megabytes)
+	// before it gets rotated. 0 disables the size-based rotation.
+	MaxSize int `json:"max_size"`
+	// MaxAge - the maximum time to write to a log file before
+	// it gets rotated. 0 disables the age-based rotation.
+	MaxAge Duration `json:"max_age"`
+	// MaxBackups - the maximum number of rotated log files to
+	// retain. 0 means retain all the rotated files.
+	MaxBackups int `json:"max_backups"`
+	// Compress - compress the rotated log files by using gzip.
+	Compress bool `json:"compress"`
+}
+
+// LogSpec describes per-Instance overrides of the log settings.
+// The fields that aren't set are taken from the Supervisor config.
+type LogSpec struct {
+	// MaxSize - see LogCfg.MaxSize.
+	MaxSize *int `json:"max_size,omitempty" mapstructure:"max_size"`
+	// MaxAge - see LogCfg.MaxAge.
+	MaxAge *Duration `json:"max_age,omitempty" mapstructure:"max_age"`
+	// MaxBackups - see LogCfg.MaxBackups.
+	MaxBackups *int `json:"max_backups,omitempty" mapstructure:"max_backups"`
+	// Compress - see LogCfg.Compress.
+	Compress *bool `json:"compress,omitempty" mapstructure:"compress"`
+}
+
+// override returns the log settings overridden by the Instance settings.
+func (cfg LogCfg) override(spec *LogSpec) LogCfg {
+	if spec == nil {
+		return cfg
+	}
+	if spec.MaxSize != nil {
+		cfg.MaxSize = *spec.MaxSize
+	}
+	if spec.MaxAge != nil {
+		cfg.MaxAge = *spec.MaxAge
+	}
+	if spec.MaxBackups != nil {
+		cfg.MaxBackups = *spec.MaxBackups
+	}
+	if spec.Compress != nil {
+		cfg.Compress = *spec.Compress
+	}
+	return cfg
+}
+
+// validate checks the log settings.
+func (cfg *LogCfg) validate() error {
+	if cfg.MaxSize < 0 || cfg.MaxAge < 0 || cfg.MaxBackups < 0 {
+		return errors.New("The log settings can't be negative.")
+	}
+	return nil
+}
+
+// logFile is a log file with size- and age-based rotation.
+// It can be shared by several Instances with the same name.
+type logFile struct {
+	// path is the path to the log file.
+	path string
+	// cfg describes the rotation settings.
+	cfg LogCfg
+	// mutex is used to serialize writes and rotations.
+	mutex sync.Mutex
+	// file is the current log file (nil if closed).
+	file *os.File
+	// size is the size of the current log file.
+	size int64
+	// openTime is the time at which the current log file has been opened.
+	openTime time.Time
+	// refs is the number of users of the log file.
+	refs int
+	// backupMutex is used to serialize compression
+	// and removal of the rotated log files.
+	backupMutex sync.Mutex
+}
+
+// newLogFile creates and opens a log file.
+func newLogFile(path string, cfg LogCfg) (*logFile, error) {
+	lf := &logFile{path: path, cfg: cfg}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+// open opens the log file for appending.
+func (lf *logFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(lf.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(lf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	lf.file = file
+	lf.size = info.Size()
+	lf.openTime = time.Now()
+	return nil
+}
+
+// Write writes the data to the log file and rotates it if necessary.
+func (lf *logFile) Write(data []byte) (int, error) {
+	lf.mutex.Lock()
+	defer lf.mutex.Unlock()
+
+	if lf.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	maxSize := int64(lf.cfg.MaxSize) * 1024 * 1024
+	sizeExceeded := maxSize > 0 && lf.size > 0 && lf.size+int64(len(data)) > maxSize
+	ageExceeded := lf.cfg.MaxAge > 0 &&
+		time.Since(lf.openTime) > time.Duration(lf.cfg.MaxAge)
+	if sizeExceeded || ageExceeded {
+		if err := lf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := lf.file.Write(data)
+	lf.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current log file and opens a new one.
+// The rotated file will be compressed and old rotated files
+// will be removed in the background. If the log file can't be
+// rotated, the current file is kept open, so the writes continue.
+func (lf *logFile) rotate() error {
+	backupPath := lf.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(lf.path, backupPath); err != nil {
+		return err
+	}
+	oldFile := lf.file
+	if err := lf.open(); err != nil {
+		// Return the current file back to keep writing to it.
+		if renameErr := os.Rename(backupPath, lf.path); renameErr != nil {
+			log.Printf(`Can't restore the log file "%v". Error: "%v"`,
+				lf.path, renameErr)
+		}
+		return err
+	}
+
+	go lf.processBackups(backupPath)
+	return oldFile.Close()
+}
+
+// processBackups compresses the rotated log file (if it is enabled)
+// and removes the rotated files exceeding the retention count.
+func (lf *logFile) processBackups(backupPath string) {
+	lf.backupMutex.Lock()
+	defer lf.backupMutex.Unlock()
+
+	if lf.cfg.Compress {
+		if err := compressFile(backupPath); err == nil {
+			os.Remove(backupPath)
+		}
+	}
+
+	if lf.cfg.MaxBackups <= 0 {
+		return
+	}
+	backups, err := lf.backups()
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(backups)-lf.cfg.MaxBackups; i++ {
+		os.Remove(backups[i])
+	}
+}
+
+// backups returns the paths of the rotated log files (the oldest first).
+func (lf *logFile) backups() ([]string, error) {
+	dir := filepath.Dir(lf.path)
+	prefix := filepath.Base(lf.path) + "."
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, file := range files {
+		name := file.Name()
+		if !file.Mode().IsRegular() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		timestamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz")
+		if _, err := time.Parse(backupTimeFormat, timestamp); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(dir, name))
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return strings.TrimSuffix(backups[i], ".gz") < strings.TrimSuffix(backups[j], ".gz")
+	})
+	return backups, nil
+}
+
+// compressFile compresses the file to "<path>.gz".
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gzWriter := gzip.NewWriter(dst)
+	if _, err := io.Copy(gzWriter, src); err != nil {
+		gzWriter.Close()
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := gzWriter.Close(); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	return dst.Close()
+}
+
+// close closes the log file.
+func (lf *logFile) close() error {
+	lf.mutex.Lock()
+	defer lf.mutex.Unlock()
+	if lf.file == nil {
+		return nil
+	}
+	err := lf.file.Close()
+	lf.file = nil
+	return err
+}
+
+// logFiles is a set of log files shared by Instances.
+type logFiles struct {
+	// mutex is used to work with the map of log files.
+	mutex sync.Mutex
+	// files is a map of a path to the log file.
+	files map[string]*logFile
+}
+
+// acquire returns the log file by the path. The log file is opened
+// if necessary. If the log file is already used with other settings,
+// an error is returned. The log file should be released after use.
+func (files *logFiles) acquire(path string, cfg LogCfg) (*logFile, error) {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+	if files.files == nil {
+		files.files = make(map[string]*logFile)
+	}
+
+	lf, ok := files.files[path]
+	if ok && lf.cfg != cfg {
+		return nil, errors.New(`The log file "` + path +
+			`" is already used with other rotation settings (see "log").`)
+	}
+	if !ok {
+		var err error
+		if lf, err = newLogFile(path, cfg); err != nil {
+			return nil, err
+		}
+		files.files[path] = lf
+	}
+	lf.refs++
+	return lf, nil
+}
+
+// release releases the log file. The log file
+// is closed when it isn't used anymore.
+func (files *logFiles) release(lf *logFile) {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+	lf.refs--
+	if lf.refs <= 0 {
+		delete(files.files, lf.path)
+		lf.close()
+	}
+}
+
+// openInstanceOutput prepares the capturing of the Instance output:
+// opens the log file (if the logs directory is set) and creates the
+// buffer of the last output lines (if its size isn't 0). The buffer
+// already set (the buffer of the replaced Instance) is kept, so its
+// followers receive the output of the new process.
+func (sv *Supervisor) openInstanceOutput(inst *Instance) error {
+	svCfg := sv.config()
+	if svCfg.OutputBufferLines > 0 && inst.output == nil {
+		inst.output = newOutputBuffer(svCfg.OutputBufferLines)
+	}
+	if svCfg.LogsDir == "" {
+		return nil
+	}
+	cfg := svCfg.Log.override(inst.Spec.Log)
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	var err error
+	inst.log, err = sv.logs.acquire(filepath.Join(svCfg.LogsDir,
+		inst.Spec.Name+".log"), cfg)
+	return err
+}
+
+// releaseInstanceLog releases the log file of the Instance.
+func (sv *Supervisor) releaseInstanceLog(inst *Instance) {
+	if inst.log != nil {
+		sv.logs.release(inst.log)
+	}
+}
+
+// closeInstanceOutput releases the log file of the
+// removed Instance and closes its output followers.
+func (sv *Supervisor) closeInstanceOutput(inst *Instance) {
+	sv.releaseInstanceLog(inst)
+	if inst.output != nil {
+		inst.output.close()
+	}
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLogFileRotation checks the size-based rotation, compression
+// and retention of the log files.
+func TestLogFileRotation(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "test.log")
+
+	var files logFiles
+	lf, err := files.acquire(logPath, LogCfg{MaxSize: 1, MaxBackups: 2, Compress: true})
+	assert.Nilf(err, `Can't open the log file. Error: "%v"`, err)
+
+	// The log file is shared only with the same settings.
+	_, err = files.acquire(logPath, LogCfg{MaxSize: 2, MaxBackups: 2, Compress: true})
+	assert.NotNil(err, "The log file is shared with other settings.")
+	sameLf, err := files.acquire(logPath, LogCfg{MaxSize: 1, MaxBackups: 2, Compress: true})
+	if assert.Nilf(err, `Can't share the log file. Error: "%v"`, err) {
+		assert.Same(lf, sameLf)
+		files.release(sameLf)
+	}
+
+	// Each write fills a half of the file, so every
+	// second write leads to the rotation.
+	chunk := []byte(strings.Repeat("a", 512*1024))
+	for i := 0; i < 8; i++ {
+		_, err := lf.Write(chunk)
+		assert.Nilf(err, `Can't write to the log file. Error: "%v"`, err)
+		// The rotated files should have different names.
+		time.Sleep(2 * time.Millisecond)
+	}
+	files.release(lf)
+
+	// Wait for the background processing of the rotated files.
+	var backups []string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		backups, err = lf.backups()
+		assert.Nilf(err, `Can't get the rotated files. Error: "%v"`, err)
+		if len(backups) == 2 && strings.HasSuffix(backups[0], ".gz") &&
+			strings.HasSuffix(backups[1], ".gz") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(2, len(backups), "Unexpected number of the rotated files.")
+	for _, backup := range backups {
+		assert.True(strings.HasSuffix(backup, ".gz"), "The rotated file isn't compressed.")
+	}
+
+	data, err := ioutil.ReadFile(logPath)
+	assert.Nilf(err, `Can't read the log file. Error: "%v"`, err)
+	assert.Equal(2*len(chunk), len(data), "Unexpected size of the log file.")
+
+	// The closed log file shouldn't be writable.
+	_, err = lf.Write(chunk)
+	assert.NotNil(err, "The closed log file is writable.")
+}
+
+// TestLogFileRotationError checks that the log file is still
+// writable if it can't be rotated.
+func TestLogFileRotationError(t *testing.T) {
+	assert := assert.New(t)
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	lf, err := newLogFile(logPath, LogCfg{MaxSize: 1})
+	assert.Nilf(err, `Can't open the log file. Error: "%v"`, err)
+	defer lf.file.Close()
+
+	// The rotation fails, because the log file has been removed.
+	assert.Nil(os.Remove(logPath))
+	assert.NotNil(lf.rotate(), "The removed log file has been rotated.")
+	if assert.NotNil(lf.file, "The log file has been closed.") {
+		_, err = lf.file.Write([]byte("test"))
+		assert.Nilf(err, `Can't write to the log file. Error: "%v"`, err)
+	}
+}
+
+// TestInstanceOutput checks that the output of an Instance is captured.
+func TestInstanceOutput(t *testing.T) {
+	assert := assert.New(t)
+	logsDir := t.TempDir()
+	sv := newTestSupervisor(t, func(cfg *Cfg) {
+		cfg.LogsDir = logsDir
+		cfg.OutputBufferLines = 10
+	})
+
+	id, err := sv.StartInstance(&InstanceSpec{Name: "test_instance"})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+
+	logPath := filepath.Join(logsDir, "test_instance.log")
+	deadline := time.Now().Add(5 * time.Second)
+	var data []byte
+	for time.Now().Before(deadline) {
+		data, _ = ioutil.ReadFile(logPath)
+		if strings.Contains(string(data), "started") &&
+			strings.Contains(string(data), "error") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Contains(string(data), "The instance has been started.")
+	assert.Contains(string(data), "An error message.")
+
+	// The last lines are available from the output buffer.
+	filter, err := NewOutputFilter(StreamStderr, "", false)
+	assert.Nil(err)
+	lines, err := sv.GetInstanceOutput(id, 10, filter)
+	assert.Nilf(err, `Can't get the Instance output. Error: "%v"`, err)
+	if assert.Equal(1, len(lines), "Unexpected number of lines.") {
+		assert.Equal("An error message.", lines[0].Text)
+	}
+
+	_, err = sv.GetInstanceOutput(id+1, 10, nil)
+	assert.NotNil(err, "The output of an unknown Instance is returned.")
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tarantool/tvisor/supervisor/metrics"
+)
+
+// newStopDurations creates the histogram of the Instance stop durations.
+func newStopDurations() *metrics.HistogramVec {
+	return metrics.NewHistogramVec("tvisor_instance_stop_duration_seconds",
+		"Time spent to stop an instance.", nil, "name")
+}
+
+// WriteMetrics writes the metrics of the Instances in
+// the Prometheus text format.
+func (sv *Supervisor) WriteMetrics(w io.Writer) error {
+	type instanceInfo struct {
+		id     int
+		status *InstanceStatus
+		usage  *procUsage
+	}
+
+	sv.instMapMutex.RLock()
+	infos := make([]instanceInfo, 0, len(sv.instancesById))
+	for id, inst := range sv.instancesById {
+		infos = append(infos, instanceInfo{id: id, status: inst.Status()})
+	}
+	sv.instMapMutex.RUnlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].id < infos[j].id })
+
+	allStates := []string{stateStarting, stateRunning, stateUnhealthy,
+		stateTerminated, stateFailed}
+	states := make(map[string]int)
+	var restarts, uptimes, exitCodes, cpuTimes, rss []metrics.Sample
+	now := time.Now()
+	for i := range infos {
+		info := &infos[i]
+		status := info.status
+		labels := []string{strconv.Itoa(info.id), status.Name}
+		states[status.State]++
+		restarts = append(restarts, metrics.Sample{LabelValues: labels,
+			Value: float64(status.RestartCount)})
+		if status.LastExit != nil {
+			exitCodes = append(exitCodes, metrics.Sample{LabelValues: labels,
+				Value: float64(status.LastExit.ExitCode)})
+		}
+		if status.State == stateTerminated || status.State == stateFailed {
+			continue
+		}
+		uptimes = append(uptimes, metrics.Sample{LabelValues: labels,
+			Value: now.Sub(status.StartTime).Seconds()})
+		// The process could be terminated right now.
+		if usage, err := readProcUsage(status.Pid); err == nil {
+			cpuTimes = append(cpuTimes, metrics.Sample{LabelValues: labels,
+				Value: usage.cpuTime})
+			rss = append(rss, metrics.Sample{LabelValues: labels,
+				Value: float64(usage.rss)})
+		}
+	}
+
+	var stateSamples []metrics.Sample
+	for _, state := range allStates {
+		stateSamples = append(stateSamples, metrics.Sample{
+			LabelValues: []string{state}, Value: float64(states[state])})
+	}
+
+	instLabels := []string{"id", "name"}
+	families := []struct {
+		name       string
+		help       string
+		metricType string
+		labelNames []string
+		samples    []metrics.Sample
+	}{
+		{"tvisor_instances", "Number of instances by state.",
+			metrics.TypeGauge, []string{"state"}, stateSamples},
+		{"tvisor_instance_restarts_total", "Number of restarts of the instance.",
+			metrics.TypeCounter, instLabels, restarts},
+		{"tvisor_instance_uptime_seconds", "Time since the instance process has been started.",
+			metrics.TypeGauge, instLabels, uptimes},
+		{"tvisor_instance_last_exit_code",
+			"Exit code of the last instance process (-1 if it has been killed by a signal).",
+			metrics.TypeGauge, instLabels, exitCodes},
+		{"tvisor_instance_cpu_seconds_total", "User and system CPU time of the instance process.",
+			metrics.TypeCounter, instLabels, cpuTimes},
+		{"tvisor_instance_resident_memory_bytes", "Resident memory size of the instance process.",
+			metrics.TypeGauge, instLabels, rss},
+	}
+	for _, family := range families {
+		if err := metrics.WriteFamily(w, family.name, family.help, family.metricType,
+			family.labelNames, family.samples); err != nil {
+			return err
+		}
+	}
+	return sv.stopDurations.Write(w)
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReadProcUsage checks reading of the resource usage of a process.
+func TestReadProcUsage(t *testing.T) {
+	assert := assert.New(t)
+	usage, err := readProcUsage(os.Getpid())
+	assert.Nilf(err, `Can't read the resource usage. Error: "%v"`, err)
+	if usage != nil {
+		assert.True(usage.rss > 0, "The resident memory size is unknown.")
+	}
+	_, err = readProcUsage(0)
+	assert.NotNil(err, "The resource usage of an unknown process is read.")
+}
+
+// Test the metrics of the Instances.
+func TestSupervisorMetrics(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, nil)
+
+	id, err := sv.StartInstance(&InstanceSpec{Name: "test_instance",
+		RestartPolicy: RestartNever})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+
+	var buf bytes.Buffer
+	assert.Nil(sv.WriteMetrics(&buf))
+	labels := `{id="` + strconv.Itoa(id) + `",name="test_instance"}`
+	metrics := buf.String()
+	assert.Contains(metrics, `tvisor_instances{state="running"} 1`)
+	assert.Contains(metrics, `tvisor_instances{state="failed"} 0`)
+	assert.Contains(metrics, "tvisor_instance_restarts_total"+labels+" 0")
+	assert.Contains(metrics, "tvisor_instance_uptime_seconds"+labels)
+	assert.Contains(metrics, "tvisor_instance_cpu_seconds_total"+labels)
+	assert.Contains(metrics, "tvisor_instance_resident_memory_bytes"+labels)
+
+	waitSignalHandlers(t, sv.getInstance(id).Pid())
+	assert.Nil(sv.StopInstance(id, true))
+	buf.Reset()
+	assert.Nil(sv.WriteMetrics(&buf))
+	metrics = buf.String()
+	assert.Contains(metrics, `tvisor_instances{state="running"} 0`)
+	assert.Contains(metrics, `tvisor_instance_stop_duration_seconds_count{name="test_instance"} 1`)
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Output streams.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// followBufferSize is the size of the channel buffer used to follow
+// an Instance output. If a follower is too slow, lines will be dropped.
+const followBufferSize = 256
+
+// OutputLine describes a line of the Instance output.
+type OutputLine struct {
+	// Time is the time at which the line has been read.
+	Time time.Time `json:"time"`
+	// Stream is the name of the stream: "stdout" or "stderr".
+	Stream string `json:"stream"`
+	// Text is the line without a trailing newline.
+	Text string `json:"text"`
+}
+
+// OutputFilter describes a filter of the Instance output lines.
+type OutputFilter struct {
+	// stream is the name of the stream ("" - all streams).
+	stream string
+	// substr is a substring to search for ("" - any line).
+	substr string
+	// re is a regular expression to search for (nil - any line).
+	re *regexp.Regexp
+}
+
+// NewOutputFilter creates an OutputFilter.
+// stream - the name of the stream ("" - all streams).
+// pattern - a substring or a regular expression to search for ("" - any line).
+// isRegex - the pattern is a regular expression.
+func NewOutputFilter(stream string, pattern string, isRegex bool) (*OutputFilter, error) {
+	if stream != "" && stream != StreamStdout && stream != StreamStderr {
+		return nil, errors.New(`Unknown stream: "` + stream + `".`)
+	}
+	filter := OutputFilter{stream: stream}
+	if !isRegex {
+		filter.substr = pattern
+		return &filter, nil
+	}
+
+	var err error
+	if filter.re, err = regexp.Compile(pattern); err != nil {
+		return nil, err
+	}
+	return &filter, nil
+}
+
+// Match checks whether the line matches the filter.
+func (filter *OutputFilter) Match(line *OutputLine) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.stream != "" && filter.stream != line.Stream {
+		return false
+	}
+	if filter.re != nil {
+		return filter.re.MatchString(line.Text)
+	}
+	return strings.Contains(line.Text, filter.substr)
+}
+
+// outputBuffer stores the last lines of the Instance output
+// and passes new lines to the followers.
+type outputBuffer struct {
+	// mutex is used to work with the lines and the followers.
+	mutex sync.Mutex
+	// lines is a ring buffer of the last lines.
+	lines []OutputLine
+	// next is the position of the next line in the ring buffer.
+	next int
+	// full indicates that the ring buffer is full.
+	full bool
+	// followers is a set of channels used to follow the output.
+	followers map[chan OutputLine]struct{}
+	// closed indicates that the Instance has been removed
+	// and the output can't be followed anymore.
+	closed bool
+}
+
+// newOutputBuffer creates an outputBuffer that stores "size" last lines.
+func newOutputBuffer(size int) *outputBuffer {
+	return &outputBuffer{
+		lines:     make([]OutputLine, size),
+		followers: make(map[chan OutputLine]struct{}),
+	}
+}
+
+// add adds the line to the buffer and passes it to the followers.
+func (buf *outputBuffer) add(line OutputLine) {
+	buf.mutex.Lock()
+	defer buf.mutex.Unlock()
+
+	if len(buf.lines) != 0 {
+		buf.lines[buf.next] = line
+		buf.next = (buf.next + 1) % len(buf.lines)
+		buf.full = buf.full || buf.next == 0
+	}
+
+	for follower := range buf.followers {
+		// The output of the process shouldn't be
+		// blocked by a slow follower.
+		select {
+		case follower <- line:
+		default:
+		}
+	}
+}
+
+// last returns "n" last lines matching the filter (the oldest first).
+// n <= 0 means all the stored lines.
+func (buf *outputBuffer) last(n int, filter *OutputFilter) []OutputLine {
+	buf.mutex.Lock()
+	defer buf.mutex.Unlock()
+
+	count := buf.next
+	if buf.full {
+		count = len(buf.lines)
+	}
+
+	// Go from the newest line to the oldest one.
+	res := []OutputLine{}
+	for i := 1; i <= count && (n <= 0 || len(res) < n); i++ {
+		line := &buf.lines[(buf.next-i+len(buf.lines))%len(buf.lines)]
+		if filter.Match(line) {
+			res = append(res, *line)
+		}
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res
+}
+
+// follow returns a channel that receives new lines of the output.
+// The channel is closed by unfollow or when the buffer is closed.
+func (buf *outputBuffer) follow() (chan OutputLine, error) {
+	buf.mutex.Lock()
+	defer buf.mutex.Unlock()
+	if buf.closed {
+		return nil, errors.New("The Instance has been removed.")
+	}
+	follower := make(chan OutputLine, followBufferSize)
+	buf.followers[follower] = struct{}{}
+	return follower, nil
+}
+
+// unfollow stops passing new lines to the follower.
+func (buf *outputBuffer) unfollow(follower chan OutputLine) {
+	buf.mutex.Lock()
+	defer buf.mutex.Unlock()
+	if _, ok := buf.followers[follower]; ok {
+		delete(buf.followers, follower)
+		close(follower)
+	}
+}
+
+// close closes all the followers.
+func (buf *outputBuffer) close() {
+	buf.mutex.Lock()
+	defer buf.mutex.Unlock()
+	buf.closed = true
+	for follower := range buf.followers {
+		delete(buf.followers, follower)
+		close(follower)
+	}
+}
+
+// copyOutput copies the output of the process from the pipe to the log
+// file and to the output buffer (if any) until EOF. Write errors are
+// ignored: the pipe should be drained anyway, otherwise the process will
+// be blocked or killed by "SIGPIPE".
+func copyOutput(log io.Writer, output *outputBuffer, stream string, pipe *os.File) {
+	defer pipe.Close()
+	reader := bufio.NewReaderSize(pipe, 64*1024)
+	for {
+		// Too long lines are split by the buffer size.
+		data, err := reader.ReadSlice('\n')
+		if len(data) > 0 {
+			if log != nil {
+				log.Write(data)
+			}
+			if output != nil {
+				output.add(OutputLine{
+					Time:   time.Now(),
+					Stream: stream,
+					Text:   strings.TrimRight(string(data), "\n"),
+				})
+			}
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOutputBuffer checks the ring buffer of the Instance output.
+func TestOutputBuffer(t *testing.T) {
+	assert := assert.New(t)
+	buf := newOutputBuffer(3)
+	assert.Empty(buf.last(0, nil))
+
+	for _, text := range []string{"one", "two", "three", "four"} {
+		buf.add(OutputLine{Stream: StreamStdout, Text: text})
+	}
+	buf.add(OutputLine{Stream: StreamStderr, Text: "five"})
+
+	// Only the last 3 lines are stored (the oldest first).
+	var texts []string
+	for _, line := range buf.last(0, nil) {
+		texts = append(texts, line.Text)
+	}
+	assert.Equal([]string{"three", "four", "five"}, texts)
+	assert.Equal("five", buf.last(1, nil)[0].Text)
+
+	filter, err := NewOutputFilter(StreamStdout, "", false)
+	assert.Nil(err)
+	lines := buf.last(0, filter)
+	assert.Equal(2, len(lines))
+	assert.Equal("four", lines[1].Text)
+
+	// New lines are passed to the followers.
+	follower, err := buf.follow()
+	assert.Nil(err)
+	buf.add(OutputLine{Stream: StreamStdout, Text: "six"})
+	select {
+	case line := <-follower:
+		assert.Equal("six", line.Text)
+	case <-time.After(time.Second):
+		t.Error("The line hasn't been passed to the follower.")
+	}
+
+	// The followers are closed with the buffer.
+	buf.close()
+	_, ok := <-follower
+	assert.False(ok, "The follower hasn't been closed.")
+	_, err = buf.follow()
+	assert.NotNil(err, "The closed buffer can be followed.")
+}
+
+// TestOutputFilter checks the filtering of the Instance output.
+func TestOutputFilter(t *testing.T) {
+	assert := assert.New(t)
+	line := OutputLine{Stream: StreamStderr, Text: "An error message."}
+
+	filter, err := NewOutputFilter("", "error", false)
+	assert.Nil(err)
+	assert.True(filter.Match(&line))
+
+	filter, err = NewOutputFilter(StreamStdout, "error", false)
+	assert.Nil(err)
+	assert.False(filter.Match(&line))
+
+	filter, err = NewOutputFilter(StreamStderr, `^An \w+ message\.$`, true)
+	assert.Nil(err)
+	assert.True(filter.Match(&line))
+
+	_, err = NewOutputFilter("stdin", "", false)
+	assert.NotNil(err, "Unknown stream is accepted.")
+	_, err = NewOutputFilter("", "(", true)
+	assert.NotNil(err, "Invalid regular expression is accepted.")
+}
@@ -0,0 +1,226 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/tarantool/tvisor/supervisor/iproto"
+)
+
+// Probe types.
+const (
+	// ProbeTCP - a TCP connection to the address can be established.
+	ProbeTCP = "tcp"
+	// ProbeUnix - the unix socket exists.
+	ProbeUnix = "unix"
+	// ProbeFile - the file exists.
+	ProbeFile = "file"
+	// ProbeExec - the command exits with the zero code.
+	ProbeExec = "exec"
+	// ProbeIproto - Tarantool responds to the iproto "PING" request.
+	ProbeIproto = "iproto"
+	// ProbeHTTP - the HTTP GET request returns a 2xx / 3xx status.
+	ProbeHTTP = "http"
+)
+
+// Default probe settings.
+const (
+	defaultProbeInterval = time.Second
+	defaultProbeTimeout  = time.Second
+	// defaultFailureThreshold is the default number of consecutive
+	// failed liveness checks after which the Instance is unhealthy.
+	defaultFailureThreshold = 3
+)
+
+// maxProbeOutput is the maximum length of the exec probe
+// output included in the error message.
+const maxProbeOutput = 256
+
+// ProbeSpec describes a check of the Instance state.
+type ProbeSpec struct {
+	// Type - the type of the probe. See probe types.
+	Type string `json:"type"`
+	// Address - "host:port" to connect to (the "tcp" and
+	// the "iproto" probes).
+	Address string `json:"address,omitempty"`
+	// Path - the path to the socket or the file (the "unix" and
+	// the "file" probes). The "iproto" probe connects to the unix
+	// socket if the path is set.
+	Path string `json:"path,omitempty"`
+	// URL - the URL to request (the "http" probe).
+	URL string `json:"url,omitempty"`
+	// Command - the command and its arguments (the "exec" probe).
+	// The command is run with the environment of the Instance.
+	Command []string `json:"command,omitempty"`
+	// Interval - the interval between the checks. Default: 1s.
+	Interval Duration `json:"interval,omitempty"`
+	// Timeout - the timeout of a check. Default: 1s.
+	Timeout Duration `json:"timeout,omitempty"`
+	// FailureThreshold - the number of consecutive failed checks
+	// after which the Instance is considered unhealthy (liveness
+	// probes only). Default: 3.
+	FailureThreshold int `json:"failure_threshold,omitempty" mapstructure:"failure_threshold"`
+}
+
+// validate checks the probe settings.
+func (spec *ProbeSpec) validate() error {
+	switch spec.Type {
+	case ProbeTCP:
+		if spec.Address == "" {
+			return errors.New(`The address of the "tcp" probe is empty.`)
+		}
+	case ProbeUnix, ProbeFile:
+		if spec.Path == "" {
+			return errors.New(`The path of the "` + spec.Type + `" probe is empty.`)
+		}
+	case ProbeExec:
+		if len(spec.Command) == 0 {
+			return errors.New(`The command of the "exec" probe is empty.`)
+		}
+	case ProbeIproto:
+		if spec.Address == "" && spec.Path == "" {
+			return errors.New(`The address of the "iproto" probe is empty.`)
+		}
+	case ProbeHTTP:
+		if spec.URL == "" {
+			return errors.New(`The URL of the "http" probe is empty.`)
+		}
+	default:
+		return errors.New(`Unknown probe type: "` + spec.Type + `".`)
+	}
+	if spec.Interval < 0 || spec.Timeout < 0 || spec.FailureThreshold < 0 {
+		return errors.New("The probe interval, timeout and failure threshold " +
+			"can't be negative.")
+	}
+	return nil
+}
+
+// interval returns the interval between the checks.
+func (spec *ProbeSpec) interval() time.Duration {
+	if spec.Interval == 0 {
+		return defaultProbeInterval
+	}
+	return time.Duration(spec.Interval)
+}
+
+// timeout returns the timeout of a check.
+func (spec *ProbeSpec) timeout() time.Duration {
+	if spec.Timeout == 0 {
+		return defaultProbeTimeout
+	}
+	return time.Duration(spec.Timeout)
+}
+
+// failureThreshold returns the number of consecutive failed
+// checks after which the Instance is unhealthy.
+func (spec *ProbeSpec) failureThreshold() int {
+	if spec.FailureThreshold == 0 {
+		return defaultFailureThreshold
+	}
+	return spec.FailureThreshold
+}
+
+// check performs the check once.
+// env - the environment of the Instance (used by the "exec" probe).
+func (spec *ProbeSpec) check(env []string) error {
+	switch spec.Type {
+	case ProbeTCP:
+		conn, err := net.DialTimeout("tcp", spec.Address, spec.timeout())
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case ProbeUnix:
+		info, err := os.Stat(spec.Path)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSocket == 0 {
+			return errors.New(`"` + spec.Path + `" isn't a socket.`)
+		}
+		return nil
+	case ProbeFile:
+		_, err := os.Stat(spec.Path)
+		return err
+	case ProbeExec:
+		return spec.checkExec(env)
+	case ProbeIproto:
+		return spec.checkIproto()
+	case ProbeHTTP:
+		return spec.checkHTTP()
+	}
+	return errors.New(`Unknown probe type: "` + spec.Type + `".`)
+}
+
+// checkExec runs the command of the "exec" probe.
+func (spec *ProbeSpec) checkExec(env []string) error {
+	return runCommand(spec.Command, env, spec.timeout())
+}
+
+// runCommand runs the command and returns an error with the
+// beginning of its output if the command fails.
+// env - the environment of the command.
+func runCommand(command []string, env []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = env
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.New("The command has timed out.")
+	}
+	if err != nil {
+		msg := strings.TrimSpace(output.String())
+		if len(msg) > maxProbeOutput {
+			msg = msg[:maxProbeOutput]
+		}
+		if msg != "" {
+			return errors.New(err.Error() + ": " + msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkIproto sends the iproto "PING" request.
+func (spec *ProbeSpec) checkIproto() error {
+	network, address := "tcp", spec.Address
+	if spec.Path != "" {
+		network, address = "unix", spec.Path
+	}
+	conn, err := iproto.Dial(network, address, spec.timeout())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Ping()
+}
+
+// checkHTTP sends the HTTP GET request.
+func (spec *ProbeSpec) checkHTTP() error {
+	client := http.Client{Timeout: spec.timeout()}
+	resp, err := client.Get(spec.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Read a part of the body to allow reusing the connection.
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxProbeOutput))
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return errors.New("Unexpected HTTP status: " + resp.Status + ".")
+	}
+	return nil
+}
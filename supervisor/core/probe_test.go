@@ -0,0 +1,87 @@
+package core
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestProbeValidate checks the validation of the probe settings.
+func TestProbeValidate(t *testing.T) {
+	assert := assert.New(t)
+	assert.Nil((&ProbeSpec{Type: ProbeTCP, Address: "127.0.0.1:3301"}).validate())
+	assert.Nil((&ProbeSpec{Type: ProbeExec, Command: []string{"true"}}).validate())
+	assert.NotNil((&ProbeSpec{Type: ProbeTCP}).validate())
+	assert.NotNil((&ProbeSpec{Type: ProbeFile}).validate())
+	assert.NotNil((&ProbeSpec{Type: ProbeExec}).validate())
+	assert.Nil((&ProbeSpec{Type: ProbeIproto, Path: "/var/run/tarantool/app.sock"}).validate())
+	assert.NotNil((&ProbeSpec{Type: ProbeHTTP, Address: "127.0.0.1:80"}).validate())
+	assert.NotNil((&ProbeSpec{Type: "grpc", Address: "127.0.0.1:80"}).validate())
+	assert.NotNil((&ProbeSpec{Type: ProbeFile, Path: "/tmp/ready",
+		Interval: Duration(-time.Second)}).validate())
+}
+
+// TestProbeCheck checks all the probe types.
+func TestProbeCheck(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	// TCP.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nilf(err, `Can't listen. Error: "%v"`, err)
+	tcpProbe := ProbeSpec{Type: ProbeTCP, Address: listener.Addr().String()}
+	assert.Nil(tcpProbe.check(nil))
+	listener.Close()
+	assert.NotNil(tcpProbe.check(nil), "The closed port is ready.")
+
+	// Unix socket.
+	sockPath := filepath.Join(dir, "test.sock")
+	unixProbe := ProbeSpec{Type: ProbeUnix, Path: sockPath}
+	assert.NotNil(unixProbe.check(nil), "The absent socket is ready.")
+	listener, err = net.Listen("unix", sockPath)
+	assert.Nilf(err, `Can't listen. Error: "%v"`, err)
+	assert.Nil(unixProbe.check(nil))
+	listener.Close()
+
+	// File.
+	filePath := filepath.Join(dir, "ready")
+	fileProbe := ProbeSpec{Type: ProbeFile, Path: filePath}
+	assert.NotNil(fileProbe.check(nil), "The absent file is ready.")
+	assert.Nil(ioutil.WriteFile(filePath, nil, 0644))
+	assert.Nil(fileProbe.check(nil))
+	unixProbe.Path = filePath
+	assert.NotNil(unixProbe.check(nil), "The regular file is a socket.")
+
+	// Exec.
+	execProbe := ProbeSpec{Type: ProbeExec,
+		Command: []string{"sh", "-c", `test "$PROBE_VAR" = yes`}}
+	assert.Nil(execProbe.check([]string{"PROBE_VAR=yes"}))
+	assert.NotNil(execProbe.check([]string{"PROBE_VAR=no"}))
+	execProbe = ProbeSpec{Type: ProbeExec, Command: []string{"sleep", "1"},
+		Timeout: Duration(50 * time.Millisecond)}
+	assert.NotNil(execProbe.check(nil), "The probe hasn't timed out.")
+
+	// HTTP.
+	server := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter,
+		req *http.Request) {
+		if req.URL.Path != "/health" {
+			wr.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	httpProbe := ProbeSpec{Type: ProbeHTTP, URL: server.URL + "/health"}
+	assert.Nil(httpProbe.check(nil))
+	httpProbe.URL = server.URL + "/other"
+	assert.NotNil(httpProbe.check(nil), "The unavailable service is healthy.")
+
+	// Iproto (the HTTP server isn't Tarantool).
+	iprotoProbe := ProbeSpec{Type: ProbeIproto, Address: server.Listener.Addr().String(),
+		Timeout: Duration(100 * time.Millisecond)}
+	assert.NotNil(iprotoProbe.check(nil), "The HTTP server responds to iproto.")
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is the number of clock ticks per second (USER_HZ)
+// used by the kernel to report times in "/proc". It is 100 on
+// all the platforms supported by Linux.
+const clockTicks = 100
+
+// procPath returns the path to the file of the process in "/proc".
+func procPath(pid int, name string) string {
+	return filepath.Join("/proc", strconv.Itoa(pid), name)
+}
+
+// procCmdline returns the command line of the process.
+func procCmdline(pid int) ([]string, error) {
+	data, err := ioutil.ReadFile(procPath(pid, "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(data), "\x00"), "\x00"), nil
+}
+
+// procStat returns the fields of "/proc/<pid>/stat" following the
+// command name. So the index of a field is its number in proc(5) minus 3.
+func procStat(pid int) ([]string, error) {
+	data, err := ioutil.ReadFile(procPath(pid, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	// The command name is enclosed in parentheses and can contain
+	// spaces, so let's skip it.
+	stat := string(data)
+	pos := strings.LastIndexByte(stat, ')')
+	if pos < 0 {
+		return nil, errors.New(`Invalid format of "` + procPath(pid, "stat") + `".`)
+	}
+	return strings.Fields(stat[pos+1:]), nil
+}
+
+// bootTime returns the time at which the system booted.
+func bootTime() (time.Time, error) {
+	data, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "btime" {
+			btime, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(btime, 0), nil
+		}
+	}
+	return time.Time{}, errors.New(`Can't find "btime" in "/proc/stat".`)
+}
+
+// procStartTime returns the time at which the process started.
+func procStartTime(pid int) (time.Time, error) {
+	stat, err := procStat(pid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// "starttime" is the field 22.
+	if len(stat) < 20 {
+		return time.Time{}, errors.New(`Invalid format of "` + procPath(pid, "stat") + `".`)
+	}
+	ticks, err := strconv.ParseInt(stat[19], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	btime, err := bootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return btime.Add(time.Duration(ticks) * time.Second / clockTicks), nil
+}
+
+// procRunsScript checks that the command line of the process contains
+// the path to the script: as it has been executed or the absolute one.
+// Tarantool may rewrite its command line to show the status of the
+// instance (for example: "tarantool /path/my_app.lua <running>"), so
+// the command line is split by spaces as well.
+func procRunsScript(pid int, scriptPath string) bool {
+	cmdline, err := procCmdline(pid)
+	if err != nil {
+		return false
+	}
+	paths := map[string]bool{filepath.Clean(scriptPath): true}
+	if absPath, err := filepath.Abs(scriptPath); err == nil {
+		paths[absPath] = true
+	}
+	for _, arg := range cmdline {
+		for _, word := range strings.Fields(arg) {
+			if paths[filepath.Clean(word)] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// procCapabilities returns the effective capabilities of the process
+// (a bit mask, see capabilities(7)).
+func procCapabilities(pid int) (uint64, error) {
+	data, err := ioutil.ReadFile(procPath(pid, "status"))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "CapEff:" {
+			return strconv.ParseUint(fields[1], 16, 64)
+		}
+	}
+	return 0, errors.New(`Can't find "CapEff" in "` + procPath(pid, "status") + `".`)
+}
+
+// procUsage describes the resource usage of a process.
+type procUsage struct {
+	// cpuTime is the user and system CPU time (in seconds).
+	cpuTime float64
+	// rss is the resident set size (in bytes).
+	rss int64
+}
+
+// readProcUsage returns the current resource usage of the process.
+func readProcUsage(pid int) (*procUsage, error) {
+	stat, err := procStat(pid)
+	if err != nil {
+		return nil, err
+	}
+	// "utime", "stime" and "rss" are the fields 14, 15 and 24.
+	if len(stat) < 22 {
+		return nil, errors.New(`Invalid format of "` + procPath(pid, "stat") + `".`)
+	}
+	var values [3]int64
+	for i, field := range []int{11, 12, 21} {
+		if values[i], err = strconv.ParseInt(stat[field], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return &procUsage{
+		cpuTime: float64(values[0]+values[1]) / clockTicks,
+		rss:     values[2] * int64(os.Getpagesize()),
+	}, nil
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"syscall"
+	"time"
+)
+
+// groupPollInterval is the interval of checking whether
+// the processes of a group have been terminated.
+const groupPollInterval = 10 * time.Millisecond
+
+// sysProcAttr returns the attributes of the Instance process: the
+// process is a leader of its own process group (or session), so the
+// processes forked by the Instance can be signalled together with it.
+// cred - the credential of the process (nil - inherited).
+func (inst *Instance) sysProcAttr(cred *syscall.Credential) *syscall.SysProcAttr {
+	attr := syscall.SysProcAttr{Credential: cred, Pdeathsig: inst.deathSignal}
+	// A session leader is a group leader too.
+	if inst.Spec.Session {
+		attr.Setsid = true
+	} else {
+		attr.Setpgid = true
+	}
+	return &attr
+}
+
+// isGroupLeader checks whether the process is a leader of its process group.
+func isGroupLeader(pid int) bool {
+	pgid, err := syscall.Getpgid(pid)
+	return err == nil && pgid == pid
+}
+
+// processGroup returns the process group of the Instance
+// (0 if the process isn't a leader of its group).
+func (inst *Instance) processGroup() int {
+	inst.infoMutex.RLock()
+	defer inst.infoMutex.RUnlock()
+	return inst.pgid
+}
+
+// signal sends the signal to the process group of the Instance (or
+// only to the process if it isn't a leader of its group).
+func (inst *Instance) signal(sig syscall.Signal) error {
+	if pgid := inst.processGroup(); pgid != 0 {
+		return syscall.Kill(-pgid, sig)
+	}
+	return inst.process().Signal(sig)
+}
+
+// sweepGroup waits for the remaining processes of the group to
+// terminate and kills them with "SIGKILL" after the timeout.
+func sweepGroup(pgid int, timeout time.Duration) {
+	if pgid == 0 {
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	// The signal "0" checks whether the group has any processes.
+	for syscall.Kill(-pgid, 0) == nil {
+		if !time.Now().Before(deadline) {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+			return
+		}
+		time.Sleep(groupPollInterval)
+	}
+}
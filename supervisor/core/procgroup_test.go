@@ -0,0 +1,145 @@
+package core
+
+import (
+	"io/ioutil"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSysProcAttr checks the attributes of the Instance processes.
+func TestSysProcAttr(t *testing.T) {
+	assert := assert.New(t)
+
+	inst := NewInstance(&InstanceSpec{Name: "test"}, newInstanceCmd("test", nil))
+	attr := inst.sysProcAttr(nil)
+	assert.True(attr.Setpgid)
+	assert.False(attr.Setsid)
+	assert.Equal(syscall.Signal(0), attr.Pdeathsig)
+
+	inst.Spec.Session = true
+	inst.deathSignal = syscall.SIGKILL
+	cred := &syscall.Credential{Uid: 1000, Gid: 1000}
+	attr = inst.sysProcAttr(cred)
+	assert.False(attr.Setpgid, "setpgid(2) fails for a session leader.")
+	assert.True(attr.Setsid)
+	assert.Equal(syscall.SIGKILL, attr.Pdeathsig)
+	assert.Equal(cred, attr.Credential)
+}
+
+// groupMembers returns the alive processes of the process group.
+func groupMembers(pgid int) []int {
+	var res []int
+	entries, _ := ioutil.ReadDir("/proc")
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// The state and the process group are the fields 3 and 5.
+		stat, err := procStat(pid)
+		if err == nil && stat[0] != "Z" && stat[2] == strconv.Itoa(pgid) {
+			res = append(res, pid)
+		}
+	}
+	return res
+}
+
+// waitGroupMembers waits for the process group to have the number of processes.
+func waitGroupMembers(t *testing.T, pgid int, count int) []int {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if members := groupMembers(pgid); len(members) == count {
+			return members
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("The process group %v doesn't have %v processes.", pgid, count)
+	return nil
+}
+
+// waitSignalIgnored waits for the process to ignore the "SIGINT" signal.
+func waitSignalIgnored(t *testing.T, pid int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		fields, err := procStatus(pid, "SigIgn")
+		if err != nil {
+			break
+		}
+		mask, err := strconv.ParseUint(fields["SigIgn"], 16, 64)
+		// The bit of the signal N is N - 1.
+		if err == nil && mask&(1<<(syscall.SIGINT-1)) != 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("The process %v doesn't ignore the signal.", pid)
+}
+
+// waitForkedIgnoring waits for the process forked by the
+// Instance to ignore the "SIGINT" signal.
+func waitForkedIgnoring(t *testing.T, pid int) {
+	for _, member := range waitGroupMembers(t, pid, 2) {
+		if member != pid {
+			waitSignalIgnored(t, member)
+		}
+	}
+}
+
+// Test the process groups of the Instances.
+func TestSupervisorProcessGroup(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, func(cfg *Cfg) {
+		cfg.TermTimeout = Duration(200 * time.Millisecond)
+		cfg.Restart = RestartCfg{BackoffInitial: Duration(10 * time.Millisecond),
+			BackoffMax: Duration(10 * time.Millisecond)}
+	})
+
+	start := func(spec *InstanceSpec) (int, int) {
+		id, err := sv.StartInstance(spec)
+		if !assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err) {
+			return 0, 0
+		}
+		pid := sv.getInstance(id).Pid()
+		waitSignalHandlers(t, pid)
+		return id, pid
+	}
+
+	// The forked processes are stopped together with the Instance.
+	id, pid := start(&InstanceSpec{Name: "test_instance", Env: []string{"INSTFORK=true"}})
+	waitGroupMembers(t, pid, 2)
+	stat, err := procStat(pid)
+	if assert.Nil(err) {
+		// The session is the field 6.
+		assert.NotEqual(strconv.Itoa(pid), stat[3])
+	}
+	assert.Nil(sv.StopInstance(id, true))
+	waitGroupMembers(t, pid, 0)
+
+	// The remaining processes are killed after the timeout.
+	id, pid = start(&InstanceSpec{Name: "test_instance", Session: true,
+		Env: []string{"INSTFORK=ignore"}})
+	waitForkedIgnoring(t, pid)
+	stat, err = procStat(pid)
+	if assert.Nil(err) {
+		assert.Equal(strconv.Itoa(pid), stat[3])
+	}
+	stopStart := time.Now()
+	assert.Nil(sv.StopInstance(id, true))
+	assert.True(time.Since(stopStart) >= time.Duration(sv.config().TermTimeout),
+		"The forked process hasn't been given the time to terminate.")
+	waitGroupMembers(t, pid, 0)
+
+	// The processes left by the crashed Instance are killed on the restart.
+	id, pid = start(&InstanceSpec{Name: "test_instance", RestartPolicy: RestartAlways,
+		Env: []string{"INSTFORK=ignore"}})
+	waitForkedIgnoring(t, pid)
+	inst := sv.getInstance(id)
+	_, _, err = sv.RestartAfterTermInstance(pid, killTestInstance(t, inst))
+	assert.Nil(err)
+	waitRestart(t, sv, id, pid)
+	waitGroupMembers(t, pid, 0)
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// readinessPollInterval is the interval of checking whether the
+// process is alive while waiting for the Instance readiness.
+const readinessPollInterval = 50 * time.Millisecond
+
+// probeReadiness runs the readiness probe of the current Instance process
+// until it succeeds or the process is terminated / replaced by a restart.
+func (sv *Supervisor) probeReadiness(id int, inst *Instance) {
+	spec := inst.Spec.Readiness
+	if spec == nil {
+		return
+	}
+	process, _ := inst.readiness()
+	env := append(os.Environ(), inst.Spec.Env...)
+
+	for {
+		if process.Signal(syscall.Signal(0)) != nil {
+			return
+		}
+		err := spec.check(env)
+		if !inst.setReadiness(process, err) {
+			return
+		}
+		if err == nil {
+			sv.publishEvent(EventReady, id, inst, nil, nil)
+			return
+		}
+		time.Sleep(spec.interval())
+	}
+}
+
+// WaitInstanceReady waits for the current process of the Instance to
+// become ready (see InstanceSpec.Readiness). Returns an error describing
+// the reason if the process is terminated or isn't ready in time.
+func (sv *Supervisor) WaitInstanceReady(id int, timeout time.Duration) error {
+	inst := sv.getInstance(id)
+	if inst == nil {
+		return errors.New("Unknown instance with id " + strconv.Itoa(id))
+	}
+	process, readyCh := inst.readiness()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(readinessPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-readyCh:
+			return nil
+		case <-ticker.C:
+			if process.Signal(syscall.Signal(0)) != nil {
+				return readinessError("The process has terminated before becoming ready.",
+					inst.Status())
+			}
+		case <-deadline.C:
+			return readinessError("The Instance isn't ready after "+timeout.String()+".",
+				inst.Status())
+		}
+	}
+}
+
+// readinessError returns the error with the details from the Instance status.
+func readinessError(msg string, status *InstanceStatus) error {
+	if status.ReadinessError != "" {
+		msg += ` Last probe error: "` + status.ReadinessError + `".`
+	}
+	if status.LastExit != nil && status.LastExit.Pid == status.Pid {
+		if status.LastExit.Signal != "" {
+			msg += " The process has been killed by " + status.LastExit.Signal + "."
+		} else {
+			msg += " Exit code: " + strconv.Itoa(status.LastExit.ExitCode) + "."
+		}
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the readiness of the Instance.
+func TestSupervisorReadiness(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, func(cfg *Cfg) { cfg.EventHistorySize = 100 })
+
+	readyPath := filepath.Join(t.TempDir(), "ready")
+	id, err := sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance",
+		RestartPolicy: RestartNever,
+		Readiness: &ProbeSpec{Type: ProbeFile, Path: readyPath,
+			Interval: Duration(10 * time.Millisecond)}})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+
+	// The Instance isn't ready until the file appears.
+	status, _ := sv.GetInstanceStatus(id)
+	assert.Equal(stateStarting, status.State)
+	err = sv.WaitInstanceReady(id, 100*time.Millisecond)
+	if assert.NotNil(err, "The Instance is ready.") {
+		assert.Contains(err.Error(), "Last probe error")
+	}
+
+	assert.Nil(ioutil.WriteFile(readyPath, nil, 0644))
+	err = sv.WaitInstanceReady(id, 5*time.Second)
+	assert.Nilf(err, `The Instance isn't ready. Error: "%v"`, err)
+	status, _ = sv.GetInstanceStatus(id)
+	assert.Equal(stateRunning, status.State)
+	assert.Empty(status.ReadinessError)
+
+	events, _, cancel := sv.SubscribeEvents(0)
+	defer cancel()
+	assert.Equal(EventReady, events[len(events)-1].Type)
+
+	// The Instance without a readiness probe is ready right after the start.
+	id, err = sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance",
+		RestartPolicy: RestartNever})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+	assert.Nil(sv.WaitInstanceReady(id, time.Millisecond))
+
+	// The terminated Instance won't be ready.
+	id, err = sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance",
+		RestartPolicy: RestartNever,
+		Readiness:     &ProbeSpec{Type: ProbeFile, Path: readyPath + ".absent"}})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+	inst := sv.getInstance(id)
+	killTestInstance(t, inst)
+	err = sv.WaitInstanceReady(id, 5*time.Second)
+	if assert.NotNil(err, "The terminated Instance is ready.") {
+		assert.Contains(err.Error(), "terminated")
+	}
+}
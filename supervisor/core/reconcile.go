@@ -0,0 +1,318 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Drift kinds.
+const (
+	// DriftMissing - the declared Instance isn't running.
+	DriftMissing = "missing"
+	// DriftChanged - the definition of the running
+	// Instance differs from the declared one.
+	DriftChanged = "changed"
+	// DriftRemoved - the running Instance isn't declared anymore.
+	DriftRemoved = "removed"
+	// DriftNotRunning - the declared Instance has been terminated
+	// and won't be restarted according to its restart policy (or
+	// the Supervisor has given up restarting it).
+	DriftNotRunning = "not_running"
+)
+
+// DeclaredInstance describes an Instance declared in the config.
+// The key (see InstanceSpec.KeySuffix) is used to match the declared
+// Instance with the running one, so it should be unique.
+type DeclaredInstance struct {
+	InstanceSpec
+	// Autostart - start the Instance if it isn't running. If it
+	// is false, the Instance isn't started by the Supervisor, but
+	// it is kept up to date with the config if it is running.
+	// Default: true.
+	Autostart *bool `json:"autostart,omitempty"`
+}
+
+// validate checks the settings of the declared Instance.
+func (decl *DeclaredInstance) validate() error {
+	return decl.spec().validate()
+}
+
+// spec returns the settings used to start the declared Instance.
+// The default restart policy of the declared Instances is RestartAlways.
+func (decl *DeclaredInstance) spec() *InstanceSpec {
+	spec := decl.InstanceSpec
+	if spec.RestartPolicy == "" {
+		spec.RestartPolicy = RestartAlways
+	}
+	return &spec
+}
+
+// autostart checks whether the Instance should be started if it isn't running.
+func (decl *DeclaredInstance) autostart() bool {
+	return decl.Autostart == nil || *decl.Autostart
+}
+
+// DriftItem describes a difference between the declared
+// Instances and the Instances managed by the Supervisor.
+type DriftItem struct {
+	// Kind describes the difference. See drift kinds.
+	Kind string `json:"kind"`
+	// Key is the key of the Instance.
+	Key string `json:"key"`
+	// Name is the name of the Instance.
+	Name string `json:"name"`
+	// ID is the ID of the running Instance (0 if it is missing).
+	ID int `json:"id,omitempty"`
+	// Error is the error of the last attempt to eliminate the difference.
+	Error string `json:"error,omitempty"`
+	// inst is the running Instance (nil if it is missing).
+	inst *Instance
+	// spec describes the declared settings (nil if it isn't declared).
+	spec *InstanceSpec
+}
+
+// DriftReport describes the result of the reconciliation.
+type DriftReport struct {
+	// LastReconcile is the time of the last reconciliation
+	// (nil if it hasn't been performed yet).
+	LastReconcile *time.Time `json:"last_reconcile,omitempty"`
+	// Drift describes the differences that haven't been eliminated.
+	Drift []DriftItem `json:"drift"`
+}
+
+// findDrift compares the declared Instances with the running ones.
+func (sv *Supervisor) findDrift(declared []DeclaredInstance) []DriftItem {
+	type runningInstance struct {
+		id   int
+		inst *Instance
+	}
+	running := make(map[string]runningInstance)
+	sv.instMapMutex.RLock()
+	for id, inst := range sv.instancesById {
+		if inst.declared {
+			running[inst.key] = runningInstance{id, inst}
+		}
+	}
+	sv.instMapMutex.RUnlock()
+
+	drift := []DriftItem{}
+	for i := range declared {
+		decl := &declared[i]
+		spec := decl.spec()
+		item := DriftItem{Key: spec.key(), Name: spec.Name, spec: spec}
+		if r, ok := running[item.Key]; !ok {
+			if !decl.autostart() {
+				continue
+			}
+			item.Kind = DriftMissing
+		} else {
+			delete(running, item.Key)
+			item.ID, item.inst = r.id, r.inst
+			if !reflect.DeepEqual(&r.inst.Spec, spec) {
+				item.Kind = DriftChanged
+			} else if status := r.inst.Status(); (status.State == stateTerminated ||
+				status.State == stateFailed) && status.NextRestart == nil &&
+				r.inst.isExitHandled() {
+				item.Kind = DriftNotRunning
+			} else {
+				continue
+			}
+		}
+		drift = append(drift, item)
+	}
+	for key, r := range running {
+		drift = append(drift, DriftItem{Kind: DriftRemoved, Key: key,
+			Name: r.inst.Spec.Name, ID: r.id, inst: r.inst})
+	}
+
+	sort.Slice(drift, func(i, j int) bool { return drift[i].Key < drift[j].Key })
+	return drift
+}
+
+// Reconcile starts the declared Instances that aren't running, stops
+// the Instances that aren't declared anymore and restarts the Instances
+// whose definition has been changed. The Instances that have been
+// started by a client aren't affected.
+// Returns the differences that couldn't be eliminated.
+func (sv *Supervisor) Reconcile() *DriftReport {
+	sv.reconcileMutex.Lock()
+	defer sv.reconcileMutex.Unlock()
+
+	errs := make(map[string]string)
+	if !sv.reconcileStopped {
+		for _, item := range sv.findDrift(sv.config().Instances) {
+			if err := sv.reconcileItem(&item); err != nil {
+				log.Printf(`Can't reconcile the Instance "%v" (%v). Error: "%v"`,
+					item.Key, item.Kind, err)
+				errs[item.Key] = err.Error()
+			}
+		}
+	}
+
+	sv.driftMutex.Lock()
+	sv.lastReconcile = time.Now()
+	sv.reconcileErrs = errs
+	sv.driftMutex.Unlock()
+
+	return sv.Drift()
+}
+
+// reconcileItem eliminates the difference described by the item.
+func (sv *Supervisor) reconcileItem(item *DriftItem) error {
+	switch item.Kind {
+	case DriftMissing:
+		id, err := sv.startInstance(item.spec, true, false)
+		if err == nil {
+			log.Printf(`The declared Instance "%v" has been started. ID: %v`,
+				item.Key, id)
+		}
+		return err
+	case DriftChanged:
+		if err := sv.replaceInstance(item.ID, item.inst, item.spec, true); err != nil {
+			return err
+		}
+		log.Printf(`The declared Instance "%v" has been changed and restarted. ID: %v`,
+			item.Key, item.ID)
+	case DriftRemoved:
+		if err := sv.StopInstance(item.ID, true); err != nil {
+			return err
+		}
+		log.Printf(`The Instance "%v" isn't declared anymore and has been stopped. ID: %v`,
+			item.Key, item.ID)
+	case DriftNotRunning:
+		return errors.New("The Instance won't be restarted according to the restart policy.")
+	}
+	return nil
+}
+
+// replaceInstance stops the Instance and starts a new one with the
+// specified settings under the same ID. The followers of the output
+// keep receiving the output of the new process. If the Instance can't be
+// stopped (see Instance.Stop), it is kept running. If the new process
+// can't be started, the Instance is kept terminated with the same ID
+// and settings: the error is saved in its status and it is restarted
+// according to its restart policy.
+func (sv *Supervisor) replaceInstance(id int, inst *Instance, spec *InstanceSpec,
+	force bool) error {
+	sv.termMutex.RLock()
+	defer sv.termMutex.RUnlock()
+
+	// The Instance could be stopped by a client.
+	if sv.getInstance(id) != inst {
+		return errors.New("Unknown instance with id " + strconv.Itoa(id))
+	}
+
+	sv.publishEvent(EventStopping, id, inst, nil, nil)
+	exit, err := sv.stopInstance(inst, force)
+	if err != nil {
+		// The process is still running, so
+		// it should be restarted on failure.
+		inst.cancelStop()
+		return err
+	}
+	// The output followers are attached to the new process.
+	sv.releaseInstanceLog(inst)
+	sv.publishEvent(EventStopped, id, inst, exit, nil)
+
+	// The cgroup of the old process is reused.
+	newInst, err := sv.runInstance(spec, inst.declared, id, inst.key, inst.output)
+	if err != nil {
+		inst.cancelStop()
+		inst.recordStartError(exit, err)
+		if outputErr := sv.openInstanceOutput(inst); outputErr != nil {
+			log.Printf(`Can't capture the output of the Instance. ID: %v. Error: "%v"`,
+				id, outputErr)
+		}
+		sv.persistState()
+		sv.scheduleRetry(id, inst)
+		return err
+	}
+	sv.instMapMutex.Lock()
+	sv.instancesById[id] = newInst
+	sv.instMapMutex.Unlock()
+	sv.publishRestartEvent(id, newInst, inst.Pid())
+	sv.persistState()
+	sv.startProbes(id, newInst)
+	return nil
+}
+
+// Drift returns the current differences between the declared Instances
+// and the Instances managed by the Supervisor with the errors of the
+// last reconciliation.
+func (sv *Supervisor) Drift() *DriftReport {
+	var report DriftReport
+	report.Drift = sv.findDrift(sv.config().Instances)
+
+	sv.driftMutex.Lock()
+	defer sv.driftMutex.Unlock()
+	if !sv.lastReconcile.IsZero() {
+		lastReconcile := sv.lastReconcile
+		report.LastReconcile = &lastReconcile
+	}
+	for i := range report.Drift {
+		report.Drift[i].Error = sv.reconcileErrs[report.Drift[i].Key]
+	}
+	return &report
+}
+
+// StartReconciliation performs the reconciliation of the declared
+// Instances and repeats it periodically (see Cfg.ReconcileInterval)
+// until StopAllInstances is called.
+func (sv *Supervisor) StartReconciliation() {
+	sv.Reconcile()
+	go func() {
+		for {
+			// The interval could be changed by the config reload.
+			var timer *time.Timer
+			var tick <-chan time.Time
+			if interval := time.Duration(sv.config().ReconcileInterval); interval > 0 {
+				timer = time.NewTimer(interval)
+				tick = timer.C
+			}
+			reconcile := false
+			select {
+			case <-tick:
+				reconcile = true
+			case <-sv.reconcileWakeup:
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			if sv.isReconcileStopped() {
+				return
+			}
+			if reconcile {
+				sv.Reconcile()
+			}
+		}
+	}()
+}
+
+// wakeupReconciliation notifies the reconciliation loop (if any).
+func (sv *Supervisor) wakeupReconciliation() {
+	select {
+	case sv.reconcileWakeup <- struct{}{}:
+	default:
+	}
+}
+
+// isReconcileStopped checks whether the reconciliation has been stopped.
+func (sv *Supervisor) isReconcileStopped() bool {
+	sv.reconcileMutex.Lock()
+	defer sv.reconcileMutex.Unlock()
+	return sv.reconcileStopped
+}
+
+// stopReconciliation stops the reconciliation. It waits for the
+// current reconciliation (if any) to be done, so no declared
+// Instances will be started after the call.
+func (sv *Supervisor) stopReconciliation() {
+	sv.reconcileMutex.Lock()
+	defer sv.reconcileMutex.Unlock()
+	sv.reconcileStopped = true
+	sv.wakeupReconciliation()
+}
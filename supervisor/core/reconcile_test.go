@@ -0,0 +1,116 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCfgValidate checks the validation of the declared Instances.
+func TestCfgValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := Cfg{
+		InstancesDir: "../../test_instances",
+		TermTimeout:  Duration(time.Second),
+		Instances: []DeclaredInstance{
+			{InstanceSpec: InstanceSpec{Name: "first"}},
+			{InstanceSpec: InstanceSpec{Name: "second", RestartPolicy: RestartNever}},
+		},
+	}
+	assert.Nil(cfg.Validate())
+
+	cfg.Instances[1].Name = "first"
+	assert.NotNil(cfg.Validate(), "Duplicate names are allowed.")
+
+	// The Instances of the same file with different keys.
+	cfg.Instances[1].KeySuffix = "2"
+	assert.Nil(cfg.Validate())
+	cfg.Instances[1].KeySuffix = ""
+
+	cfg.Instances[1].Name = ""
+	assert.NotNil(cfg.Validate(), "Empty name is allowed.")
+
+	cfg.Instances[1].Name = "second"
+	cfg.Instances[1].RestartPolicy = "sometimes"
+	assert.NotNil(cfg.Validate(), "Unknown restart policy is allowed.")
+}
+
+// Test the reconciliation of the declared Instances.
+func TestSupervisorReconcile(t *testing.T) {
+	assert := assert.New(t)
+	instName := "test_instance"
+	autostart := false
+	sv := newTestSupervisor(t, func(cfg *Cfg) {
+		cfg.Instances = []DeclaredInstance{
+			{InstanceSpec: InstanceSpec{Name: instName}},
+			{InstanceSpec: InstanceSpec{Name: "unknown_instance"}},
+			{InstanceSpec: InstanceSpec{Name: "manual_instance"}, Autostart: &autostart},
+		}
+	})
+	cfg := sv.config()
+
+	// The Instance started by a client isn't affected.
+	manualID, err := sv.StartInstance(&InstanceSpec{Name: instName, KeySuffix: "manual"})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+
+	report := sv.Drift()
+	assert.Nil(report.LastReconcile)
+	assert.Len(report.Drift, 2)
+
+	// The declared Instance should be started. The Instance
+	// with an unknown executable file is reported.
+	report = sv.Reconcile()
+	assert.NotNil(report.LastReconcile)
+	if assert.Len(report.Drift, 1) {
+		assert.Equal(DriftMissing, report.Drift[0].Kind)
+		assert.Equal("unknown_instance", report.Drift[0].Name)
+		assert.NotEmpty(report.Drift[0].Error)
+	}
+	assert.Len(sv.ListInstances(), 2)
+	var id int
+	for key, status := range sv.ListInstances() {
+		if status.Declared {
+			assert.Equal(RestartAlways, status.RestartPolicy)
+			assert.Equal(instName, key)
+			id = status.ID
+		}
+	}
+	assert.NotEqual(0, id, "The declared Instance hasn't been started.")
+	oldPid := sv.getInstance(id).Pid()
+	waitSignalHandlers(t, oldPid)
+
+	// Nothing changes on the next reconciliation.
+	cfg.Instances = cfg.Instances[:1]
+	assert.Empty(sv.Reconcile().Drift)
+	assert.Equal(oldPid, sv.getInstance(id).Pid())
+
+	// The changed Instance should be restarted with the same ID.
+	cfg.Instances[0].Env = []string{"MYVAR=1"}
+	drift := sv.Drift().Drift
+	if assert.Len(drift, 1) {
+		assert.Equal(DriftChanged, drift[0].Kind)
+		assert.Equal(id, drift[0].ID)
+	}
+	assert.Empty(sv.Reconcile().Drift)
+	status, err := sv.GetInstanceStatus(id)
+	assert.Nilf(err, `Can't get Instance status. Error: "%v"`, err)
+	assert.NotEqual(oldPid, status.Pid, "The Instance hasn't been restarted.")
+	assert.Equal([]string{"MYVAR=1"}, status.Env)
+	waitSignalHandlers(t, status.Pid)
+
+	// The removed Instance should be stopped.
+	cfg.Instances = nil
+	assert.Empty(sv.Reconcile().Drift)
+	_, err = sv.GetInstanceStatus(id)
+	assert.NotNil(err, "The removed Instance hasn't been stopped.")
+	_, err = sv.GetInstanceStatus(manualID)
+	assert.Nil(err, "The Instance started by a client has been stopped.")
+
+	// The declared Instances aren't started after the termination.
+	cfg.Instances = []DeclaredInstance{{InstanceSpec: InstanceSpec{Name: instName}}}
+	sv.StopAllInstances()
+	sv.Reconcile()
+	assert.Empty(sv.ListInstances())
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"strings"
+)
+
+// CfgLoader loads the Supervisor config (for example, parses the config file).
+type CfgLoader func() (*Cfg, error)
+
+// SetCfgLoader sets the function used by ReloadCfg to load a new config.
+func (sv *Supervisor) SetCfgLoader(loader CfgLoader) {
+	sv.reloadMutex.Lock()
+	defer sv.reloadMutex.Unlock()
+	sv.cfgLoader = loader
+}
+
+// keepStaticFields keeps the values of the fields of the current config
+// that are used only at the start of the Supervisor (the state file, the
+// privileges) or by all the Instances at once (the logs directory, the
+// parent cgroup). Returns the names of the changed fields.
+func keepStaticFields(current *Cfg, cfg *Cfg) []string {
+	var changed []string
+	if cfg.StateFile != current.StateFile {
+		changed = append(changed, `"state_file"`)
+		cfg.StateFile = current.StateFile
+	}
+	if cfg.LogsDir != current.LogsDir {
+		changed = append(changed, `"logs_dir"`)
+		cfg.LogsDir = current.LogsDir
+	}
+	if cfg.CgroupParent != current.CgroupParent {
+		changed = append(changed, `"cgroup_parent"`)
+		cfg.CgroupParent = current.CgroupParent
+	}
+	if cfg.DropPrivileges != current.DropPrivileges {
+		changed = append(changed, `"drop_privileges"`)
+		cfg.DropPrivileges = current.DropPrivileges
+	}
+	return changed
+}
+
+// ReloadCfg loads a new config, validates it and replaces the current one.
+// Then the declared Instances are reconciled with the new config, the
+// other Instances aren't affected (the new settings will be used on their
+// next stop / restart). If the config can't be loaded or it is invalid,
+// the current config is kept. The changes of the fields that can't be
+// reloaded (see keepStaticFields) are ignored with a message in the log.
+// Returns the differences that couldn't be eliminated (see Reconcile).
+func (sv *Supervisor) ReloadCfg() (*DriftReport, error) {
+	// Only one reload can be performed at a time.
+	sv.reloadMutex.Lock()
+	defer sv.reloadMutex.Unlock()
+
+	if sv.cfgLoader == nil {
+		return nil, errors.New("The config loader isn't set.")
+	}
+	cfg, err := sv.cfgLoader()
+	if err != nil {
+		return nil, errors.New("Can't load the config: " + err.Error())
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.New("Invalid config: " + err.Error())
+	}
+
+	if changed := keepStaticFields(sv.config(), cfg); len(changed) != 0 {
+		log.Printf("The changes of %v can't be reloaded, they will be applied "+
+			"after the restart.", strings.Join(changed, ", "))
+	}
+
+	sv.events.resize(cfg.EventHistorySize)
+	sv.cfgMutex.Lock()
+	sv.cfg = cfg
+	sv.cfgMutex.Unlock()
+	log.Print("The config has been reloaded.")
+
+	// The reconciliation interval could be changed.
+	sv.wakeupReconciliation()
+	return sv.Reconcile(), nil
+}
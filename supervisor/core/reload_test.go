@@ -0,0 +1,89 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the reload of the Supervisor config.
+func TestSupervisorReloadCfg(t *testing.T) {
+	assert := assert.New(t)
+	instName := "test_instance"
+	sv := newTestSupervisor(t, nil)
+	cfg := sv.config()
+
+	_, err := sv.ReloadCfg()
+	assert.NotNil(err, "The config has been reloaded without a loader.")
+
+	// The Instance started by a client isn't affected.
+	id, err := sv.StartInstance(&InstanceSpec{Name: instName, KeySuffix: "manual"})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+	pid := sv.getInstance(id).Pid()
+
+	var newCfg *Cfg
+	var loadErr error
+	sv.SetCfgLoader(func() (*Cfg, error) { return newCfg, loadErr })
+
+	// The config that can't be loaded is rejected.
+	loadErr = errors.New("Syntax error.")
+	_, err = sv.ReloadCfg()
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "Syntax error.")
+	}
+	assert.Equal(cfg, sv.config(), "The config has been replaced.")
+
+	// The invalid config is rejected.
+	loadErr = nil
+	newCfg = &Cfg{
+		InstancesDir: "../../test_instances",
+		TermTimeout:  Duration(200 * time.Millisecond),
+		Instances: []DeclaredInstance{
+			{InstanceSpec: InstanceSpec{Name: instName}},
+			{InstanceSpec: InstanceSpec{Name: instName}},
+		},
+	}
+	_, err = sv.ReloadCfg()
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "declared more than once")
+	}
+	assert.Equal(cfg, sv.config(), "The config has been replaced.")
+	assert.Len(sv.ListInstances(), 1)
+
+	// The valid config is applied.
+	newCfg.Instances = newCfg.Instances[:1]
+	report, err := sv.ReloadCfg()
+	assert.Nilf(err, `Can't reload the config. Error: "%v"`, err)
+	assert.Empty(report.Drift)
+	assert.Equal(newCfg, sv.config(), "The config hasn't been replaced.")
+	assert.Len(sv.ListInstances(), 2)
+	assert.Equal(pid, sv.getInstance(id).Pid(), "The Instance has been restarted.")
+
+	// The fields that can't be reloaded are kept.
+	newCfg = &Cfg{
+		InstancesDir: "../../test_instances",
+		TermTimeout:  Duration(300 * time.Millisecond),
+		StateFile:    "/tmp/state.json",
+		LogsDir:      t.TempDir(),
+	}
+	_, err = sv.ReloadCfg()
+	assert.Nilf(err, `Can't reload the config. Error: "%v"`, err)
+	assert.Equal(300*time.Millisecond, sv.TermTimeout())
+	assert.Empty(sv.config().StateFile)
+	assert.Empty(sv.config().LogsDir)
+}
+
+// TestKeepStaticFields checks the fields that can't be reloaded.
+func TestKeepStaticFields(t *testing.T) {
+	assert := assert.New(t)
+	current := &Cfg{StateFile: "a", CgroupParent: "/sys/fs/cgroup/a"}
+	cfg := &Cfg{StateFile: "a", LogsDir: "/tmp",
+		DropPrivileges: PrivilegesCfg{User: "nobody"}, TermTimeout: Duration(time.Second)}
+	assert.Equal([]string{`"logs_dir"`, `"cgroup_parent"`, `"drop_privileges"`},
+		keepStaticFields(current, cfg))
+	assert.Equal(&Cfg{StateFile: "a", CgroupParent: "/sys/fs/cgroup/a",
+		TermTimeout: Duration(time.Second)}, cfg)
+	assert.Empty(keepStaticFields(current, cfg))
+}
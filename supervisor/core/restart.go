@@ -0,0 +1,164 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Restart policies.
+const (
+	// RestartAlways - restart the Instance after any termination.
+	RestartAlways = "always"
+	// RestartOnFailure - restart the Instance only if it has been
+	// terminated by a signal or with a non-zero exit code.
+	RestartOnFailure = "on-failure"
+	// RestartNever - never restart the Instance.
+	RestartNever = "never"
+)
+
+// Restart modes (see RestartInstance).
+const (
+	// RestartModeGraceful - the Instance is restarted only if it has
+	// been terminated by its stop sequence (the "SIGKILL" steps are
+	// skipped). Otherwise, it is kept running.
+	RestartModeGraceful = "graceful"
+	// RestartModeForce - the Instance is killed with "SIGKILL" if
+	// it isn't terminated by its stop sequence.
+	RestartModeForce = "force"
+)
+
+// errRestartGivenUp is returned when the Instance has been
+// restarted too many times within the restart window.
+var errRestartGivenUp = errors.New("Too many restarts, the Instance is considered failed.")
+
+// validateRestartPolicy checks the restart policy.
+// An empty policy is the same as RestartNever.
+func validateRestartPolicy(policy string) error {
+	switch policy {
+	case "", RestartAlways, RestartOnFailure, RestartNever:
+		return nil
+	}
+	return errors.New(`Unknown restart policy: "` + policy + `".`)
+}
+
+// needRestart checks whether the Instance terminated with the
+// failure flag should be restarted according to the policy.
+func needRestart(policy string, failure bool) bool {
+	switch policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return failure
+	}
+	return false
+}
+
+// restartBackoff returns the delay before the restart of an Instance that
+// has already been restarted "restarts" times within the restart window.
+// The delay grows exponentially up to the BackoffMax. To prevent
+// simultaneous restarts of many Instances, a random jitter is used:
+// the result is in the range [delay / 2, delay].
+func restartBackoff(cfg *RestartCfg, restarts int) time.Duration {
+	delay := time.Duration(cfg.BackoffInitial)
+	max := time.Duration(cfg.BackoffMax)
+	for i := 0; i < restarts; i++ {
+		if (max > 0 && delay >= max) || delay > math.MaxInt64/2 {
+			break
+		}
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// validateRestartMode checks the restart mode.
+func validateRestartMode(mode string) error {
+	switch mode {
+	case RestartModeGraceful, RestartModeForce:
+		return nil
+	}
+	return errors.New(`Unknown restart mode: "` + mode + `".`)
+}
+
+// RestartInstance stops the Instance and starts it again with the same
+// settings under the same ID. Like a start of a new Instance, the restart
+// resets the restart counters and the exit history of the Instance. If the
+// new process can't be started, the Instance is kept terminated (see
+// replaceInstance).
+// mode - see restart modes.
+func (sv *Supervisor) RestartInstance(id int, mode string) error {
+	if err := validateRestartMode(mode); err != nil {
+		return err
+	}
+	inst := sv.getInstance(id)
+	if inst == nil {
+		return errors.New("Unknown instance with id " + strconv.Itoa(id))
+	}
+	spec := inst.Spec
+	if err := sv.replaceInstance(id, inst, &spec, mode == RestartModeForce); err != nil {
+		return err
+	}
+	log.Printf(`The Instance "%v" has been restarted. ID: %v`, spec.Name, id)
+	return nil
+}
+
+// MatchInstances returns the IDs of the Instances whose names match
+// the shell pattern (see path.Match) in ascending order.
+func (sv *Supervisor) MatchInstances(pattern string) ([]int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, errors.New(`Invalid pattern "` + pattern + `": ` + err.Error() + ".")
+	}
+	sv.instMapMutex.RLock()
+	ids := []int{}
+	for id, inst := range sv.instancesById {
+		if matched, _ := path.Match(pattern, inst.Spec.Name); matched {
+			ids = append(ids, id)
+		}
+	}
+	sv.instMapMutex.RUnlock()
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// RollingRestart restarts the Instances one at a time (see RestartInstance)
+// in the specified order. The next Instance is restarted only after the
+// previous one has become ready (see WaitInstanceReady), so the rest of the
+// Instances keep working. The restart is aborted on the first failure.
+// Returns the IDs of the Instances that have been restarted successfully.
+func (sv *Supervisor) RollingRestart(ids []int, mode string,
+	readyTimeout time.Duration) ([]int, error) {
+	if err := validateRestartMode(mode); err != nil {
+		return nil, err
+	}
+	// Don't restart anything if the request is wrong.
+	for _, id := range ids {
+		if sv.getInstance(id) == nil {
+			return nil, errors.New("Unknown instance with id " + strconv.Itoa(id))
+		}
+	}
+
+	restarted := []int{}
+	for _, id := range ids {
+		err := sv.RestartInstance(id, mode)
+		if err == nil {
+			err = sv.WaitInstanceReady(id, readyTimeout)
+		}
+		if err != nil {
+			return restarted, errors.New("Can't restart the Instance with id " +
+				strconv.Itoa(id) + ": " + err.Error())
+		}
+		restarted = append(restarted, id)
+	}
+	return restarted, nil
+}
@@ -0,0 +1,275 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRestartBackoff checks the calculation of the restart delay.
+func TestRestartBackoff(t *testing.T) {
+	assert := assert.New(t)
+	cfg := RestartCfg{
+		BackoffInitial: Duration(time.Second),
+		BackoffMax:     Duration(10 * time.Second),
+	}
+
+	// The delay is doubled with each restart and the jitter
+	// keeps it in the range [delay / 2, delay].
+	for restarts, delay := range []time.Duration{time.Second, 2 * time.Second,
+		4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		backoff := restartBackoff(&cfg, restarts)
+		assert.Truef(backoff >= delay/2 && backoff <= delay,
+			"Unexpected delay %v after %v restarts.", backoff, restarts)
+	}
+
+	// Without the initial delay restart immediately.
+	assert.Equal(time.Duration(0), restartBackoff(&RestartCfg{}, 3))
+
+	// Without the max delay the delay shouldn't overflow.
+	cfg.BackoffMax = 0
+	assert.True(restartBackoff(&cfg, 1000) > 0, "The delay has overflowed.")
+}
+
+// TestNeedRestart checks the restart policies.
+func TestNeedRestart(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(needRestart(RestartAlways, false))
+	assert.True(needRestart(RestartAlways, true))
+	assert.False(needRestart(RestartOnFailure, false))
+	assert.True(needRestart(RestartOnFailure, true))
+	assert.False(needRestart(RestartNever, true))
+	assert.False(needRestart("", true))
+
+	assert.Nil(validateRestartPolicy(RestartOnFailure))
+	assert.NotNil(validateRestartPolicy("sometimes"))
+}
+
+// killTestInstance kills the process of the Instance and
+// returns the exit status.
+func killTestInstance(t *testing.T, inst *Instance) *ExitStatus {
+	inst.Cmd.Process.Kill()
+	inst.Cmd.Wait()
+	return exitStatusFromState(inst.Cmd.Process.Pid, inst.Cmd.ProcessState)
+}
+
+// waitRestart waits for the Instance to be restarted.
+func waitRestart(t *testing.T, sv *Supervisor, id int, oldPid int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status, err := sv.GetInstanceStatus(id)
+		if err == nil && status.Pid != oldPid && status.State == stateRunning {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("The Instance %v hasn't been restarted.", id)
+}
+
+// Test the restart of a crashed Instance and the crash loop detection.
+func TestSupervisorRestart(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, func(cfg *Cfg) {
+		cfg.Restart = RestartCfg{
+			BackoffInitial: Duration(10 * time.Millisecond),
+			BackoffMax:     Duration(20 * time.Millisecond),
+			MaxRestarts:    2,
+			Window:         Duration(time.Minute),
+		}
+		cfg.ExitHistorySize = 2
+	})
+
+	id, err := sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance",
+		RestartPolicy: RestartOnFailure})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+
+	// The Instance should be restarted "MaxRestarts" times.
+	for i := 1; i <= 2; i++ {
+		inst := sv.getInstance(id)
+		pid := inst.Cmd.Process.Pid
+		_, delay, err := sv.RestartAfterTermInstance(pid, killTestInstance(t, inst))
+		assert.Nilf(err, `Can't restart the Instance. Error: "%v"`, err)
+		assert.True(delay >= 5*time.Millisecond && delay <= 20*time.Millisecond,
+			"Unexpected restart delay.")
+
+		waitRestart(t, sv, id, pid)
+		status, _ := sv.GetInstanceStatus(id)
+		assert.Equal(i, status.RestartCount, "Unexpected restart count.")
+		assert.Nil(status.NextRestart, "The restart is still scheduled.")
+	}
+
+	// And now the Instance is in a crash loop.
+	inst := sv.getInstance(id)
+	_, _, err = sv.RestartAfterTermInstance(inst.Cmd.Process.Pid,
+		killTestInstance(t, inst))
+	assert.Equal(errRestartGivenUp, err, "The Instance has been restarted.")
+	status, _ := sv.GetInstanceStatus(id)
+	assert.Equal(stateFailed, status.State, "The Instance isn't failed.")
+
+	// Check the exit statuses.
+	assert.Equal(inst.Cmd.Process.Pid, status.LastExit.Pid)
+	assert.Equal("SIGKILL", status.LastExit.Signal)
+	assert.Equal(-1, status.LastExit.ExitCode)
+	assert.True(status.LastExit.MaxRSS > 0, "The resource usage is unknown.")
+	assert.Equal(2, len(status.ExitHistory), "Unexpected exit history size.")
+	assert.Equal(status.LastExit, status.ExitHistory[1])
+
+	// The Instance that has been stopped shouldn't be restarted.
+	id, err = sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance",
+		RestartPolicy: RestartAlways})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+	inst = sv.getInstance(id)
+	inst.Stop(time.Duration(sv.config().TermTimeout), true)
+	_, _, err = sv.RestartAfterTermInstance(inst.Cmd.Process.Pid, nil)
+	assert.NotNil(err, "The stopped Instance has been restarted.")
+}
+
+// Test the restart of the Instances by a client.
+func TestSupervisorRestartCommand(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, func(cfg *Cfg) {
+		cfg.OutputBufferLines = 10
+		cfg.EventHistorySize = 10
+	})
+
+	id, err := sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance",
+		Env: []string{"INSTSIGIGNORE=true"}, RestartPolicy: RestartAlways})
+	if !assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err) {
+		return
+	}
+	inst := sv.getInstance(id)
+	waitSignalHandlers(t, inst.Pid())
+
+	assert.EqualError(sv.RestartInstance(id, "soft"), `Unknown restart mode: "soft".`)
+	assert.EqualError(sv.RestartInstance(id+1, RestartModeForce),
+		"Unknown instance with id 2")
+
+	// The Instance ignores the signals, so it isn't restarted
+	// gracefully and should be restarted on failure as before.
+	assert.EqualError(sv.RestartInstance(id, RestartModeGraceful),
+		"The process couldn't be terminated correctly.")
+	assert.Equal(inst, sv.getInstance(id))
+	assert.True(inst.IsAlive())
+	assert.False(inst.isStopped())
+
+	// The ID and the settings are kept.
+	_, events, cancelEvents := sv.SubscribeEvents(sv.events.lastSeq())
+	defer cancelEvents()
+	lines, cancelFollow, err := sv.FollowInstanceOutput(id)
+	assert.Nilf(err, `Can't follow the output. Error: "%v"`, err)
+	defer cancelFollow()
+	restartTime := time.Now()
+	assert.Nil(sv.RestartInstance(id, RestartModeForce))
+	newInst := sv.getInstance(id)
+	if assert.NotNil(newInst) {
+		assert.NotEqual(inst.Pid(), newInst.Pid())
+		assert.Equal(inst.Spec, newInst.Spec)
+		assert.False(inst.IsAlive())
+		assert.Equal("SIGKILL", inst.Status().LastExit.Signal)
+	}
+
+	// The restart is published with the old and the new PID.
+	waitEvent(t, events, EventStopping)
+	waitEvent(t, events, EventStopped)
+	if event := waitEvent(t, events, EventRestarted); event != nil && newInst != nil {
+		assert.Equal(inst.Pid(), event.OldPid)
+		assert.Equal(newInst.Pid(), event.Pid)
+	}
+
+	// The output followers receive the output of the new process.
+	select {
+	case line, ok := <-lines:
+		if assert.True(ok, "The output follower has been closed.") {
+			assert.True(line.Time.After(restartTime),
+				"The line has been read before the restart.")
+		}
+	case <-time.After(5 * time.Second):
+		assert.Fail("The output of the new process hasn't been received.")
+	}
+
+	// If the new process can't be started, the Instance is kept
+	// terminated and it is restarted according to its restart policy.
+	cfg := sv.config()
+	brokenCfg := *cfg
+	brokenCfg.InstancesDir = t.TempDir()
+	brokenCfg.Restart = RestartCfg{BackoffInitial: Duration(time.Hour),
+		BackoffMax: Duration(time.Hour)}
+	sv.cfgMutex.Lock()
+	sv.cfg = &brokenCfg
+	sv.cfgMutex.Unlock()
+	waitSignalHandlers(t, newInst.Pid())
+	assert.NotNil(sv.RestartInstance(id, RestartModeForce))
+	if assert.Equal(newInst, sv.getInstance(id)) {
+		status := newInst.Status()
+		assert.Equal(stateTerminated, status.State)
+		assert.NotEmpty(status.StartError)
+		if assert.NotNil(status.LastExit) {
+			assert.Equal(status.StartError, status.LastExit.StartError)
+		}
+		assert.NotNil(status.NextRestart)
+		assert.False(newInst.isStopped())
+	}
+
+	// The next restart starts the Instance with the same ID.
+	sv.cfgMutex.Lock()
+	sv.cfg = cfg
+	sv.cfgMutex.Unlock()
+	assert.Nil(sv.RestartInstance(id, RestartModeForce))
+	if restartedInst := sv.getInstance(id); assert.NotNil(restartedInst) {
+		assert.True(restartedInst.IsAlive())
+		assert.Empty(restartedInst.Status().StartError)
+	}
+}
+
+// Test the rolling restart of the Instances.
+func TestSupervisorRollingRestart(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, nil)
+
+	readyPath := filepath.Join(t.TempDir(), "ready")
+	specs := []InstanceSpec{{Name: "test_instance"}, {Name: "test_instance"},
+		{Name: "test_instance", Readiness: &ProbeSpec{Type: ProbeFile, Path: readyPath,
+			Interval: Duration(10 * time.Millisecond)}},
+		{Name: "test_instance"}}
+	var ids, pids []int
+	for i := range specs {
+		id, err := sv.StartDuplicateInstance(&specs[i])
+		if !assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err) {
+			return
+		}
+		ids = append(ids, id)
+		pids = append(pids, sv.getInstance(id).Pid())
+		waitSignalHandlers(t, pids[i])
+	}
+
+	matched, err := sv.MatchInstances("test_*")
+	assert.Nil(err)
+	assert.Equal(ids, matched)
+	matched, err = sv.MatchInstances("other")
+	assert.Nil(err)
+	assert.Empty(matched)
+	_, err = sv.MatchInstances("[")
+	assert.NotNil(err)
+
+	// Nothing is restarted if the request is wrong.
+	_, err = sv.RollingRestart([]int{ids[0], 100}, RestartModeForce, time.Second)
+	assert.EqualError(err, "Unknown instance with id 100")
+	assert.Equal(pids[0], sv.getInstance(ids[0]).Pid())
+
+	// The third Instance doesn't become ready, so the restart is aborted.
+	restarted, err := sv.RollingRestart(ids, RestartModeForce, 100*time.Millisecond)
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "Can't restart the Instance with id 3: "+
+			"The Instance isn't ready")
+	}
+	assert.Equal(ids[:2], restarted)
+	for i, id := range ids {
+		if i < 3 {
+			assert.NotEqual(pids[i], sv.getInstance(id).Pid())
+		} else {
+			assert.Equal(pids[i], sv.getInstance(id).Pid(), "The Instance has been restarted.")
+		}
+	}
+}
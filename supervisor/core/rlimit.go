@@ -0,0 +1,236 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// rlimitInfinity is the value of an unlimited resource (RLIM_INFINITY).
+const rlimitInfinity = ^uint64(0)
+
+// rlimitUnlimited is the name of the unlimited value in the settings.
+const rlimitUnlimited = "unlimited"
+
+// The syscall package doesn't define these resources. The values
+// are the same for the most of the architectures supported by Linux.
+const (
+	rlimitNproc   = 6
+	rlimitMemlock = 8
+)
+
+// RlimitValue is a value of a resource limit. It is set as
+// a number or "unlimited" (both in JSON).
+type RlimitValue uint64
+
+// UnmarshalJSON decodes the limit from a number or "unlimited".
+func (value *RlimitValue) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		if str != rlimitUnlimited {
+			return errors.New(`Invalid resource limit: "` + str + `".`)
+		}
+		*value = RlimitValue(rlimitInfinity)
+		return nil
+	}
+	var number uint64
+	if err := json.Unmarshal(data, &number); err != nil {
+		return errors.New("Invalid resource limit: " + string(data) + ".")
+	}
+	*value = RlimitValue(number)
+	return nil
+}
+
+// MarshalJSON encodes the limit as a number or "unlimited".
+func (value RlimitValue) MarshalJSON() ([]byte, error) {
+	if uint64(value) == rlimitInfinity {
+		return json.Marshal(rlimitUnlimited)
+	}
+	return json.Marshal(uint64(value))
+}
+
+// String returns the limit as a number or "unlimited".
+func (value RlimitValue) String() string {
+	if uint64(value) == rlimitInfinity {
+		return rlimitUnlimited
+	}
+	return strconv.FormatUint(uint64(value), 10)
+}
+
+// parseRlimitValue parses the limit from "/proc/<pid>/limits".
+func parseRlimitValue(str string) (RlimitValue, error) {
+	if str == rlimitUnlimited {
+		return RlimitValue(rlimitInfinity), nil
+	}
+	value, err := strconv.ParseUint(str, 10, 64)
+	return RlimitValue(value), err
+}
+
+// RlimitSpec describes a limit of a resource (see setrlimit(2)).
+// The limit that isn't set is inherited from the Supervisor.
+type RlimitSpec struct {
+	// Soft is the value enforced by the kernel.
+	Soft *RlimitValue `json:"soft,omitempty"`
+	// Hard is the ceiling for the soft limit. Only a privileged
+	// process can raise it.
+	Hard *RlimitValue `json:"hard,omitempty"`
+}
+
+// validate checks the limit.
+func (spec *RlimitSpec) validate(name string) error {
+	if spec.Soft == nil && spec.Hard == nil {
+		return errors.New(`The resource limit "` + name + `" is empty.`)
+	}
+	if spec.Soft != nil && spec.Hard != nil && *spec.Soft > *spec.Hard {
+		return errors.New(`The soft limit of "` + name +
+			`" is greater than the hard one.`)
+	}
+	return nil
+}
+
+// RlimitsSpec describes the resource limits of an Instance.
+type RlimitsSpec struct {
+	// Nofile - the maximum number of open files (RLIMIT_NOFILE).
+	Nofile *RlimitSpec `json:"nofile,omitempty"`
+	// Core - the maximum size of a core file in bytes (RLIMIT_CORE).
+	Core *RlimitSpec `json:"core,omitempty"`
+	// As - the maximum size of the virtual memory in bytes (RLIMIT_AS).
+	As *RlimitSpec `json:"as,omitempty"`
+	// Nproc - the maximum number of processes of the user (RLIMIT_NPROC).
+	Nproc *RlimitSpec `json:"nproc,omitempty"`
+	// Memlock - the maximum number of bytes of memory
+	// that may be locked into RAM (RLIMIT_MEMLOCK).
+	Memlock *RlimitSpec `json:"memlock,omitempty"`
+}
+
+// rlimitResource describes a resource that can be limited.
+type rlimitResource struct {
+	// name is the name of the resource in the settings.
+	name string
+	// resource is the resource for setrlimit(2).
+	resource int
+	// procName is the name of the limit in "/proc/<pid>/limits".
+	procName string
+	// spec returns the limit of the resource from the settings.
+	spec func(*RlimitsSpec) **RlimitSpec
+}
+
+// rlimitResources describes the resources that can be limited.
+var rlimitResources = []rlimitResource{
+	{"nofile", syscall.RLIMIT_NOFILE, "Max open files",
+		func(spec *RlimitsSpec) **RlimitSpec { return &spec.Nofile }},
+	{"core", syscall.RLIMIT_CORE, "Max core file size",
+		func(spec *RlimitsSpec) **RlimitSpec { return &spec.Core }},
+	{"as", syscall.RLIMIT_AS, "Max address space",
+		func(spec *RlimitsSpec) **RlimitSpec { return &spec.As }},
+	{"nproc", rlimitNproc, "Max processes",
+		func(spec *RlimitsSpec) **RlimitSpec { return &spec.Nproc }},
+	{"memlock", rlimitMemlock, "Max locked memory",
+		func(spec *RlimitsSpec) **RlimitSpec { return &spec.Memlock }},
+}
+
+// validate checks the resource limits.
+func (spec *RlimitsSpec) validate() error {
+	for _, res := range rlimitResources {
+		if limit := *res.spec(spec); limit != nil {
+			if err := limit.validate(res.name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check verifies that the resource limits can be set by the current
+// process. privileged - the process can raise the hard limits.
+func (spec *RlimitsSpec) check(privileged bool) error {
+	for _, res := range rlimitResources {
+		limit := *res.spec(spec)
+		if limit == nil {
+			continue
+		}
+		var rlimit syscall.Rlimit
+		if err := syscall.Getrlimit(res.resource, &rlimit); err != nil {
+			return errors.New(`Can't get the resource limit "` + res.name +
+				`": ` + err.Error() + ".")
+		}
+		hard := rlimit.Max
+		if limit.Hard != nil {
+			if uint64(*limit.Hard) > rlimit.Max && !privileged {
+				return errors.New(`Can't raise the hard limit of "` + res.name +
+					`" above the limit of the Supervisor (` +
+					RlimitValue(rlimit.Max).String() + `) without CAP_SYS_RESOURCE.`)
+			}
+			hard = uint64(*limit.Hard)
+		}
+		if limit.Soft != nil && uint64(*limit.Soft) > hard {
+			return errors.New(`The soft limit of "` + res.name +
+				`" is greater than the inherited hard one (` +
+				RlimitValue(hard).String() + `).`)
+		}
+	}
+	return nil
+}
+
+// apply sets the resource limits of the current process. The limits
+// that aren't set keep their current values.
+func (spec *RlimitsSpec) apply() error {
+	for _, res := range rlimitResources {
+		limit := *res.spec(spec)
+		if limit == nil {
+			continue
+		}
+		var rlimit syscall.Rlimit
+		if err := syscall.Getrlimit(res.resource, &rlimit); err != nil {
+			return errors.New(`Can't get the resource limit "` + res.name +
+				`": ` + err.Error() + ".")
+		}
+		if limit.Soft != nil {
+			rlimit.Cur = uint64(*limit.Soft)
+		}
+		if limit.Hard != nil {
+			rlimit.Max = uint64(*limit.Hard)
+		}
+		if err := syscall.Setrlimit(res.resource, &rlimit); err != nil {
+			return errors.New(`Can't set the resource limit "` + res.name +
+				`": ` + err.Error() + ".")
+		}
+	}
+	return nil
+}
+
+// procRlimits returns the current resource limits of the process.
+func procRlimits(pid int) (*RlimitsSpec, error) {
+	data, err := ioutil.ReadFile(procPath(pid, "limits"))
+	if err != nil {
+		return nil, err
+	}
+
+	var limits RlimitsSpec
+	for _, line := range strings.Split(string(data), "\n") {
+		for _, res := range rlimitResources {
+			if !strings.HasPrefix(line, res.procName+" ") {
+				continue
+			}
+			// Limit  Soft Limit  Hard Limit  Units
+			fields := strings.Fields(line[len(res.procName):])
+			if len(fields) < 2 {
+				return nil, errors.New(`Invalid format of "` +
+					procPath(pid, "limits") + `".`)
+			}
+			soft, err := parseRlimitValue(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			hard, err := parseRlimitValue(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			*res.spec(&limits) = &RlimitSpec{Soft: &soft, Hard: &hard}
+		}
+	}
+	return &limits, nil
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRlimitsSpec checks the decoding and the validation of the limits.
+func TestRlimitsSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	var spec RlimitsSpec
+	err := json.Unmarshal([]byte(`{"nofile": {"soft": 1024, "hard": "unlimited"},
+		"core": {"soft": 0}}`), &spec)
+	if assert.Nil(err) {
+		assert.Equal(RlimitValue(1024), *spec.Nofile.Soft)
+		assert.Equal(RlimitValue(rlimitInfinity), *spec.Nofile.Hard)
+		assert.Nil(spec.Core.Hard)
+		assert.Nil(spec.validate())
+	}
+	data, err := json.Marshal(spec.Nofile)
+	assert.Nil(err)
+	assert.Equal(`{"soft":1024,"hard":"unlimited"}`, string(data))
+
+	assert.NotNil(json.Unmarshal([]byte(`{"as": {"soft": "infinity"}}`), &spec))
+	assert.NotNil(json.Unmarshal([]byte(`{"as": {"soft": -1}}`), &spec))
+
+	soft, hard := RlimitValue(2048), RlimitValue(1024)
+	assert.NotNil((&RlimitsSpec{Nproc: &RlimitSpec{Soft: &soft, Hard: &hard}}).validate(),
+		"The soft limit is greater than the hard one.")
+	assert.NotNil((&RlimitsSpec{Memlock: &RlimitSpec{}}).validate(),
+		"The limit is empty.")
+}
+
+// TestChildSetupCheck checks the verification of the settings
+// before the start of the Instance.
+func TestChildSetupCheck(t *testing.T) {
+	assert := assert.New(t)
+	var nofile syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &nofile); err != nil {
+		t.Fatalf(`Can't get the resource limit. Error: "%v"`, err)
+	}
+	allCapabilities := ^uint64(0)
+
+	// The limits can be lowered without the privileges.
+	soft := RlimitValue(nofile.Cur - 1)
+	setup := childSetup{Rlimits: &RlimitsSpec{Nofile: &RlimitSpec{Soft: &soft}}}
+	assert.Nil(setup.check(0))
+
+	if nofile.Max != rlimitInfinity {
+		// Only a privileged process can raise the hard limit.
+		hard := RlimitValue(nofile.Max + 1)
+		setup.Rlimits.Nofile = &RlimitSpec{Hard: &hard}
+		assert.EqualError(setup.check(0), `Can't raise the hard limit of "nofile" `+
+			`above the limit of the Supervisor (`+RlimitValue(nofile.Max).String()+
+			`) without CAP_SYS_RESOURCE.`)
+		assert.Nil(setup.check(1 << capSysResource))
+
+		// The soft limit can't be greater than the inherited hard one.
+		setup.Rlimits.Nofile = &RlimitSpec{Soft: &hard}
+		assert.NotNil(setup.check(allCapabilities))
+	}
+
+	// The credential requires the privileges.
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	setup = childSetup{Credential: &syscall.Credential{Uid: uid, Gid: gid}}
+	assert.NotNil(setup.check(0))
+	assert.Nil(setup.check(1 << capSetgid))
+	setup.Credential.Uid = uid + 1
+	assert.NotNil(setup.check(1 << capSetgid))
+	assert.Nil(setup.check(allCapabilities))
+}
+
+// waitExec waits for the child setup to execute the Instance.
+// instDir - the directory of the test Instance.
+func waitExec(t *testing.T, pid int, instDir string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		// The command line of the child setup contains the script too.
+		cmdline, err := procCmdline(pid)
+		if err == nil && cmdline[0] != childSetupName &&
+			procRunsScript(pid, filepath.Join(instDir, testInstName)) {
+			waitSignalHandlers(t, pid)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("The process %v hasn't executed the Instance.", pid)
+}
+
+// Test the resource limits of the Instance.
+func TestSupervisorRlimits(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, func(cfg *Cfg) {
+		cfg.Restart = RestartCfg{BackoffInitial: Duration(10 * time.Millisecond),
+			BackoffMax: Duration(10 * time.Millisecond)}
+	})
+
+	// The limits of the Supervisor.
+	var nofile syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &nofile); err != nil {
+		t.Fatalf(`Can't get the resource limit. Error: "%v"`, err)
+	}
+
+	// Unprivileged processes can lower the limits.
+	soft, zero := RlimitValue(nofile.Cur-1), RlimitValue(0)
+	spec := InstanceSpec{Name: "test_instance", RestartPolicy: RestartAlways,
+		Rlimits: &RlimitsSpec{
+			Nofile: &RlimitSpec{Soft: &soft},
+			Core:   &RlimitSpec{Soft: &zero, Hard: &zero},
+		}}
+	id, err := sv.StartDuplicateInstance(&spec)
+	if !assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err) {
+		return
+	}
+
+	checkRlimits := func() {
+		status, err := sv.GetInstanceStatus(id)
+		assert.Nil(err)
+		waitExec(t, status.Pid, testInstPath)
+		status, _ = sv.GetInstanceStatus(id)
+		if !assert.NotNil(status.Rlimits, "The limits aren't reported.") {
+			return
+		}
+		assert.Equal(soft, *status.Rlimits.Nofile.Soft)
+		// The hard limit is inherited.
+		assert.Equal(RlimitValue(nofile.Max), *status.Rlimits.Nofile.Hard)
+		assert.Equal(zero, *status.Rlimits.Core.Soft)
+		assert.Equal(zero, *status.Rlimits.Core.Hard)
+		assert.NotNil(status.Rlimits.As)
+	}
+	checkRlimits()
+
+	// The limits are applied after the restart too.
+	inst := sv.getInstance(id)
+	pid := inst.Pid()
+	_, _, err = sv.RestartAfterTermInstance(pid, killTestInstance(t, inst))
+	assert.Nilf(err, `Can't restart the Instance. Error: "%v"`, err)
+	waitRestart(t, sv, id, pid)
+	checkRlimits()
+
+	// The limit can't be raised above the hard one.
+	big := RlimitValue(rlimitInfinity)
+	if nofile.Max != rlimitInfinity {
+		spec.Rlimits = &RlimitsSpec{Nofile: &RlimitSpec{Soft: &big, Hard: &big}}
+		spec.RestartPolicy = RestartNever
+		// The error is reported on the start if the Supervisor has
+		// no privileges, otherwise the child process fails.
+		capabilities, err := procCapabilities(os.Getpid())
+		assert.Nil(err)
+		id, err = sv.StartDuplicateInstance(&spec)
+		if capabilities&(1<<capSysResource) == 0 {
+			assert.NotNil(err, "The hard limit has been raised without the privileges.")
+		} else if assert.Nil(err) {
+			inst := sv.getInstance(id)
+			inst.Cmd.Wait()
+			assert.Equal(childSetupExitCode, inst.Cmd.ProcessState.ExitCode())
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// maxSignal is the maximum signal number (SIGRTMAX).
+const maxSignal = 64
+
+// signalNames maps signals to their names.
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGABRT:   "SIGABRT",
+	syscall.SIGALRM:   "SIGALRM",
+	syscall.SIGBUS:    "SIGBUS",
+	syscall.SIGCHLD:   "SIGCHLD",
+	syscall.SIGCONT:   "SIGCONT",
+	syscall.SIGFPE:    "SIGFPE",
+	syscall.SIGHUP:    "SIGHUP",
+	syscall.SIGILL:    "SIGILL",
+	syscall.SIGINT:    "SIGINT",
+	syscall.SIGIO:     "SIGIO",
+	syscall.SIGKILL:   "SIGKILL",
+	syscall.SIGPIPE:   "SIGPIPE",
+	syscall.SIGPROF:   "SIGPROF",
+	syscall.SIGPWR:    "SIGPWR",
+	syscall.SIGQUIT:   "SIGQUIT",
+	syscall.SIGSEGV:   "SIGSEGV",
+	syscall.SIGSTKFLT: "SIGSTKFLT",
+	syscall.SIGSTOP:   "SIGSTOP",
+	syscall.SIGSYS:    "SIGSYS",
+	syscall.SIGTERM:   "SIGTERM",
+	syscall.SIGTRAP:   "SIGTRAP",
+	syscall.SIGTSTP:   "SIGTSTP",
+	syscall.SIGTTIN:   "SIGTTIN",
+	syscall.SIGTTOU:   "SIGTTOU",
+	syscall.SIGURG:    "SIGURG",
+	syscall.SIGUSR1:   "SIGUSR1",
+	syscall.SIGUSR2:   "SIGUSR2",
+	syscall.SIGVTALRM: "SIGVTALRM",
+	syscall.SIGWINCH:  "SIGWINCH",
+	syscall.SIGXCPU:   "SIGXCPU",
+	syscall.SIGXFSZ:   "SIGXFSZ",
+}
+
+// signalName returns the name of the signal (for example, "SIGTERM").
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return "SIG" + strconv.Itoa(int(sig))
+}
+
+// parseSignal parses the signal set by the name ("SIGTERM" or "TERM",
+// case-insensitive) or the number.
+func parseSignal(value string) (syscall.Signal, error) {
+	if number, err := strconv.Atoi(value); err == nil {
+		if number <= 0 || number > maxSignal {
+			return 0, errors.New(`Invalid signal number "` + value + `".`)
+		}
+		return syscall.Signal(number), nil
+	}
+	name := strings.ToUpper(value)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	for sig, sigName := range signalNames {
+		if sigName == name {
+			return sig, nil
+		}
+	}
+	return 0, errors.New(`Unknown signal "` + value + `".`)
+}
+
+// Signal sends the signal to the process of the Instance. The "mutex"
+// is locked, so the signal can't be sent to the process started by a
+// concurrent restart. The signal isn't sent if the termination of the
+// process has been handled: the process is reaped then, so its PID can
+// be reused by an unrelated process (for example, during the restart
+// backoff).
+func (inst *Instance) Signal(sig syscall.Signal) error {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+
+	if inst.stopped {
+		return errors.New("The Instance has been stopped.")
+	}
+	if inst.exitHandled || !inst.IsAlive() {
+		return errors.New("The Instance isn't running.")
+	}
+	return inst.process().Signal(sig)
+}
+
+// SignalInstance sends the signal (the name or the number) to the
+// Instance process. The signal should be allowed by the config
+// (see Cfg.AllowedSignals).
+func (sv *Supervisor) SignalInstance(id int, sig string) error {
+	parsed, err := parseSignal(sig)
+	if err != nil {
+		return err
+	}
+	allowed := false
+	for _, allowedSig := range sv.config().AllowedSignals {
+		// The allowed signals are checked by the validation of the config.
+		if value, _ := parseSignal(allowedSig); value == parsed {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return errors.New(`The signal "` + signalName(parsed) +
+			`" isn't allowed (see "allowed_signals").`)
+	}
+
+	inst := sv.getInstance(id)
+	if inst == nil {
+		return errors.New("Unknown instance with id " + strconv.Itoa(id))
+	}
+	return inst.Signal(parsed)
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the delivery of the signals to the Instances.
+func TestSupervisorSignal(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, func(cfg *Cfg) {
+		cfg.AllowedSignals = []string{"SIGHUP", "USR1"}
+	})
+
+	id, err := sv.StartInstance(&InstanceSpec{Name: "test_instance",
+		RestartPolicy: RestartNever})
+	if !assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err) {
+		return
+	}
+	inst := sv.getInstance(id)
+	waitSignalHandlers(t, inst.Pid())
+
+	assert.EqualError(sv.SignalInstance(id, "15"),
+		`The signal "SIGTERM" isn't allowed (see "allowed_signals").`)
+	assert.EqualError(sv.SignalInstance(id, "SIGFOO"), `Unknown signal "SIGFOO".`)
+	assert.EqualError(sv.SignalInstance(id+1, "SIGHUP"), "Unknown instance with id 2")
+	assert.True(inst.IsAlive())
+
+	// The signal isn't sent after the termination of the process has
+	// been handled, even if the PID is used by another process.
+	inst.mutex.Lock()
+	inst.exitHandled = true
+	inst.mutex.Unlock()
+	assert.EqualError(sv.SignalInstance(id, "SIGHUP"), "The Instance isn't running.")
+	assert.True(inst.IsAlive())
+	inst.mutex.Lock()
+	inst.exitHandled = false
+	inst.mutex.Unlock()
+
+	// The test Instance doesn't handle "SIGHUP", so it is terminated.
+	assert.Nil(sv.SignalInstance(id, "hup"))
+	inst.Cmd.Wait()
+	sv.RestartAfterTermInstance(inst.Pid(),
+		exitStatusFromState(inst.Pid(), inst.Cmd.ProcessState))
+	if exit := inst.Status().LastExit; assert.NotNil(exit) {
+		assert.Equal("SIGHUP", exit.Signal)
+		assert.Empty(exit.StopSignal)
+	}
+	assert.EqualError(sv.SignalInstance(id, "SIGHUP"), "The Instance isn't running.")
+
+	assert.Nil(sv.StopInstance(id, true))
+	assert.EqualError(inst.Signal(1), "The Instance has been stopped.")
+}
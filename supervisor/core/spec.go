@@ -0,0 +1,153 @@
+package core
+
+import (
+	"errors"
+)
+
+// InstanceSpec describes the settings used to start an Instance.
+type InstanceSpec struct {
+	// Name is the name of the Instance (the name of the
+	// executable file without ".lua" extension).
+	Name string `json:"name"`
+	// KeySuffix is appended to the Name to make the unique key of the
+	// Instance ("<name>-<suffix>"), so several Instances can be run from
+	// the same file. Default: the key is the Name.
+	KeySuffix string `json:"key_suffix,omitempty"`
+	// Env describes the environment settled by a client.
+	Env []string `json:"env"`
+	// RestartPolicy describes when the Instance should be
+	// restarted after termination. See restart policies.
+	RestartPolicy string `json:"restart_policy"`
+	// Log overrides the rotation settings of the Instance log.
+	Log *LogSpec `json:"log,omitempty"`
+	// Readiness describes the check of the Instance readiness
+	// after the start. The Instance is "starting" until the
+	// check succeeds. If it isn't set, the Instance is ready
+	// right after the start.
+	Readiness *ProbeSpec `json:"readiness,omitempty"`
+	// Liveness describes the periodic check of the Instance health
+	// (after it is ready). If the check fails several times in a row,
+	// the Instance is restarted.
+	Liveness *ProbeSpec `json:"liveness,omitempty"`
+	// Iproto describes the connection to the Instance used
+	// to query "box.info". If it isn't set, "box.info" isn't
+	// queried.
+	Iproto *IprotoSpec `json:"iproto,omitempty"`
+	// Rlimits describes the resource limits of the Instance process.
+	// They are applied in the child process before the Instance is
+	// executed. The limits that aren't set are inherited from the
+	// Supervisor.
+	Rlimits *RlimitsSpec `json:"rlimits,omitempty"`
+	// Cgroup describes the limits of the cgroup of the Instance
+	// (see Cfg.CgroupParent).
+	Cgroup *CgroupSpec `json:"cgroup,omitempty"`
+	// User - the name or ID of the user that runs the Instance.
+	// Default: the user of the Supervisor.
+	User string `json:"user,omitempty"`
+	// Group - the name or ID of the group of the Instance process.
+	// Default: the primary group of the User (or the group of the
+	// Supervisor if the User isn't set).
+	Group string `json:"group,omitempty"`
+	// Groups - the names or IDs of the supplementary groups of the
+	// Instance process. If the User or the Group is set, the
+	// supplementary groups of the Supervisor aren't inherited.
+	Groups []string `json:"groups,omitempty"`
+	// WorkDir overrides the template of the working directory
+	// of the Instance (see Cfg.WorkDir).
+	WorkDir string `json:"work_dir,omitempty"`
+	// Umask - the file mode creation mask of the Instance process
+	// (an octal number, for example, "0027"). Default: the umask
+	// of the Supervisor.
+	Umask string `json:"umask,omitempty"`
+	// Session - run the Instance in its own session (setsid(2)),
+	// detached from the controlling terminal. Otherwise, it is run
+	// in its own process group. The signals of the Supervisor are
+	// sent to the whole group.
+	Session bool `json:"session,omitempty"`
+	// StopSequence - the signals sent to the Instance on the stop
+	// (see StopStep). Default: "SIGINT" right away. "SIGKILL" is
+	// sent if the Instance isn't terminated in the termination
+	// timeout after the last step.
+	StopSequence []StopStep `json:"stop_sequence,omitempty"`
+	// PreStop describes the hook run before the stop signals are sent.
+	PreStop *PreStopSpec `json:"pre_stop,omitempty"`
+}
+
+// InvalidSpecError is returned if the Instance can't
+// be started because of the invalid settings.
+type InvalidSpecError struct {
+	// Err describes the problem.
+	Err error
+}
+
+// Error returns the description of the error.
+func (err *InvalidSpecError) Error() string {
+	return err.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (err *InvalidSpecError) Unwrap() error {
+	return err.Err
+}
+
+// validate checks the Instance settings.
+func (spec *InstanceSpec) validate() error {
+	if spec.Name == "" {
+		return errors.New(`The instance name is empty.`)
+	}
+	if err := validateKeySuffix(spec.KeySuffix); err != nil {
+		return err
+	}
+	for _, probe := range []*ProbeSpec{spec.Readiness, spec.Liveness} {
+		if probe == nil {
+			continue
+		}
+		if err := probe.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Iproto != nil {
+		if err := spec.Iproto.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Rlimits != nil {
+		if err := spec.Rlimits.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Cgroup != nil {
+		if err := spec.Cgroup.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.WorkDir != "" {
+		if err := checkWorkDir(spec.WorkDir); err != nil {
+			return err
+		}
+	}
+	if spec.Umask != "" {
+		if _, err := parseUmask(spec.Umask); err != nil {
+			return err
+		}
+	}
+	if err := validateStopSequence(spec.StopSequence); err != nil {
+		return err
+	}
+	if spec.PreStop != nil {
+		if err := spec.PreStop.validate(spec.Iproto); err != nil {
+			return err
+		}
+	}
+	// The user and the groups should exist.
+	if _, err := spec.credential(); err != nil {
+		return err
+	}
+	return validateRestartPolicy(spec.RestartPolicy)
+}
+
+// isRestartable checks whether the Instance
+// can be restarted after termination.
+func (spec *InstanceSpec) isRestartable() bool {
+	return spec.RestartPolicy != "" && spec.RestartPolicy != RestartNever
+}
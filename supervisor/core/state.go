@@ -0,0 +1,235 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// startTimeTolerance is the maximum allowed difference between the saved
+// start time of an Instance and the start time of the process found by
+// PID. The start time of a process is calculated from the boot time,
+// which is known with an accuracy of a second.
+const startTimeTolerance = 2 * time.Second
+
+// instanceState describes the persisted state of an Instance.
+type instanceState struct {
+	// InstanceSpec describes the settings used to start the Instance.
+	InstanceSpec
+	// Key is the unique key of the Instance (the key made
+	// of the spec is used if it is empty).
+	Key string `json:"key,omitempty"`
+	// Pid is a process ID.
+	Pid int `json:"pid"`
+	// StartTime is the time at which the process has been started.
+	StartTime time.Time `json:"start_time"`
+	// Declared indicates that the Instance is declared in the config.
+	Declared bool `json:"declared,omitempty"`
+}
+
+// supervisorState describes the persisted state of the Supervisor.
+type supervisorState struct {
+	// LastID is an id of the last running Instance.
+	LastID int `json:"last_id"`
+	// Instances is a map of an Instance ID to the Instance state.
+	Instances map[string]*instanceState `json:"instances"`
+	// EventSeq is the sequence number of the last lifecycle event at
+	// the moment of the save, so the sequence is continued after the
+	// restart.
+	EventSeq uint64 `json:"event_seq"`
+}
+
+// saveState writes the current state of the Supervisor to the state file.
+// The file is replaced atomically, so it always contains a consistent state.
+func (sv *Supervisor) saveState() error {
+	stateFile := sv.config().StateFile
+	if stateFile == "" {
+		return nil
+	}
+
+	// Only one goroutine should write the file at a time.
+	sv.stateMutex.Lock()
+	defer sv.stateMutex.Unlock()
+
+	state := supervisorState{Instances: make(map[string]*instanceState),
+		EventSeq: sv.events.lastSeq()}
+	sv.instMapMutex.RLock()
+	state.LastID = sv.lastId
+	for id, inst := range sv.instancesById {
+		inst.infoMutex.RLock()
+		state.Instances[strconv.Itoa(id)] = &instanceState{
+			InstanceSpec: inst.Spec,
+			Key:          inst.key,
+			Pid:          inst.Cmd.Process.Pid,
+			StartTime:    inst.StartTime,
+			Declared:     inst.declared,
+		}
+		inst.infoMutex.RUnlock()
+	}
+	sv.instMapMutex.RUnlock()
+
+	data, err := json.MarshalIndent(&state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write the state to a temporary file and rename it.
+	dir := filepath.Dir(stateFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(dir, filepath.Base(stateFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), stateFile)
+}
+
+// persistState saves the state of the Supervisor. The state is saved
+// after the Instance is started / stopped, so an error can't be returned
+// to the caller and it will be only logged.
+func (sv *Supervisor) persistState() {
+	if err := sv.saveState(); err != nil {
+		log.Printf(`Can't save the state to "%v". Error: "%v"`,
+			sv.config().StateFile, err)
+	}
+}
+
+// isStateAlive checks that the process described by the state is still
+// running and belongs to the expected Instance (the PID can be reused).
+// instPath - the path to the Instance.
+func isStateAlive(state *instanceState, instPath string) bool {
+	if !procRunsScript(state.Pid, instPath) {
+		return false
+	}
+
+	startTime, err := procStartTime(state.Pid)
+	if err != nil {
+		return false
+	}
+	diff := startTime.Sub(state.StartTime)
+	return diff < startTimeTolerance && diff > -startTimeTolerance
+}
+
+// RestoreState reads the state file and re-adopts the Instances that are
+// still running without restarting them. Terminated Instances are
+// forgotten (the declared ones are started again by the reconciliation).
+// Returns the number of restored Instances.
+func (sv *Supervisor) RestoreState() (int, error) {
+	cfg := sv.config()
+	if cfg.StateFile == "" {
+		return 0, nil
+	}
+
+	data, err := ioutil.ReadFile(cfg.StateFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var state supervisorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return 0, err
+	}
+
+	sv.termMutex.RLock()
+	defer sv.termMutex.RUnlock()
+
+	// The events of the restored Instances continue the sequence.
+	sv.events.continueSeq(state.EventSeq)
+	restored := 0
+	for idStr, instState := range state.Instances {
+		if err := sv.restoreInstance(idStr, instState); err != nil {
+			log.Printf(`The Instance from the state hasn't been restored. `+
+				`ID: %v. PID: %v. Error: "%v"`, idStr, instState.Pid, err)
+			continue
+		}
+		restored++
+	}
+
+	sv.instMapMutex.Lock()
+	if state.LastID > sv.lastId {
+		sv.lastId = state.LastID
+	}
+	for id := range sv.instancesById {
+		if id > sv.lastId {
+			sv.lastId = id
+		}
+	}
+	sv.instMapMutex.Unlock()
+
+	// The state file could contain terminated Instances.
+	return restored, sv.saveState()
+}
+
+// restoreInstance re-adopts the running Instance described by the state.
+// idStr - the ID of the Instance from the state file.
+func (sv *Supervisor) restoreInstance(idStr string, instState *instanceState) error {
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 || instState.Name == "" {
+		return errors.New("Invalid entry of the state.")
+	}
+	instPath := path.Join(sv.config().InstancesDir, instState.Name+".lua")
+
+	if !isStateAlive(instState, instPath) {
+		return errors.New("The process has been terminated.")
+	}
+	inst, err := adoptInstance(&instState.InstanceSpec, instPath,
+		instState.Pid, instState.StartTime)
+	if err != nil {
+		return err
+	}
+	inst.declared = instState.Declared
+	inst.id = id
+	inst.key = instState.Key
+	if inst.key == "" {
+		inst.key = instState.key()
+	}
+	inst.cgroupPath = sv.cgroupPath(id, instState.Name)
+	inst.workDir = sv.workDir(id, &instState.InstanceSpec)
+	inst.deathSignal = sv.deathSignal()
+
+	// The output of an adopted Instance can't be captured
+	// (it will be captured after the restart).
+	if err := sv.openInstanceOutput(inst); err != nil {
+		return err
+	}
+
+	sv.instMapMutex.Lock()
+	if _, ok := sv.instancesById[id]; ok {
+		sv.instMapMutex.Unlock()
+		sv.closeInstanceOutput(inst)
+		return errors.New("The ID is already used by another Instance.")
+	}
+	if usedBy, ok := sv.idsByKey[inst.key]; ok {
+		sv.instMapMutex.Unlock()
+		sv.closeInstanceOutput(inst)
+		return &KeyUsedError{Key: inst.key, ID: usedBy}
+	}
+	sv.instancesById[id] = inst
+	sv.idsByKey[inst.key] = id
+	sv.instMapMutex.Unlock()
+	sv.publishEvent(EventAdopted, id, inst, nil, nil)
+	sv.startProbes(id, inst)
+	return nil
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the persistence of the Supervisor state and adoption of Instances.
+func TestSupervisorRestoreState(t *testing.T) {
+	assert := assert.New(t)
+	// Start Instances by the first Supervisor.
+	stateFile := path.Join(t.TempDir(), "state.json")
+	setup := func(cfg *Cfg) {
+		cfg.StateFile = stateFile
+		cfg.EventHistorySize = 10
+	}
+	sv := newTestSupervisor(t, setup)
+	instName := "test_instance"
+
+	id1, err := sv.StartDuplicateInstance(&InstanceSpec{Name: instName,
+		Env: []string{"MYVAR=1"}})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+	id2, err := sv.StartDuplicateInstance(&InstanceSpec{Name: instName,
+		RestartPolicy: RestartAlways})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+
+	inst1 := sv.getInstance(id1)
+	inst2 := sv.getInstance(id2)
+	t.Cleanup(func() { cleanupTestInstances([]*Instance{inst1, inst2}) })
+	waitSignalHandlers(t, inst1.Cmd.Process.Pid)
+
+	// The processes of the first Supervisor are children of the test,
+	// so they should be reaped by the test after termination.
+	go inst1.Cmd.Wait()
+
+	// The process is checked by the full path to the script.
+	pid := inst1.Cmd.Process.Pid
+	assert.True(procRunsScript(pid, path.Join(testInstPath, testInstName)))
+	assert.False(procRunsScript(pid, path.Join(t.TempDir(), testInstName)),
+		"The script with the same name in another directory is accepted.")
+
+	// Let's emulate the termination of the restartable
+	// Instance while the Supervisor isn't running.
+	inst2.Cmd.Process.Kill()
+	inst2.Cmd.Wait()
+
+	// Restore the state by a new Supervisor.
+	lastSeq := sv.events.lastSeq()
+	newSv := newTestSupervisor(t, setup)
+	restored, err := newSv.RestoreState()
+	assert.Nilf(err, `Can't restore the state. Error: "%v"`, err)
+	assert.Equal(1, restored, "Unexpected number of restored Instances.")
+
+	// The sequence of the events is continued.
+	events, _, cancel := newSv.SubscribeEvents(0)
+	cancel()
+	if assert.NotEmpty(events) {
+		assert.Equal(lastSeq+1, events[0].Seq, "The event sequence hasn't been continued.")
+	}
+
+	// The running Instance should be adopted without restarting.
+	status, err := newSv.GetInstanceStatus(id1)
+	assert.Nilf(err, `Can't get Instance status. Error: "%v"`, err)
+	assert.Equal(inst1.Cmd.Process.Pid, status.Pid, "The Instance has been restarted.")
+	assert.Equal("MYVAR=1", status.Env[0], "The environment hasn't been restored.")
+	assert.True(newSv.getInstance(id1).IsAdopted(), "The Instance hasn't been adopted.")
+	assert.Equal(instName, status.Key)
+
+	// The terminated Instance shouldn't be started again,
+	// even if it is restartable.
+	_, err = newSv.GetInstanceStatus(id2)
+	assert.NotNil(err, "The terminated Instance has been restored.")
+	assert.Empty(newSv.DeadAdoptedInstances(), "Unexpected dead adopted Instances.")
+
+	// The IDs shouldn't be reused.
+	_, err = newSv.StartInstance(&InstanceSpec{Name: instName})
+	assert.NotNil(err, "The key of the restored Instance is reused.")
+	id3, err := newSv.StartInstance(&InstanceSpec{Name: instName, KeySuffix: "new"})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+	assert.Equal(id2+1, id3, "The last ID hasn't been restored.")
+
+	// The restored Instance shouldn't replace an Instance with the same key.
+	otherStateFile := path.Join(t.TempDir(), "state.json")
+	otherSv := newTestSupervisor(t, func(cfg *Cfg) { cfg.StateFile = otherStateFile })
+	otherId, err := otherSv.StartInstance(&InstanceSpec{Name: instName})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+	var state supervisorState
+	data, err := ioutil.ReadFile(stateFile)
+	assert.Nilf(err, `Can't read the state. Error: "%v"`, err)
+	assert.Nil(json.Unmarshal(data, &state))
+	restoredId := id3 + 1
+	state.Instances = map[string]*instanceState{
+		strconv.Itoa(restoredId): state.Instances[strconv.Itoa(id1)]}
+	data, err = json.Marshal(&state)
+	assert.Nilf(err, `Can't encode the state. Error: "%v"`, err)
+	assert.Nil(ioutil.WriteFile(otherStateFile, data, 0644))
+	restored, err = otherSv.RestoreState()
+	assert.Nilf(err, `Can't restore the state. Error: "%v"`, err)
+	assert.Equal(0, restored, "The Instance with the used key has been restored.")
+	_, err = otherSv.GetInstanceStatus(restoredId)
+	assert.NotNil(err, "The Instance with the used key has been restored.")
+	keyId, err := otherSv.InstanceId(instName)
+	assert.Nilf(err, `Can't get the Instance ID. Error: "%v"`, err)
+	assert.Equal(otherId, keyId, "The key has been overwritten by the state.")
+
+	// Check that the adopted Instance can be stopped.
+	assert.Nil(newSv.StopInstance(id1, true), "Can't stop the adopted Instance.")
+	assert.False(inst1.IsAlive(), "The adopted Instance hasn't been terminated.")
+}
@@ -0,0 +1,241 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Pre-stop hook types.
+const (
+	// PreStopExec - the command is run.
+	PreStopExec = "exec"
+	// PreStopIproto - the Lua code is evaluated by the Instance
+	// (the iproto "EVAL" request, see InstanceSpec.Iproto).
+	PreStopIproto = "iproto"
+)
+
+// defaultPreStopTimeout is the default timeout of the pre-stop hook.
+const defaultPreStopTimeout = 10 * time.Second
+
+// defaultStopSequence is the stop sequence used if it isn't set.
+var defaultStopSequence = []StopStep{{Signal: "SIGINT"}}
+
+// StopStep describes a step of the stop sequence of an Instance.
+type StopStep struct {
+	// Signal - the name ("SIGTERM" or "TERM") or the number of the signal.
+	Signal string `json:"signal"`
+	// After - the time since the start of the stop
+	// after which the signal is sent.
+	After Duration `json:"after"`
+}
+
+// PreStopSpec describes the hook run before the Instance is stopped.
+// The stop continues if the hook fails.
+type PreStopSpec struct {
+	// Type - the type of the hook. See pre-stop hook types.
+	Type string `json:"type"`
+	// Command - the command and its arguments (the "exec" hook).
+	// The command is run with the environment of the Instance.
+	Command []string `json:"command,omitempty"`
+	// Eval - the Lua code evaluated by the Instance (the "iproto" hook).
+	Eval string `json:"eval,omitempty"`
+	// Timeout - the timeout of the hook. Default: 10s.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// validate checks the settings of the pre-stop hook.
+// iproto - the iproto settings of the Instance (nil if unset).
+func (spec *PreStopSpec) validate(iproto *IprotoSpec) error {
+	switch spec.Type {
+	case PreStopExec:
+		if len(spec.Command) == 0 {
+			return errors.New(`The command of the "exec" pre-stop hook is empty.`)
+		}
+	case PreStopIproto:
+		if spec.Eval == "" {
+			return errors.New(`The code of the "iproto" pre-stop hook is empty.`)
+		}
+		if iproto == nil {
+			return errors.New(`The "iproto" pre-stop hook requires the iproto ` +
+				`settings of the instance.`)
+		}
+	default:
+		return errors.New(`Unknown pre-stop hook type: "` + spec.Type + `".`)
+	}
+	if spec.Timeout < 0 {
+		return errors.New("The timeout of the pre-stop hook can't be negative.")
+	}
+	return nil
+}
+
+// timeout returns the timeout of the hook.
+func (spec *PreStopSpec) timeout() time.Duration {
+	if spec.Timeout == 0 {
+		return defaultPreStopTimeout
+	}
+	return time.Duration(spec.Timeout)
+}
+
+// run runs the hook.
+// env - the environment of the Instance (used by the "exec" hook).
+// iproto - the iproto settings of the Instance.
+func (spec *PreStopSpec) run(env []string, iproto *IprotoSpec) error {
+	if spec.Type == PreStopExec {
+		return runCommand(spec.Command, env, spec.timeout())
+	}
+	// The request can take longer than the other iproto requests.
+	connSpec := *iproto
+	connSpec.Timeout = Duration(spec.timeout())
+	conn, err := connSpec.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Eval(spec.Eval)
+	return err
+}
+
+// validateStopSequence checks the stop sequence: the signals should
+// be valid and the steps should be ordered by time.
+func validateStopSequence(steps []StopStep) error {
+	for i, step := range steps {
+		field := "stop_sequence[" + strconv.Itoa(i) + "]"
+		if _, err := parseSignal(step.Signal); err != nil {
+			return errors.New(`Invalid "` + field + `": ` + err.Error())
+		}
+		if step.After < 0 {
+			return errors.New(`Invalid "` + field + `": the time can't be negative.`)
+		}
+		if i > 0 && step.After < steps[i-1].After {
+			return errors.New(`Invalid "` + field + `": the steps should be ` +
+				`ordered by time.`)
+		}
+	}
+	return nil
+}
+
+// stopSequence returns the stop sequence of the Instance.
+func (spec *InstanceSpec) stopSequence() []StopStep {
+	if len(spec.StopSequence) == 0 {
+		return defaultStopSequence
+	}
+	return spec.StopSequence
+}
+
+// runPreStop runs the pre-stop hook of the Instance (if any).
+// The errors are logged.
+func (inst *Instance) runPreStop() {
+	if inst.Spec.PreStop == nil {
+		return
+	}
+	env := append(os.Environ(), inst.Spec.Env...)
+	if err := inst.Spec.PreStop.run(env, inst.Spec.Iproto); err != nil {
+		log.Printf(`The pre-stop hook of the Instance has failed. PID: %v. Error: "%v"`,
+			inst.Pid(), err)
+	}
+}
+
+// sendStopSignal sends the signal of the stop sequence to the
+// Instance and records it as the signal that stops the process.
+func (inst *Instance) sendStopSignal(sig syscall.Signal) error {
+	inst.infoMutex.Lock()
+	inst.stopSignal = sig
+	inst.infoMutex.Unlock()
+	return inst.signal(sig)
+}
+
+// terminate terminates the process of the Instance (see Stop): runs the
+// pre-stop hook and sends the signals of the stop sequence. The process
+// is given the timeout after the last step to terminate. If the timeout
+// expires (or the sequence reaches "SIGKILL") and force is "false", an
+// error is returned. The "mutex" should be locked.
+func (inst *Instance) terminate(timeout time.Duration, force bool) error {
+	// Check if the process is running by sending a signal "0".
+	if !inst.IsAlive() {
+		return nil
+	}
+
+	// First of all start wait for the process to terminate.
+	// The inst.done channel is initialized on the first
+	// attempt to terminate the Instance.
+	if inst.done == nil {
+		inst.done = make(chan error, 1)
+		go func() {
+			inst.done <- inst.wait()
+		}()
+	}
+	inst.runPreStop()
+
+	pgid := inst.processGroup()
+	start := time.Now()
+	steps := inst.Spec.stopSequence()
+	// The processes forked by the Instance get the same time to terminate.
+	deadline := start.Add(time.Duration(steps[len(steps)-1].After) + timeout)
+	for _, step := range steps {
+		// The sequence is checked by the validation of the spec.
+		sig, _ := parseSignal(step.Signal)
+		select {
+		case <-time.After(time.Until(start.Add(time.Duration(step.After)))):
+		case err := <-inst.done:
+			return inst.handleTerminated(err, pgid, deadline)
+		}
+		if sig == syscall.SIGKILL {
+			return inst.kill(pgid, force)
+		}
+		if err := inst.sendStopSignal(sig); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-time.After(time.Until(deadline)):
+		return inst.kill(pgid, force)
+	case err := <-inst.done:
+		return inst.handleTerminated(err, pgid, deadline)
+	}
+}
+
+// kill kills the process group of the Instance with "SIGKILL" and waits
+// for the process to terminate. If force is "false", returns an error
+// instead. The "mutex" should be locked.
+func (inst *Instance) kill(pgid int, force bool) error {
+	if !force {
+		return errors.New("The process couldn't be terminated correctly.")
+	}
+	if err := inst.sendStopSignal(syscall.SIGKILL); err != nil {
+		return err
+	}
+	// Wait for the process to terminate.
+	_ = <-inst.done
+	sweepGroup(pgid, 0)
+	return nil
+}
+
+// handleTerminated handles the termination of the process during the
+// stop: the remaining processes of its group are killed after the
+// deadline. err - the result of the wait for the process.
+func (inst *Instance) handleTerminated(err error, pgid int, deadline time.Time) error {
+	sweepGroup(pgid, time.Until(deadline))
+	// The process could be reaped by someone else (for example,
+	// by the "SIGCHLD" handler), it isn't an error.
+	if errors.Is(err, syscall.ECHILD) && !inst.IsAlive() {
+		return nil
+	}
+	return err
+}
+
+// stoppedExit returns the exit status of the process terminated
+// by the stop (nil if it is unknown, for example, the process has
+// been reaped by the "SIGCHLD" handler).
+func (inst *Instance) stoppedExit() *ExitStatus {
+	inst.infoMutex.RLock()
+	defer inst.infoMutex.RUnlock()
+	if inst.adopted || inst.Cmd.ProcessState == nil {
+		return nil
+	}
+	return exitStatusFromState(inst.Cmd.Process.Pid, inst.Cmd.ProcessState)
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tarantool/tvisor/supervisor/iproto"
+	"github.com/tarantool/tvisor/supervisor/iproto/iprototest"
+)
+
+// TestStopSequence checks the validation of the stop settings.
+func TestStopSequence(t *testing.T) {
+	assert := assert.New(t)
+
+	for value, sig := range map[string]syscall.Signal{"SIGTERM": syscall.SIGTERM,
+		"term": syscall.SIGTERM, "9": syscall.SIGKILL, "34": syscall.Signal(34)} {
+		parsed, err := parseSignal(value)
+		assert.Nil(err)
+		assert.Equal(sig, parsed)
+	}
+	for _, value := range []string{"SIGFOO", "0", "65", ""} {
+		_, err := parseSignal(value)
+		assert.NotNilf(err, "The signal %q is parsed.", value)
+	}
+
+	assert.Nil(validateStopSequence([]StopStep{{Signal: "SIGTERM"},
+		{Signal: "INT", After: Duration(10 * time.Second)},
+		{Signal: "KILL", After: Duration(30 * time.Second)}}))
+	assert.EqualError(validateStopSequence([]StopStep{{Signal: "SIGTERM"},
+		{Signal: "SIGFOO"}}), `Invalid "stop_sequence[1]": Unknown signal "SIGFOO".`)
+	assert.EqualError(validateStopSequence([]StopStep{
+		{Signal: "SIGTERM", After: Duration(time.Second)}, {Signal: "SIGKILL"}}),
+		`Invalid "stop_sequence[1]": the steps should be ordered by time.`)
+
+	assert.NotNil((&PreStopSpec{Type: PreStopExec}).validate(nil))
+	assert.NotNil((&PreStopSpec{Type: PreStopIproto, Eval: "box.snapshot()"}).validate(nil),
+		"The iproto settings aren't checked.")
+	assert.Nil((&PreStopSpec{Type: PreStopIproto, Eval: "box.snapshot()"}).validate(
+		&IprotoSpec{Address: "127.0.0.1:3301"}))
+	assert.NotNil((&PreStopSpec{Type: "http"}).validate(nil))
+}
+
+// Test the stop sequences of the Instances.
+func TestSupervisorStopSequence(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, nil)
+
+	start := func(spec *InstanceSpec) *Instance {
+		id, err := sv.StartDuplicateInstance(spec)
+		if !assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err) {
+			return nil
+		}
+		inst := sv.getInstance(id)
+		waitSignalHandlers(t, inst.Pid())
+		return inst
+	}
+
+	// The default sequence.
+	if inst := start(&InstanceSpec{Name: "test_instance"}); inst != nil {
+		exit, err := sv.stopInstance(inst, true)
+		assert.Nil(err)
+		if assert.NotNil(exit) {
+			assert.Equal(0, exit.ExitCode)
+			assert.Equal("SIGINT", exit.StopSignal)
+		}
+		assert.Equal(exit, inst.Status().LastExit)
+	}
+
+	// The Instance ignores the signals, so it is terminated
+	// by the "SIGKILL" step after the pre-stop hook.
+	hookFile := filepath.Join(t.TempDir(), "pre_stop")
+	spec := InstanceSpec{Name: "test_instance", Env: []string{"INSTSIGIGNORE=true"},
+		StopSequence: []StopStep{{Signal: "SIGTERM"},
+			{Signal: "INT", After: Duration(50 * time.Millisecond)},
+			{Signal: "9", After: Duration(100 * time.Millisecond)}},
+		PreStop: &PreStopSpec{Type: PreStopExec, Command: []string{"touch", hookFile}}}
+	if inst := start(&spec); inst != nil {
+		stopStart := time.Now()
+		exit, err := sv.stopInstance(inst, true)
+		assert.Nil(err)
+		assert.True(time.Since(stopStart) >= 100*time.Millisecond,
+			"The sequence hasn't been followed.")
+		if assert.NotNil(exit) {
+			assert.Equal("SIGKILL", exit.Signal)
+			assert.Equal("SIGKILL", exit.StopSignal)
+		}
+		_, err = os.Stat(hookFile)
+		assert.Nil(err, "The pre-stop hook hasn't been run.")
+
+		// The sequence isn't applied to a terminated process.
+		assert.Nil(inst.Stop(time.Second, true))
+	}
+
+	// "SIGKILL" isn't sent without the force.
+	if inst := start(&spec); inst != nil {
+		_, err := sv.stopInstance(inst, false)
+		assert.EqualError(err, "The process couldn't be terminated correctly.")
+		assert.True(inst.IsAlive())
+		_, err = sv.stopInstance(inst, true)
+		assert.Nil(err)
+	}
+
+	// The pre-stop hook evaluates the code by the Instance.
+	var mutex sync.Mutex
+	var exprs []string
+	srv, err := iprototest.NewServer(func(req *iprototest.Request) ([]interface{}, error) {
+		if req.Type != iprototest.TypeEval {
+			return nil, &iproto.Error{Code: iprototest.ErrAccessDenied, Msg: "Access denied"}
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		exprs = append(exprs, req.Expr)
+		return nil, nil
+	}, nil)
+	if !assert.Nilf(err, `Can't start the server. Error: "%v"`, err) {
+		return
+	}
+	defer srv.Close()
+	spec = InstanceSpec{Name: "test_instance",
+		StopSequence: []StopStep{{Signal: "SIGTERM"}},
+		Iproto:       &IprotoSpec{Address: srv.Addr, Interval: Duration(time.Hour)},
+		PreStop:      &PreStopSpec{Type: PreStopIproto, Eval: "box.snapshot()"}}
+	if inst := start(&spec); inst != nil {
+		exit, err := sv.stopInstance(inst, true)
+		assert.Nil(err)
+		if assert.NotNil(exit) {
+			assert.Equal("SIGTERM", exit.StopSignal)
+		}
+		mutex.Lock()
+		assert.Contains(exprs, "box.snapshot()")
+		mutex.Unlock()
+	}
+}
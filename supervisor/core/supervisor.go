@@ -5,11 +5,17 @@ package core
 
 import (
 	"errors"
+	"log"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"strconv"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tarantool/tvisor/supervisor/metrics"
 )
 
 // Supervisor stores the information about started Instances.
@@ -19,10 +25,43 @@ type Supervisor struct {
 	termMutex sync.RWMutex
 	// instMapMutex is used to work with the map of Instances.
 	instMapMutex sync.RWMutex
+	// stateMutex is used to serialize writes of the state file.
+	stateMutex sync.Mutex
 	// instancesById is a map of running Instances.
 	instancesById map[int]*Instance
-	// cfg is a pointer to a Supervisor config.
+	// idsByKey is a map of the Instance key to the ID (including
+	// the keys reserved by the Instances that are being started).
+	idsByKey map[string]int
+	// logs is a set of log files used to store the output of Instances.
+	logs logFiles
+	// events is used to notify clients about the Instance lifecycle.
+	events *eventBus
+	// stopDurations is the histogram of the Instance stop durations.
+	stopDurations *metrics.HistogramVec
+	// reconcileMutex is used to serialize reconciliations.
+	reconcileMutex sync.Mutex
+	// reconcileStopped indicates that the Instances are being terminated
+	// and the declared Instances shouldn't be started anymore.
+	reconcileStopped bool
+	// driftMutex is used to work with the results of the last reconciliation.
+	driftMutex sync.Mutex
+	// lastReconcile is the time of the last reconciliation.
+	lastReconcile time.Time
+	// reconcileErrs is a map of a declared Instance key to the error
+	// of the last reconciliation.
+	reconcileErrs map[string]string
+	// reconcileWakeup is used to notify the reconciliation loop
+	// that the config has been reloaded or the loop should be stopped.
+	reconcileWakeup chan struct{}
+	// reloadMutex is used to serialize the config reloads.
+	reloadMutex sync.Mutex
+	// cfgMutex is used to swap the config on reload.
+	cfgMutex sync.RWMutex
+	// cfg is a pointer to a Supervisor config. The config
+	// isn't changed after it is set, it is only replaced.
 	cfg *Cfg
+	// cfgLoader is used to load a new config on reload.
+	cfgLoader CfgLoader
 	// lastId is an id of the last running Instance.
 	lastId int
 }
@@ -31,25 +70,89 @@ type Supervisor struct {
 func NewSupervisor(cfg *Cfg) *Supervisor {
 	sv := new(Supervisor)
 	sv.instancesById = make(map[int]*Instance)
+	sv.idsByKey = make(map[string]int)
+	sv.events = newEventBus(cfg.EventHistorySize)
+	sv.stopDurations = newStopDurations()
+	sv.reconcileWakeup = make(chan struct{}, 1)
 	sv.cfg = cfg
 	return sv
 }
 
-// addInstance adds the Instance to the Supervisor map.
-// Return ID of the Instance.
-func (sv *Supervisor) addInstance(inst *Instance) int {
+// config returns the current config of the Supervisor.
+func (sv *Supervisor) config() *Cfg {
+	sv.cfgMutex.RLock()
+	defer sv.cfgMutex.RUnlock()
+	return sv.cfg
+}
+
+// TermTimeout returns the current timeout of the
+// termination of the Instances (see Cfg.TermTimeout).
+func (sv *Supervisor) TermTimeout() time.Duration {
+	return time.Duration(sv.config().TermTimeout)
+}
+
+// reserveId returns the ID for a new Instance and reserves its key (see
+// reserveKey). The ID is reserved before the Instance is started, because
+// the cgroup of the Instance is named by it. The ID of an Instance that
+// has failed to start isn't reused.
+func (sv *Supervisor) reserveId(key string, allowDuplicate bool) (int, string, error) {
 	sv.instMapMutex.Lock()
 	defer sv.instMapMutex.Unlock()
+	key, err := sv.reserveKey(key, sv.lastId+1, allowDuplicate)
+	if err != nil {
+		return 0, "", err
+	}
 	sv.lastId++
-	id := sv.lastId
+	return sv.lastId, key, nil
+}
+
+// setInstance adds the Instance with the ID to the Supervisor map.
+func (sv *Supervisor) setInstance(id int, inst *Instance) {
+	sv.instMapMutex.Lock()
+	defer sv.instMapMutex.Unlock()
 	sv.instancesById[id] = inst
-	return id
 }
 
-// deleteInstance removes the Instance from the Supervisor map.
+// cgroupPath returns the path to the cgroup of the
+// Instance ("" if the cgroups are disabled).
+func (sv *Supervisor) cgroupPath(id int, name string) string {
+	parent := sv.config().CgroupParent
+	if parent == "" {
+		return ""
+	}
+	return filepath.Join(parent, name+"-"+strconv.Itoa(id))
+}
+
+// workDir returns the working directory of the Instance
+// ("" if it isn't set, see Cfg.WorkDir).
+func (sv *Supervisor) workDir(id int, spec *InstanceSpec) string {
+	tmpl := spec.WorkDir
+	if tmpl == "" {
+		tmpl = sv.config().WorkDir
+	}
+	if tmpl == "" {
+		return ""
+	}
+	return expandWorkDir(tmpl, id, spec.Name)
+}
+
+// deathSignal returns the signal sent to the Instances
+// when the Supervisor terminates (see Cfg.KillOnExit).
+func (sv *Supervisor) deathSignal() syscall.Signal {
+	if sv.config().KillOnExit {
+		return syscall.SIGKILL
+	}
+	return 0
+}
+
+// deleteInstance removes the Instance from the Supervisor map
+// and releases its key.
 func (sv *Supervisor) deleteInstance(id int) {
 	sv.instMapMutex.Lock()
 	defer sv.instMapMutex.Unlock()
+	if inst := sv.instancesById[id]; inst != nil {
+		sv.releaseKey(inst.key, id)
+	}
 	delete(sv.instancesById, id)
 }
 
@@ -68,53 +171,120 @@ func (sv *Supervisor) getInstanceByPid(pid int) (int, *Instance) {
 	sv.instMapMutex.RLock()
 	defer sv.instMapMutex.RUnlock()
 	for id, inst := range sv.instancesById {
-		if inst.Cmd.Process.Pid == pid {
+		if inst.Pid() == pid {
 			return id, inst
 		}
 	}
 	return 0, nil
 }
 
+// newInstanceCmd creates a command to run the Instance.
+func newInstanceCmd(instPath string, env []string) *exec.Cmd {
+	cmd := exec.Command(instPath)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd
+}
+
+// runInstance creates and starts an Instance with the specified parameters.
+// declared - the Instance is declared in the config (see Cfg.Instances).
+// id - the ID of the Instance.
+// key - the key reserved for the Instance (see reserveKey).
+// output - the output buffer of the replaced Instance (nil - a new one).
+func (sv *Supervisor) runInstance(spec *InstanceSpec, declared bool,
+	id int, key string, output *outputBuffer) (*Instance, error) {
+	// Form the path to the instance and check is it exists.
+	if err := spec.validate(); err != nil {
+		return nil, &InvalidSpecError{err}
+	}
+	instPath := path.Join(sv.config().InstancesDir, spec.Name+".lua")
+	if _, err := exec.LookPath(instPath); err != nil {
+		return nil, &InvalidSpecError{err}
+	}
+
+	// Start an Instance.
+	cmd := newInstanceCmd(instPath, spec.Env)
+	inst := NewInstance(spec, cmd)
+	inst.declared = declared
+	inst.id = id
+	inst.key = key
+	inst.cgroupPath = sv.cgroupPath(id, spec.Name)
+	inst.workDir = sv.workDir(id, spec)
+	inst.deathSignal = sv.deathSignal()
+	inst.output = output
+	if err := sv.openInstanceOutput(inst); err != nil {
+		return nil, err
+	}
+	if err := inst.Start(); err != nil {
+		if output != nil {
+			// The output buffer is still used by the replaced Instance.
+			sv.releaseInstanceLog(inst)
+		} else {
+			sv.closeInstanceOutput(inst)
+		}
+		return nil, err
+	}
+	return inst, nil
+}
+
 // StartInstance starts a new Instance with the specified parameters.
+// The start fails if the key of the Instance (see InstanceSpec.KeySuffix)
+// is already used by another Instance.
+// On fail returns 0, error.
+func (sv *Supervisor) StartInstance(spec *InstanceSpec) (int, error) {
+	return sv.startInstance(spec, false, false)
+}
+
+// StartDuplicateInstance is like StartInstance, but if the key of the
+// Instance is already used, the ID is appended to it ("<key>-<id>").
+func (sv *Supervisor) StartDuplicateInstance(spec *InstanceSpec) (int, error) {
+	return sv.startInstance(spec, false, true)
+}
+
+// startInstance starts a new Instance (see runInstance).
+// allowDuplicate - see StartDuplicateInstance.
 // On fail returns 0, error.
-func (sv *Supervisor) StartInstance(name string, env []string, restartable bool) (int, error) {
+func (sv *Supervisor) startInstance(spec *InstanceSpec, declared bool,
+	allowDuplicate bool) (int, error) {
 	// When Supervisor is terminating, we will lock "termMutex"
 	// to prevent new instances from starting during Supervisor termination.
 	sv.termMutex.RLock()
 	defer sv.termMutex.RUnlock()
 
-	// Form the path to the instance and check is it exists.
-	if name == "" {
-		return 0, errors.New(`The instance name is empty.`)
-	}
-	instPath := path.Join(sv.cfg.InstancesDir, name+".lua")
-	if _, err := exec.LookPath(instPath); err != nil {
+	id, key, err := sv.reserveId(spec.key(), allowDuplicate)
+	if err != nil {
 		return 0, err
 	}
-
-	// Start an Instance.
-	cmd := exec.Command(instPath)
-	cmd.Env = append(os.Environ(), env...)
-	inst := NewInstance(name, cmd, env, restartable)
-	if err := inst.Start(); err != nil {
+	inst, err := sv.runInstance(spec, declared, id, key, nil)
+	if err != nil {
+		sv.instMapMutex.Lock()
+		sv.releaseKey(key, id)
+		sv.instMapMutex.Unlock()
 		return 0, err
 	}
 
-	return sv.addInstance(inst), nil
+	sv.setInstance(id, inst)
+	sv.publishEvent(EventStarted, id, inst, nil, nil)
+	sv.persistState()
+	sv.startProbes(id, inst)
+	return id, nil
 }
 
 // RestartAfterTermInstance should be used to restart an instance in case
 // of an unexpected termination of the instance.
 // First of all, the method checks if the instance is alive. In this case,
 // the method returns the current Instance ID with an error.
-// If the Instance has been terminated and Instance.Restartable is true,
-// then the Instance will be restarted.
-// On success returns the Instance ID (0 invalid).
-func (sv *Supervisor) RestartAfterTermInstance(pid int) (int, error) {
+// If the Instance has been terminated and its restart policy requires
+// a restart, then the restart of the Instance will be scheduled after
+// the backoff delay (see RestartCfg).
+// status - the exit status of the process (nil if it is unknown). It
+// will be saved to the Instance exit history.
+// On success returns the Instance ID (0 invalid) and the restart delay.
+func (sv *Supervisor) RestartAfterTermInstance(pid int,
+	status *ExitStatus) (int, time.Duration, error) {
 	// Find an Instance.
 	id, inst := sv.getInstanceByPid(pid)
 	if inst == nil {
-		return 0, errors.New("Unknown Instance.")
+		return 0, 0, errors.New("Unknown Instance.")
 	}
 
 	// We don't want to restart the Instance at the same time
@@ -124,18 +294,146 @@ func (sv *Supervisor) RestartAfterTermInstance(pid int) (int, error) {
 
 	// If the Instance is alive something went wrong.
 	if inst.IsAlive() {
-		return id, errors.New("Instance is alive.")
+		return id, 0, errors.New("Instance is alive.")
 	}
 
-	if !inst.Restartable {
-		return id, errors.New("The Restart Instance flag is false.")
+	// The termination could be handled by the "SIGCHLD" handler
+	// and the liveness probe at the same time.
+	if !inst.markExitHandled() {
+		return id, 0, errors.New("The termination has already been handled.")
 	}
 
+	if status != nil {
+		inst.recordExit(status, sv.config().ExitHistorySize)
+	}
+	// The termination of the stopped Instance is expected.
+	if !inst.isStopped() {
+		sv.publishEvent(EventExited, id, inst, status, nil)
+	}
+
+	// The unhealthy Instance has been terminated by the Supervisor.
+	failure := status == nil || status.isFailure() || inst.isUnhealthy()
+	delay, err := inst.scheduleRestart(&sv.config().Restart, failure, func() {
+		sv.restartInstance(id, inst)
+	})
+	if err != nil {
+		if err == errRestartGivenUp {
+			sv.publishEvent(EventRestartGivenUp, id, inst, nil, err)
+		}
+		return id, 0, err
+	}
+
+	return id, delay, nil
+}
+
+// scheduleRetry schedules the restart of the Instance whose process
+// can't be started. It is considered as a failure (see scheduleRestart).
+func (sv *Supervisor) scheduleRetry(id int, inst *Instance) {
+	delay, err := inst.scheduleRestart(&sv.config().Restart, true, func() {
+		sv.restartInstance(id, inst)
+	})
+	if err != nil {
+		log.Printf(`The Instance won't be restarted. ID: %v. Error: "%v"`, id, err)
+		if err == errRestartGivenUp {
+			sv.publishEvent(EventRestartGivenUp, id, inst, nil, err)
+		}
+	} else {
+		log.Printf("The Instance restart has been scheduled. ID: %v. Delay: %v",
+			id, delay)
+	}
+}
+
+// restartInstance restarts the Instance after the backoff delay.
+// If the Instance can't be started, it is considered as a failure
+// and the next restart will be scheduled.
+func (sv *Supervisor) restartInstance(id int, inst *Instance) {
+	sv.termMutex.RLock()
+	defer sv.termMutex.RUnlock()
+
+	// The Instance could be stopped during the delay.
+	if sv.getInstance(id) != inst {
+		return
+	}
+
+	oldPid := inst.Pid()
 	if err := inst.Restart(); err != nil {
-		return id, err
+		log.Printf(`Can't restart the Instance. ID: %v. Error: "%v"`, id, err)
+		sv.scheduleRetry(id, inst)
+		return
 	}
+	sv.publishRestartEvent(id, inst, oldPid)
+	sv.persistState()
+	sv.startProbes(id, inst)
+	log.Printf("The Instance has been restarted. ID: %v", id)
+}
 
-	return id, nil
+// DeadAdoptedInstances returns PIDs of the terminated adopted Instances.
+// Adopted Instances aren't child processes of the Supervisor, so their
+// termination can't be noticed by "SIGCHLD" and should be checked
+// periodically. The returned PIDs can be passed to RestartAfterTermInstance
+// (the exit status of such Instances is unknown).
+func (sv *Supervisor) DeadAdoptedInstances() []int {
+	sv.instMapMutex.RLock()
+	defer sv.instMapMutex.RUnlock()
+	var pids []int
+	for _, inst := range sv.instancesById {
+		if inst.IsAdopted() && !inst.IsAlive() && !inst.isExitHandled() {
+			pids = append(pids, inst.Pid())
+		}
+	}
+	return pids
+}
+
+// ReapInstances reaps the terminated processes of the Instances in a
+// non-blocking style and returns their exit statuses. Only the processes
+// of the Instances are reaped: the other child processes of the Supervisor
+// (the commands of the "exec" probes, the pre-stop hooks) are waited for
+// by their owners. The PIDs of the returned statuses can be passed to
+// RestartAfterTermInstance.
+func (sv *Supervisor) ReapInstances() []*ExitStatus {
+	sv.instMapMutex.RLock()
+	var pids []int
+	for _, inst := range sv.instancesById {
+		if !inst.IsAdopted() {
+			pids = append(pids, inst.Pid())
+		}
+	}
+	sv.instMapMutex.RUnlock()
+
+	var statuses []*ExitStatus
+	for _, pid := range pids {
+		var status syscall.WaitStatus
+		var rusage syscall.Rusage
+		wpid, err := syscall.Wait4(pid, &status, syscall.WNOHANG, &rusage)
+		// "no child processes" means that the process has been already
+		// reaped by the stop of the Instance.
+		if err != nil && !errors.Is(err, syscall.ECHILD) {
+			log.Printf(`Can't wait for the process. PID: %v. Error: "%v"`,
+				pid, err)
+		}
+		if err != nil || wpid != pid {
+			continue
+		}
+		statuses = append(statuses, NewExitStatus(pid, status, &rusage))
+	}
+	return statuses
+}
+
+// stopInstance stops the Instance and observes the stop duration.
+// Returns the exit status of the stopped process (nil if it is
+// unknown), which is recorded to the exit history.
+func (sv *Supervisor) stopInstance(inst *Instance, force bool) (*ExitStatus, error) {
+	start := time.Now()
+	err := inst.Stop(sv.TermTimeout(), force)
+	sv.stopDurations.Observe(time.Since(start).Seconds(), inst.Spec.Name)
+	if err != nil {
+		return nil, err
+	}
+	status := inst.stoppedExit()
+	if status != nil && inst.markExitHandled() {
+		inst.recordExit(status, sv.config().ExitHistorySize)
+	}
+	return status, nil
 }
 
 // StopInstance terminate an Instance by ID.
@@ -151,16 +449,25 @@ func (sv *Supervisor) StopInstance(id int, force bool) error {
 		return errors.New("Unknown instance with id " + strconv.Itoa(id))
 	}
 
-	if err := inst.Stop(sv.cfg.TermTimeout, force); err != nil {
+	sv.publishEvent(EventStopping, id, inst, nil, nil)
+	exit, err := sv.stopInstance(inst, force)
+	if err != nil {
 		return err
 	}
 	sv.deleteInstance(id)
+	sv.closeInstanceOutput(inst)
+	inst.removeCgroup()
+	sv.publishEvent(EventStopped, id, inst, exit, nil)
+	sv.persistState()
 
 	return nil
 }
 
 // StopAllInstances terminate all Instances managed by the Supervisor.
+// The declared Instances won't be started by the reconciliation anymore.
 func (sv *Supervisor) StopAllInstances() {
+	sv.stopReconciliation()
+
 	// Disable start / stop Instances.
 	sv.termMutex.Lock()
 
@@ -173,8 +480,12 @@ func (sv *Supervisor) StopAllInstances() {
 	for id, inst := range sv.instancesById {
 		wg.Add(1)
 		go func(id int, inst *Instance) {
-			inst.Stop(sv.cfg.TermTimeout, true)
+			sv.publishEvent(EventStopping, id, inst, nil, nil)
+			exit, _ := sv.stopInstance(inst, true)
 			sv.deleteInstance(id)
+			sv.closeInstanceOutput(inst)
+			inst.removeCgroup()
+			sv.publishEvent(EventStopped, id, inst, exit, nil)
 			wg.Done()
 		}(id, inst)
 	}
@@ -184,7 +495,7 @@ func (sv *Supervisor) StopAllInstances() {
 	sv.instMapMutex.RUnlock()
 	// Wait for the end of the termination process.
 	wg.Wait()
-
+	sv.persistState()
 }
 
 // GetInstanceStatus returns the current status of the Instance.
@@ -199,16 +510,47 @@ func (sv *Supervisor) GetInstanceStatus(id int) (*InstanceStatus, error) {
 	return inst.Status(), nil
 }
 
-// ListInstances returns a list of running instances.
+// ListInstances returns a list of running instances by the key.
 func (sv *Supervisor) ListInstances() map[string]*InstanceStatus {
 	sv.instMapMutex.RLock()
 	defer sv.instMapMutex.RUnlock()
 	instsMap := make(map[string]*InstanceStatus)
-	for id, inst := range sv.instancesById {
-		// For following serialization to JSON, a string is used as
-		// a key instead of an int.
-		instsMap[strconv.Itoa(id)] = inst.Status()
+	for _, inst := range sv.instancesById {
+		instsMap[inst.key] = inst.Status()
 	}
 
 	return instsMap
 }
+
+// GetInstanceOutput returns "n" last lines of the Instance output
+// matching the filter (nil - all lines). n <= 0 means all the lines
+// kept in memory (see Cfg.OutputBufferLines).
+func (sv *Supervisor) GetInstanceOutput(id int, n int,
+	filter *OutputFilter) ([]OutputLine, error) {
+	inst := sv.getInstance(id)
+	if inst == nil {
+		return nil, errors.New("Unknown instance with id " + strconv.Itoa(id))
+	}
+	if inst.output == nil {
+		return nil, errors.New("The output of Instances isn't captured.")
+	}
+	return inst.output.last(n, filter), nil
+}
+
+// FollowInstanceOutput returns a channel that receives new lines of the
+// Instance output. The channel is closed when the Instance is removed
+// or when the returned cancel function is called.
+func (sv *Supervisor) FollowInstanceOutput(id int) (<-chan OutputLine, func(), error) {
+	inst := sv.getInstance(id)
+	if inst == nil {
+		return nil, nil, errors.New("Unknown instance with id " + strconv.Itoa(id))
+	}
+	if inst.output == nil {
+		return nil, nil, errors.New("The output of Instances isn't captured.")
+	}
+	follower, err := inst.output.follow()
+	if err != nil {
+		return nil, nil, err
+	}
+	return follower, func() { inst.output.unfollow(follower) }, nil
+}
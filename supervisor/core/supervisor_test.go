@@ -1,44 +1,61 @@
 package core
 
 import (
+	"os"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-// Test the basic Supervisor functionality.
-func TestSupervisorBase(t *testing.T) {
-	assert := assert.New(t)
-	// Create config for Supervisor.
-	cfg := new(Cfg)
-	cfg.InstancesDir = "../../test_instances"
-	cfg.TermTimeout = 100 * time.Millisecond
+// TestMain runs the child setup if the test executable has been run for
+// it, as the Instances with the settings are run by the executable itself.
+func TestMain(m *testing.M) {
+	RunChildSetupIfRequested()
+	os.Exit(m.Run())
+}
 
-	// Create Supervisor.
+// newTestSupervisor creates a Supervisor of the test Instances that is
+// stopped after the test. setup - changes the config (nil - the defaults).
+func newTestSupervisor(t *testing.T, setup func(*Cfg)) *Supervisor {
+	cfg := new(Cfg)
+	cfg.InstancesDir = testInstPath
+	cfg.TermTimeout = Duration(100 * time.Millisecond)
+	if setup != nil {
+		setup(cfg)
+	}
 	sv := NewSupervisor(cfg)
-
-	// Set a cleanup callback.
 	t.Cleanup(func() { sv.StopAllInstances() })
+	return sv
+}
+
+// Test the basic Supervisor functionality.
+func TestSupervisorBase(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, nil)
 
 	// Run several instances (some with additional env and some without).
 	instName := "test_instance"
 
-	id1, err := sv.StartInstance(instName, nil, false)
+	id1, err := sv.StartDuplicateInstance(&InstanceSpec{Name: instName})
 	assert.Nilf(err, `Can't start the Instance.Error: "%v"`, err)
 
-	id2, err := sv.StartInstance(instName, []string{"INSTSIGIGNORE=true"}, false)
+	id2, err := sv.StartDuplicateInstance(&InstanceSpec{Name: instName,
+		Env: []string{"INSTSIGIGNORE=true"}})
 	assert.Nilf(err, `Can't start the Instance.Error: "%v"`, err)
 
-	_, err = sv.StartInstance(instName, nil, false)
+	_, err = sv.StartDuplicateInstance(&InstanceSpec{Name: instName})
 	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
 
-	_, err = sv.StartInstance(instName, []string{"INSTSIGIGNORE=true"}, false)
+	_, err = sv.StartDuplicateInstance(&InstanceSpec{Name: instName,
+		Env: []string{"INSTSIGIGNORE=true"}})
 	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
 
 	// We need to wait for the new process to set handlers.
-	// It is necessary to update for more correct synchronization.
-	time.Sleep(100 * time.Millisecond)
+	for _, status := range sv.ListInstances() {
+		waitSignalHandlers(t, status.Pid)
+	}
 
 	// Check  all instance are running.
 	assert.Equal(len(sv.ListInstances()), 4,
@@ -72,3 +89,49 @@ func TestSupervisorBase(t *testing.T) {
 		"Expected number of instances is 0, but now it's %v",
 		len(sv.ListInstances()))
 }
+
+// TestReapInstances checks that only the processes of the Instances are
+// reaped, so the "exec" probes work while the zombies are being handled.
+func TestReapInstances(t *testing.T) {
+	assert := assert.New(t)
+	sv := newTestSupervisor(t, nil)
+
+	id, err := sv.StartInstance(&InstanceSpec{Name: "test_instance"})
+	assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err)
+	status, err := sv.GetInstanceStatus(id)
+	assert.Nilf(err, `Can't get Instance status. Error: "%v"`, err)
+	waitSignalHandlers(t, status.Pid)
+
+	// Reap the zombies concurrently, like the "SIGCHLD" handler does.
+	done := make(chan struct{})
+	reaped := make(chan *ExitStatus, 1)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, exitStatus := range sv.ReapInstances() {
+				reaped <- exitStatus
+			}
+		}
+	}()
+	defer close(done)
+
+	execProbe := ProbeSpec{Type: ProbeExec, Command: []string{"true"}}
+	for i := 0; i < 50; i++ {
+		err := execProbe.check(nil)
+		assert.Nilf(err, `The "exec" probe has failed. Error: "%v"`, err)
+	}
+
+	// The terminated Instance is still reaped.
+	assert.Nil(syscall.Kill(status.Pid, syscall.SIGKILL))
+	select {
+	case exitStatus := <-reaped:
+		assert.Equal(status.Pid, exitStatus.Pid)
+		assert.Equal("SIGKILL", exitStatus.Signal)
+	case <-time.After(5 * time.Second):
+		assert.Fail("The terminated Instance hasn't been reaped.")
+	}
+}
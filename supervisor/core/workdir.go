@@ -0,0 +1,123 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// workDirMode is the mode of the created working directories.
+const workDirMode = 0750
+
+// Placeholders of the working directory template.
+const (
+	// workDirName is replaced with the name of the Instance.
+	workDirName = "{name}"
+	// workDirId is replaced with the ID of the Instance.
+	workDirId = "{id}"
+)
+
+// workDirSubdirs maps the subdirectories created in the working directory
+// to the environment variables with their paths passed to the Instance.
+var workDirSubdirs = []struct {
+	name string
+	env  string
+}{
+	{"run", "TVISOR_INSTANCE_RUN_DIR"},
+	{"log", "TVISOR_INSTANCE_LOG_DIR"},
+	{"data", "TVISOR_INSTANCE_DATA_DIR"},
+}
+
+// workDirEnv is the environment variable with the path to the working directory.
+const workDirEnv = "TVISOR_INSTANCE_WORK_DIR"
+
+// placeholderRe matches a placeholder of the template.
+var placeholderRe = regexp.MustCompile(`{[^{}]*}`)
+
+// checkWorkDir checks the template of the working directory.
+func checkWorkDir(tmpl string) error {
+	if !filepath.IsAbs(tmpl) {
+		return errors.New(`The working directory "` + tmpl + `" should be an absolute path.`)
+	}
+	for _, placeholder := range placeholderRe.FindAllString(tmpl, -1) {
+		if placeholder != workDirName && placeholder != workDirId {
+			return errors.New(`Unknown placeholder "` + placeholder +
+				`" in the working directory "` + tmpl + `".`)
+		}
+	}
+	return nil
+}
+
+// expandWorkDir returns the working directory of the Instance.
+func expandWorkDir(tmpl string, id int, name string) string {
+	return strings.NewReplacer(workDirName, name, workDirId, strconv.Itoa(id)).Replace(tmpl)
+}
+
+// parseUmask parses the umask of the Instance (an octal number).
+func parseUmask(umask string) (int, error) {
+	value, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, errors.New(`Invalid umask "` + umask + `": expected an octal number ` +
+			`in the range [0, 0777].`)
+	}
+	return int(value), nil
+}
+
+// prepareDir creates the directory (if it doesn't exist) and changes
+// its owner to the user of the Instance (if cred isn't nil).
+func prepareDir(path string, cred *syscall.Credential) error {
+	if err := os.Mkdir(path, workDirMode); err == nil {
+		// The mode of the created directory is affected by the umask.
+		if err := os.Chmod(path, workDirMode); err != nil {
+			return err
+		}
+	} else if !os.IsExist(err) {
+		return err
+	}
+	if cred != nil {
+		return os.Chown(path, int(cred.Uid), int(cred.Gid))
+	}
+	return nil
+}
+
+// prepareWorkDir creates the working directory of the Instance and its
+// subdirectories, runs the command in it and passes their paths to the
+// process in the environment. The "infoMutex" should be locked.
+// cred - the credential of the Instance process (nil - inherited).
+func (inst *Instance) prepareWorkDir(cred *syscall.Credential) error {
+	if inst.workDir == "" {
+		return nil
+	}
+	// The path to the executable is relative to the working directory
+	// of the child process.
+	path, err := filepath.Abs(inst.Cmd.Path)
+	if err != nil {
+		return err
+	}
+	inst.Cmd.Path = path
+
+	err = os.MkdirAll(filepath.Dir(inst.workDir), 0755)
+	if err == nil {
+		err = prepareDir(inst.workDir, cred)
+	}
+	env := []string{workDirEnv + "=" + inst.workDir}
+	for _, subdir := range workDirSubdirs {
+		if err != nil {
+			break
+		}
+		subdirPath := filepath.Join(inst.workDir, subdir.name)
+		err = prepareDir(subdirPath, cred)
+		env = append(env, subdir.env+"="+subdirPath)
+	}
+	if err != nil {
+		return errors.New(`Can't prepare the working directory "` + inst.workDir +
+			`": ` + err.Error() + ".")
+	}
+	inst.Cmd.Dir = inst.workDir
+	inst.Cmd.Env = append(inst.Cmd.Env, env...)
+	return nil
+}
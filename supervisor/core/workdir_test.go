@@ -0,0 +1,93 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWorkDirTemplate checks the templates of the working directories.
+func TestWorkDirTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(checkWorkDir("/var/lib/tarantool/{name}"))
+	assert.Equal("/var/lib/tarantool/app-3/app",
+		expandWorkDir("/var/lib/tarantool/{name}-{id}/{name}", 3, "app"))
+	assert.EqualError(checkWorkDir("tarantool/{name}"),
+		`The working directory "tarantool/{name}" should be an absolute path.`)
+	assert.EqualError(checkWorkDir("/var/lib/{instance}"),
+		`Unknown placeholder "{instance}" in the working directory "/var/lib/{instance}".`)
+
+	umask, err := parseUmask("0027")
+	assert.Nil(err)
+	assert.Equal(027, umask)
+	_, err = parseUmask("0800")
+	assert.NotNil(err)
+	_, err = parseUmask("1777")
+	assert.NotNil(err)
+	assert.NotNil((&InstanceSpec{Name: "app", Umask: "rwx"}).validate())
+}
+
+// Test the working directories of the Instances.
+func TestSupervisorWorkDir(t *testing.T) {
+	assert := assert.New(t)
+	root := t.TempDir()
+	sv := newTestSupervisor(t, func(cfg *Cfg) {
+		cfg.WorkDir = filepath.Join(root, "tarantool", "{name}-{id}")
+	})
+
+	id, err := sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance", Umask: "0027"})
+	if !assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err) {
+		return
+	}
+	pid := sv.getInstance(id).Pid()
+	waitExec(t, pid, testInstPath)
+
+	workDir := filepath.Join(root, "tarantool", "test_instance-"+strconv.Itoa(id))
+	status, _ := sv.GetInstanceStatus(id)
+	assert.Equal(workDir, status.WorkDir)
+	cwd, err := os.Readlink(procPath(pid, "cwd"))
+	assert.Nil(err)
+	assert.Equal(workDir, cwd)
+	fields, err := procStatus(pid, "Umask")
+	assert.Nil(err)
+	assert.Equal("0027", fields["Umask"])
+
+	data, err := ioutil.ReadFile(procPath(pid, "environ"))
+	assert.Nil(err)
+	env := strings.Split(string(data), "\x00")
+	assert.Contains(env, workDirEnv+"="+workDir)
+	for _, subdir := range workDirSubdirs {
+		path := filepath.Join(workDir, subdir.name)
+		assert.Contains(env, subdir.env+"="+path)
+		if info, err := os.Stat(path); assert.Nil(err) {
+			assert.True(info.IsDir())
+			assert.Equal(os.FileMode(workDirMode), info.Mode().Perm())
+		}
+	}
+
+	// The spec overrides the template.
+	id, err = sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance",
+		WorkDir: filepath.Join(root, "custom")})
+	if assert.Nilf(err, `Can't start the Instance. Error: "%v"`, err) {
+		pid := sv.getInstance(id).Pid()
+		waitSignalHandlers(t, pid)
+		cwd, _ := os.Readlink(procPath(pid, "cwd"))
+		assert.Equal(filepath.Join(root, "custom"), cwd)
+	}
+
+	// The Instance isn't started if the directory can't be created.
+	if err := ioutil.WriteFile(filepath.Join(root, "file"), nil, 0644); err != nil {
+		t.Fatalf(`Can't create the file. Error: "%v"`, err)
+	}
+	_, err = sv.StartDuplicateInstance(&InstanceSpec{Name: "test_instance",
+		WorkDir: filepath.Join(root, "file", "{name}")})
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "Can't prepare the working directory")
+	}
+}
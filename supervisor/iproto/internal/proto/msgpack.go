@@ -0,0 +1,393 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Encode appends the MessagePack representation of the value to
+// the buffer. Supported types: nil, bool, integers, floats, string,
+// []byte, slices and maps of the supported types.
+func Encode(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case int:
+		encodeInt(buf, int64(v))
+	case int8:
+		encodeInt(buf, int64(v))
+	case int16:
+		encodeInt(buf, int64(v))
+	case int32:
+		encodeInt(buf, int64(v))
+	case int64:
+		encodeInt(buf, v)
+	case uint:
+		encodeUint(buf, uint64(v))
+	case uint8:
+		encodeUint(buf, uint64(v))
+	case uint16:
+		encodeUint(buf, uint64(v))
+	case uint32:
+		encodeUint(buf, uint64(v))
+	case uint64:
+		encodeUint(buf, v)
+	case float32:
+		buf.WriteByte(0xca)
+		binary.Write(buf, binary.BigEndian, math.Float32bits(v))
+	case float64:
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case string:
+		encodeStrHeader(buf, len(v))
+		buf.WriteString(v)
+	case []byte:
+		encodeBinHeader(buf, len(v))
+		buf.Write(v)
+	default:
+		return encodeValue(buf, reflect.ValueOf(value))
+	}
+	return nil
+}
+
+// encodeValue encodes slices and maps.
+func encodeValue(buf *bytes.Buffer, value reflect.Value) error {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		encodeContainerHeader(buf, value.Len(), 0x90, 0xdc)
+		for i := 0; i < value.Len(); i++ {
+			if err := Encode(buf, value.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		encodeContainerHeader(buf, value.Len(), 0x80, 0xde)
+		iter := value.MapRange()
+		for iter.Next() {
+			if err := Encode(buf, iter.Key().Interface()); err != nil {
+				return err
+			}
+			if err := Encode(buf, iter.Value().Interface()); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("Unsupported type: " + value.Type().String() + ".")
+	}
+	return nil
+}
+
+// encodeInt encodes a signed integer.
+func encodeInt(buf *bytes.Buffer, v int64) {
+	switch {
+	case v >= 0:
+		encodeUint(buf, uint64(v))
+	case v >= -32:
+		buf.WriteByte(byte(v))
+	case v >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(v))
+	case v >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(v))
+	case v >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(v))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, v)
+	}
+}
+
+// encodeUint encodes an unsigned integer.
+func encodeUint(buf *bytes.Buffer, v uint64) {
+	switch {
+	case v <= 0x7f:
+		buf.WriteByte(byte(v))
+	case v <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(v))
+	case v <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(v))
+	case v <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(v))
+	default:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, v)
+	}
+}
+
+// encodeStrHeader encodes the header of a string.
+func encodeStrHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n <= 31:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdb)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// encodeBinHeader encodes the header of a binary string.
+func encodeBinHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xc4)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xc5)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xc6)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// encodeContainerHeader encodes the header of an array or a map.
+// fix - the "fix" format code, code16 - the 16-bit format code
+// (the 32-bit format code follows it).
+func encodeContainerHeader(buf *bytes.Buffer, n int, fix byte, code16 byte) {
+	switch {
+	case n <= 15:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code16 + 1)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// decoder decodes MessagePack values.
+type decoder struct {
+	r *bytes.Reader
+}
+
+// Decode decodes a value from the data. Integers are decoded
+// to int64 (or uint64 if they don't fit), maps to map[interface{}]interface{},
+// arrays to []interface{}, strings to string and binary strings to []byte.
+func Decode(data []byte) (interface{}, error) {
+	dec := decoder{r: bytes.NewReader(data)}
+	return dec.decode()
+}
+
+// DecodeAll decodes all the consecutive values from the data.
+func DecodeAll(data []byte) ([]interface{}, error) {
+	dec := decoder{r: bytes.NewReader(data)}
+	var values []interface{}
+	for dec.r.Len() != 0 {
+		value, err := dec.decode()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// readN reads "n" bytes.
+func (dec *decoder) readN(n uint64) ([]byte, error) {
+	if n > uint64(dec.r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, n)
+	_, err := io.ReadFull(dec.r, data)
+	return data, err
+}
+
+// readUint reads a big-endian unsigned integer of "size" bytes.
+func (dec *decoder) readUint(size int) (uint64, error) {
+	data, err := dec.readN(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+// decode decodes the next value.
+func (dec *decoder) decode() (interface{}, error) {
+	code, err := dec.r.ReadByte()
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xf0 == 0x80:
+		return dec.decodeMap(uint64(code & 0x0f))
+	case code&0xf0 == 0x90:
+		return dec.decodeArray(uint64(code & 0x0f))
+	case code&0xe0 == 0xa0:
+		return dec.decodeStr(uint64(code & 0x1f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := dec.readUint(1 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return dec.readN(n)
+	case 0xca:
+		v, err := dec.readUint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := dec.readUint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := dec.readUint(1 << (code - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if v > math.MaxInt64 {
+			return v, nil
+		}
+		return int64(v), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code - 0xd0)
+		v, err := dec.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign extension.
+		shift := uint(64 - 8*size)
+		return int64(v<<shift) >> shift, nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := dec.readUint(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return dec.decodeStr(n)
+	case 0xdc, 0xdd:
+		n, err := dec.readUint(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return dec.decodeArray(n)
+	case 0xde, 0xdf:
+		n, err := dec.readUint(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return dec.decodeMap(n)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xc7, 0xc8, 0xc9:
+		return dec.decodeExt(code)
+	}
+	return nil, errors.New("Unknown MessagePack code: 0x" +
+		strconv.FormatUint(uint64(code), 16) + ".")
+}
+
+// decodeStr decodes a string of "n" bytes.
+func (dec *decoder) decodeStr(n uint64) (interface{}, error) {
+	data, err := dec.readN(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// decodeArray decodes an array of "n" elements.
+func (dec *decoder) decodeArray(n uint64) (interface{}, error) {
+	// Each element takes at least 1 byte.
+	if n > uint64(dec.r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	arr := make([]interface{}, n)
+	for i := range arr {
+		var err error
+		if arr[i], err = dec.decode(); err != nil {
+			return nil, err
+		}
+	}
+	return arr, nil
+}
+
+// decodeMap decodes a map of "n" pairs.
+func (dec *decoder) decodeMap(n uint64) (interface{}, error) {
+	if n > uint64(dec.r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	m := make(map[interface{}]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		key, err := dec.decode()
+		if err != nil {
+			return nil, err
+		}
+		value, err := dec.decode()
+		if err != nil {
+			return nil, err
+		}
+		// Slices and maps can't be keys in Go.
+		switch key.(type) {
+		case []interface{}, map[interface{}]interface{}, []byte:
+			return nil, errors.New("Unsupported map key type.")
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// Ext describes a MessagePack extension value (for example,
+// a decimal or a UUID) that is returned as is.
+type Ext struct {
+	// Type - the extension type.
+	Type int8
+	// Data - the extension data.
+	Data []byte
+}
+
+// decodeExt decodes an extension value.
+func (dec *decoder) decodeExt(code byte) (interface{}, error) {
+	var n uint64
+	var err error
+	switch code {
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		n = 1 << (code - 0xd4)
+	default:
+		if n, err = dec.readUint(1 << (code - 0xc7)); err != nil {
+			return nil, err
+		}
+	}
+	extType, err := dec.r.ReadByte()
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data, err := dec.readN(n)
+	if err != nil {
+		return nil, err
+	}
+	return &Ext{Type: int8(extType), Data: data}, nil
+}
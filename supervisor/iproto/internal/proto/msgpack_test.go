@@ -0,0 +1,56 @@
+package proto
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMsgpack checks encoding and decoding of MessagePack values.
+func TestMsgpack(t *testing.T) {
+	assert := assert.New(t)
+	longStr := string(bytes.Repeat([]byte("a"), 300))
+	values := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{nil, nil},
+		{true, true},
+		{false, false},
+		{5, int64(5)},
+		{-5, int64(-5)},
+		{200, int64(200)},
+		{-200, int64(-200)},
+		{70000, int64(70000)},
+		{int64(math.MinInt64), int64(math.MinInt64)},
+		{uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{1.5, 1.5},
+		{"str", "str"},
+		{longStr, longStr},
+		{[]byte{1, 2}, []byte{1, 2}},
+		{[]interface{}{1, "a"}, []interface{}{int64(1), "a"}},
+		{map[string]interface{}{"a": []int{1}},
+			map[interface{}]interface{}{"a": []interface{}{int64(1)}}},
+	}
+
+	for _, v := range values {
+		var buf bytes.Buffer
+		assert.Nil(Encode(&buf, v.value))
+		decoded, err := Decode(buf.Bytes())
+		assert.Nilf(err, `Can't decode %v. Error: "%v"`, v.value, err)
+		assert.Equal(v.expected, decoded)
+	}
+
+	// Truncated data.
+	_, err := Decode([]byte{0x92, 0x01})
+	assert.NotNil(err, "The truncated array is decoded.")
+	// Several values.
+	values2, err := DecodeAll([]byte{0x01, 0xa1, 'a', 0xc0})
+	assert.Nil(err)
+	assert.Equal([]interface{}{int64(1), "a", nil}, values2)
+
+	// Unsupported type.
+	assert.NotNil(Encode(&bytes.Buffer{}, struct{}{}))
+}
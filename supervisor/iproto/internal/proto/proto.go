@@ -0,0 +1,86 @@
+/*
+Proto implements the encoding of the Tarantool binary protocol shared
+by the iproto client and the fake server used in tests.
+*/
+package proto
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+// GreetingSize is the size of the greeting sent by Tarantool
+// right after the connection is established.
+const GreetingSize = 128
+
+// scrambleSize is the size of the salt part used for the authentication.
+const scrambleSize = 20
+
+// Request types.
+const (
+	TypeOK    = 0x00
+	TypeAuth  = 0x07
+	TypeEval  = 0x08
+	TypeCall  = 0x0a
+	TypePing  = 0x40
+	TypeError = 0x8000
+)
+
+// Keys of the header and the body.
+const (
+	KeyRequestType  = 0x00
+	KeySync         = 0x01
+	KeyTuple        = 0x21
+	KeyFunctionName = 0x22
+	KeyUserName     = 0x23
+	KeyExpr         = 0x27
+	KeyData         = 0x30
+	KeyError24      = 0x31
+)
+
+// EncodePacket encodes the packet with the header and the body.
+// The size of the packet is always encoded as uint32.
+func EncodePacket(header map[int]interface{}, body map[int]interface{}) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := Encode(&payload, header); err != nil {
+		return nil, err
+	}
+	if err := Encode(&payload, body); err != nil {
+		return nil, err
+	}
+
+	var packet bytes.Buffer
+	packet.WriteByte(0xce)
+	binary.Write(&packet, binary.BigEndian, uint32(payload.Len()))
+	payload.WriteTo(&packet)
+	return packet.Bytes(), nil
+}
+
+// Scramble calculates the "chap-sha1" scramble of the password:
+// sha1(password) XOR sha1(salt + sha1(sha1(password))).
+// salt - the base64-encoded salt from the greeting.
+func Scramble(salt string, password string) ([]byte, error) {
+	decodedSalt, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(decodedSalt) < scrambleSize {
+		return nil, errors.New("The salt is too short.")
+	}
+
+	step1 := sha1.Sum([]byte(password))
+	step2 := sha1.Sum(step1[:])
+	hash := sha1.New()
+	hash.Write(decodedSalt[:scrambleSize])
+	hash.Write(step2[:])
+	step3 := hash.Sum(nil)
+
+	scramble := make([]byte, sha1.Size)
+	for i := range scramble {
+		scramble[i] = step1[i] ^ step3[i]
+	}
+	return scramble, nil
+}
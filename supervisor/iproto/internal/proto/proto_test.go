@@ -0,0 +1,26 @@
+package proto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEncodePacket checks the encoding of a packet.
+func TestEncodePacket(t *testing.T) {
+	assert := assert.New(t)
+	packet, err := EncodePacket(map[int]interface{}{KeyRequestType: TypePing},
+		map[int]interface{}{})
+	assert.Nil(err)
+	assert.Equal([]byte{0xce, 0x00, 0x00, 0x00, 0x04, 0x81, 0x00, 0x40, 0x80}, packet)
+}
+
+// TestScramble checks the "chap-sha1" scramble.
+func TestScramble(t *testing.T) {
+	assert := assert.New(t)
+	scramble, err := Scramble("yIdOiJmJmB8uR/jUG7X9T3hSuGcTqlasqOFRqDYSyp8=", "secret")
+	assert.Nil(err)
+	assert.Equal(20, len(scramble))
+	_, err = Scramble("c2FsdA==", "secret")
+	assert.NotNil(err, "The short salt is accepted.")
+}
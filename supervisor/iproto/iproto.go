@@ -0,0 +1,264 @@
+/*
+Iproto provides a minimal client of the Tarantool binary protocol.
+*/
+package iproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tarantool/tvisor/supervisor/iproto/internal/proto"
+)
+
+// maxResponseSize is the maximum size of a response accepted by the client.
+const maxResponseSize = 64 * 1024 * 1024
+
+// authMechanism is the only supported authentication mechanism.
+const authMechanism = "chap-sha1"
+
+// Ext describes a MessagePack extension value (for example,
+// a decimal or a UUID) returned by Tarantool. It is returned as is.
+type Ext = proto.Ext
+
+// Greeting describes the greeting of Tarantool.
+type Greeting struct {
+	// Version is the first line of the greeting
+	// (for example, "Tarantool 2.8.2 (Binary) <uuid>").
+	Version string
+	// Salt is the base64-encoded salt used for the authentication.
+	Salt string
+}
+
+// Error describes an error returned by Tarantool.
+type Error struct {
+	// Code is the error code.
+	Code int
+	// Msg is the error message.
+	Msg string
+}
+
+// Error returns the error message.
+func (err *Error) Error() string {
+	return err.Msg + " (code " + strconv.Itoa(err.Code) + ")"
+}
+
+// Conn is a connection to Tarantool. Requests are sent one by one.
+type Conn struct {
+	// conn is the network connection.
+	conn net.Conn
+	// reader is used to read the responses.
+	reader *bufio.Reader
+	// greeting is the greeting received from Tarantool.
+	greeting Greeting
+	// timeout is the timeout of a request.
+	timeout time.Duration
+	// mutex is used to send requests one by one.
+	mutex sync.Mutex
+	// sync is the ID of the last request.
+	sync uint64
+}
+
+// Dial connects to Tarantool and reads the greeting.
+// network - "tcp" or "unix".
+// timeout - the timeout of the connection and of each request.
+func Dial(network string, address string, timeout time.Duration) (*Conn, error) {
+	netConn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &Conn{
+		conn:    netConn,
+		reader:  bufio.NewReader(netConn),
+		timeout: timeout,
+	}
+	if err := conn.readGreeting(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// readGreeting reads and parses the greeting.
+func (conn *Conn) readGreeting() error {
+	conn.conn.SetReadDeadline(time.Now().Add(conn.timeout))
+	data := make([]byte, proto.GreetingSize)
+	if _, err := io.ReadFull(conn.reader, data); err != nil {
+		return err
+	}
+	version := strings.TrimSpace(string(data[:proto.GreetingSize/2]))
+	if !strings.HasPrefix(version, "Tarantool ") {
+		return errors.New(`Invalid greeting: "` + version + `".`)
+	}
+	conn.greeting = Greeting{
+		Version: version,
+		Salt:    strings.TrimSpace(string(data[proto.GreetingSize/2:])),
+	}
+	return nil
+}
+
+// Greeting returns the greeting received from Tarantool.
+func (conn *Conn) Greeting() Greeting {
+	return conn.greeting
+}
+
+// Close closes the connection.
+func (conn *Conn) Close() error {
+	return conn.conn.Close()
+}
+
+// Ping checks that Tarantool is able to process requests.
+func (conn *Conn) Ping() error {
+	_, err := conn.request(proto.TypePing, map[int]interface{}{})
+	return err
+}
+
+// Auth authenticates the user by using the "chap-sha1" mechanism.
+func (conn *Conn) Auth(user string, password string) error {
+	scramble, err := proto.Scramble(conn.greeting.Salt, password)
+	if err != nil {
+		return err
+	}
+	_, err = conn.request(proto.TypeAuth, map[int]interface{}{
+		proto.KeyUserName: user,
+		proto.KeyTuple:    []interface{}{authMechanism, scramble},
+	})
+	return err
+}
+
+// Call calls the stored or the global Lua function with the arguments.
+// Returns the values returned by the function.
+func (conn *Conn) Call(function string, args ...interface{}) ([]interface{}, error) {
+	if args == nil {
+		args = []interface{}{}
+	}
+	body, err := conn.request(proto.TypeCall, map[int]interface{}{
+		proto.KeyFunctionName: function,
+		proto.KeyTuple:        args,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return responseData(body)
+}
+
+// Eval evaluates the Lua expression with the arguments ("...").
+// Returns the values returned by the expression.
+func (conn *Conn) Eval(expr string, args ...interface{}) ([]interface{}, error) {
+	if args == nil {
+		args = []interface{}{}
+	}
+	body, err := conn.request(proto.TypeEval, map[int]interface{}{
+		proto.KeyExpr:  expr,
+		proto.KeyTuple: args,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return responseData(body)
+}
+
+// responseData returns the data of the response.
+func responseData(body map[interface{}]interface{}) ([]interface{}, error) {
+	value, ok := body[int64(proto.KeyData)]
+	if !ok {
+		return []interface{}{}, nil
+	}
+	data, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("Invalid data of the response.")
+	}
+	return data, nil
+}
+
+// request sends the request and returns the body of the response.
+func (conn *Conn) request(reqType int, body map[int]interface{}) (map[interface{}]interface{}, error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.sync++
+	header := map[int]interface{}{proto.KeyRequestType: reqType, proto.KeySync: conn.sync}
+	packet, err := proto.EncodePacket(header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(conn.timeout)
+	conn.conn.SetDeadline(deadline)
+	if _, err := conn.conn.Write(packet); err != nil {
+		return nil, err
+	}
+	return conn.readResponse(conn.sync)
+}
+
+// readResponse reads the response to the request with the sync.
+func (conn *Conn) readResponse(sync uint64) (map[interface{}]interface{}, error) {
+	for {
+		sizeData, err := conn.reader.Peek(5)
+		if err != nil {
+			return nil, err
+		}
+		if sizeData[0] != 0xce {
+			return nil, errors.New("Invalid size of the response.")
+		}
+		size := binary.BigEndian.Uint32(sizeData[1:])
+		if size > maxResponseSize {
+			return nil, errors.New("The response is too large.")
+		}
+		conn.reader.Discard(5)
+		data := make([]byte, size)
+		if _, err := io.ReadFull(conn.reader, data); err != nil {
+			return nil, err
+		}
+
+		values, err := proto.DecodeAll(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 || len(values) > 2 {
+			return nil, errors.New("Invalid response.")
+		}
+		header, ok := values[0].(map[interface{}]interface{})
+		if !ok {
+			return nil, errors.New("Invalid header of the response.")
+		}
+		// Skip the responses to the timed out requests.
+		if respSync, _ := toUint64(header[int64(proto.KeySync)]); respSync != sync {
+			continue
+		}
+
+		body := map[interface{}]interface{}{}
+		if len(values) == 2 {
+			if body, ok = values[1].(map[interface{}]interface{}); !ok {
+				return nil, errors.New("Invalid body of the response.")
+			}
+		}
+
+		code, _ := toUint64(header[int64(proto.KeyRequestType)])
+		if code == proto.TypeOK {
+			return body, nil
+		}
+		msg, _ := body[int64(proto.KeyError24)].(string)
+		return nil, &Error{Code: int(code &^ proto.TypeError), Msg: msg}
+	}
+}
+
+// toUint64 converts a decoded integer to uint64.
+func toUint64(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case int64:
+		if v < 0 {
+			return 0, false
+		}
+		return uint64(v), true
+	case uint64:
+		return v, true
+	}
+	return 0, false
+}
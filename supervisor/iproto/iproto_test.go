@@ -0,0 +1,104 @@
+package iproto_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tarantool/tvisor/supervisor/iproto"
+	"github.com/tarantool/tvisor/supervisor/iproto/iprototest"
+)
+
+// startServer starts a fake Tarantool with the "admin" user.
+func startServer(t *testing.T) *iprototest.Server {
+	srv, err := iprototest.NewServer(func(req *iprototest.Request) ([]interface{}, error) {
+		switch {
+		case req.Type == iprototest.TypeCall && req.Function == "echo":
+			return req.Args, nil
+		case req.Type == iprototest.TypeEval && req.User == "admin":
+			return []interface{}{req.Expr}, nil
+		case req.Type == iprototest.TypeEval:
+			return nil, &iproto.Error{Code: iprototest.ErrAccessDenied,
+				Msg: "Execute access to universe '' is denied for user 'guest'"}
+		}
+		return nil, errors.New("Procedure '" + req.Function + "' is not defined")
+	}, map[string]string{"admin": "secret"})
+	if err != nil {
+		t.Fatalf(`Can't start the server. Error: "%v"`, err)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestPing checks the greeting and the "PING" request.
+func TestPing(t *testing.T) {
+	assert := assert.New(t)
+	srv := startServer(t)
+
+	conn, err := iproto.Dial("tcp", srv.Addr, time.Second)
+	if !assert.Nilf(err, `Can't connect. Error: "%v"`, err) {
+		return
+	}
+	defer conn.Close()
+	assert.Equal(iprototest.Version, conn.Greeting().Version)
+	assert.NotEmpty(conn.Greeting().Salt)
+	assert.Nil(conn.Ping())
+	assert.Nil(conn.Ping())
+}
+
+// TestCallEval checks the authentication, "CALL" and "EVAL" requests.
+func TestCallEval(t *testing.T) {
+	assert := assert.New(t)
+	srv := startServer(t)
+
+	conn, err := iproto.Dial("tcp", srv.Addr, time.Second)
+	if !assert.Nilf(err, `Can't connect. Error: "%v"`, err) {
+		return
+	}
+	defer conn.Close()
+
+	data, err := conn.Call("echo", 1, "two", []interface{}{true})
+	assert.Nil(err)
+	assert.Equal([]interface{}{int64(1), "two", []interface{}{true}}, data)
+
+	_, err = conn.Call("unknown")
+	if assert.NotNil(err) {
+		assert.Equal("Procedure 'unknown' is not defined", err.(*iproto.Error).Msg)
+	}
+
+	// The guest can't evaluate expressions.
+	_, err = conn.Eval("return box.info")
+	if assert.NotNil(err) {
+		assert.Equal(iprototest.ErrAccessDenied, err.(*iproto.Error).Code)
+	}
+
+	assert.NotNil(conn.Auth("admin", "wrong"), "The wrong password is accepted.")
+	assert.NotNil(conn.Auth("unknown", "secret"), "The unknown user is accepted.")
+	assert.Nil(conn.Auth("admin", "secret"))
+	data, err = conn.Eval("return box.info")
+	assert.Nil(err)
+	assert.Equal([]interface{}{"return box.info"}, data)
+}
+
+// TestDialInvalidGreeting checks the connection to a non-Tarantool server.
+func TestDialInvalidGreeting(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(`Can't listen. Error: "%v"`, err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(bytes.Repeat([]byte("x"), 128))
+	}()
+
+	_, err = iproto.Dial("tcp", listener.Addr().String(), time.Second)
+	assert.NotNil(t, err, "The invalid greeting is accepted.")
+}
@@ -0,0 +1,233 @@
+/*
+Iprototest provides a fake Tarantool server for testing iproto clients.
+*/
+package iprototest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/tarantool/tvisor/supervisor/iproto"
+	"github.com/tarantool/tvisor/supervisor/iproto/internal/proto"
+)
+
+// Version is the version of Tarantool reported in the greeting.
+const Version = "Tarantool 2.8.2 (Binary) 5a5ef21c-9ee8-4e4d-b4d3-ddeaf5e87b53"
+
+// Types of the requests passed to the Handler.
+const (
+	TypeCall = proto.TypeCall
+	TypeEval = proto.TypeEval
+)
+
+// Error codes returned by the server.
+const (
+	ErrUnknownRequestType = 48
+	ErrAccessDenied       = 42
+	ErrPasswordMismatch   = 47
+)
+
+// Request describes a request received by the server.
+type Request struct {
+	// Type is the request type (TypeCall or TypeEval).
+	Type int64
+	// User is the authenticated user ("guest" by default).
+	User string
+	// Function is the name of the called function ("CALL" only).
+	Function string
+	// Expr is the evaluated expression ("EVAL" only).
+	Expr string
+	// Args are the arguments of the function or the expression.
+	Args []interface{}
+}
+
+// Handler handles "CALL" and "EVAL" requests. It returns the data of
+// the response or an error. An error of the *iproto.Error type is sent
+// with its code.
+type Handler func(req *Request) ([]interface{}, error)
+
+// Server is a fake Tarantool server listening on a local port.
+type Server struct {
+	// Addr is the address of the server ("host:port").
+	Addr string
+	// listener is the listener of the server.
+	listener net.Listener
+	// handler handles the requests.
+	handler Handler
+	// users is a map of a user name to the password.
+	users map[string]string
+	// wg is used to wait for the connections to be closed.
+	wg sync.WaitGroup
+	// mutex is used to work with the connections.
+	mutex sync.Mutex
+	// conns is a set of the accepted connections.
+	conns map[net.Conn]struct{}
+}
+
+// NewServer starts a fake Tarantool server.
+// users - a map of a user name to the password (nil - "guest" only).
+func NewServer(handler Handler, users map[string]string) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := &Server{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		handler:  handler,
+		users:    users,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	srv.wg.Add(1)
+	go srv.serve()
+	return srv, nil
+}
+
+// Close stops the server and closes all the connections.
+func (srv *Server) Close() {
+	srv.listener.Close()
+	srv.mutex.Lock()
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	srv.mutex.Unlock()
+	srv.wg.Wait()
+}
+
+// serve accepts the connections.
+func (srv *Server) serve() {
+	defer srv.wg.Done()
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+		srv.mutex.Lock()
+		srv.conns[conn] = struct{}{}
+		srv.mutex.Unlock()
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			srv.serveConn(conn)
+			srv.mutex.Lock()
+			delete(srv.conns, conn)
+			srv.mutex.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// greeting returns the greeting with the salt.
+func greeting(salt []byte) []byte {
+	data := bytes.Repeat([]byte(" "), proto.GreetingSize)
+	copy(data, Version)
+	copy(data[proto.GreetingSize/2:], base64.StdEncoding.EncodeToString(salt))
+	data[proto.GreetingSize/2-1] = '\n'
+	data[proto.GreetingSize-1] = '\n'
+	return data
+}
+
+// serveConn serves the connection.
+func (srv *Server) serveConn(conn net.Conn) {
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	if _, err := conn.Write(greeting(salt)); err != nil {
+		return
+	}
+
+	user := "guest"
+	for {
+		sizeData := make([]byte, 5)
+		if _, err := io.ReadFull(conn, sizeData); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint32(sizeData[1:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		values, err := proto.DecodeAll(data)
+		if err != nil || len(values) != 2 {
+			return
+		}
+		header, _ := values[0].(map[interface{}]interface{})
+		body, _ := values[1].(map[interface{}]interface{})
+		reqType, _ := header[int64(proto.KeyRequestType)].(int64)
+
+		var respData []interface{}
+		switch reqType {
+		case proto.TypePing:
+		case proto.TypeAuth:
+			var authUser string
+			if authUser, err = srv.auth(body, salt); err == nil {
+				user = authUser
+			}
+		case TypeCall, TypeEval:
+			req := Request{Type: reqType, User: user}
+			req.Function, _ = body[int64(proto.KeyFunctionName)].(string)
+			req.Expr, _ = body[int64(proto.KeyExpr)].(string)
+			req.Args, _ = body[int64(proto.KeyTuple)].([]interface{})
+			respData, err = srv.handler(&req)
+		default:
+			err = &iproto.Error{Code: ErrUnknownRequestType, Msg: "Unknown request type"}
+		}
+
+		if err := writeResponse(conn, header[int64(proto.KeySync)], respData, err); err != nil {
+			return
+		}
+	}
+}
+
+// auth checks the authentication request. Returns the user name.
+func (srv *Server) auth(body map[interface{}]interface{}, salt []byte) (string, error) {
+	user, _ := body[int64(proto.KeyUserName)].(string)
+	password, ok := srv.users[user]
+	if !ok {
+		return "", &iproto.Error{Code: ErrAccessDenied,
+			Msg: "User '" + user + "' is not found"}
+	}
+	tuple, _ := body[int64(proto.KeyTuple)].([]interface{})
+	if len(tuple) != 2 {
+		return "", &iproto.Error{Code: ErrPasswordMismatch, Msg: "Invalid auth request"}
+	}
+	scramble, _ := tuple[1].([]byte)
+	expected, err := proto.Scramble(base64.StdEncoding.EncodeToString(salt), password)
+	if err != nil || !bytes.Equal(scramble, expected) {
+		return "", &iproto.Error{Code: ErrPasswordMismatch,
+			Msg: "Incorrect password supplied for user '" + user + "'"}
+	}
+	return user, nil
+}
+
+// writeResponse writes the response to the request with the sync.
+func writeResponse(conn net.Conn, sync interface{}, data []interface{}, respErr error) error {
+	code := proto.TypeOK
+	body := map[int]interface{}{}
+	if respErr != nil {
+		var iprotoErr *iproto.Error
+		if errors.As(respErr, &iprotoErr) {
+			code = proto.TypeError | iprotoErr.Code
+		} else {
+			code = proto.TypeError
+		}
+		body[proto.KeyError24] = respErr.Error()
+		if iprotoErr != nil {
+			body[proto.KeyError24] = iprotoErr.Msg
+		}
+	} else if data != nil {
+		body[proto.KeyData] = data
+	}
+
+	header := map[int]interface{}{proto.KeyRequestType: code, proto.KeySync: sync}
+	packet, err := proto.EncodePacket(header, body)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(packet)
+	return err
+}
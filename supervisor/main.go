@@ -3,103 +3,284 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"flag"
-	"io/ioutil"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/tarantool/tvisor/supervisor/api/supervisorhttp"
 	"github.com/tarantool/tvisor/supervisor/core"
 )
 
+// checkCfgCommand is the command that checks the config without
+// starting the service.
+const checkCfgCommand = "check-config"
+
+// Environment variables that set the arguments of the service
+// (the flags override them).
+const (
+	// cfgPathEnv - the path to the config ("-cfg").
+	cfgPathEnv = core.CfgEnvPrefix + "CFG"
+	// addrEnv - the address of the HTTP server ("-addr").
+	addrEnv = core.CfgEnvPrefix + "ADDR"
+)
+
 // args describes the parsed arguments.
 type args struct {
 	//CfgPath - path to Tvisor config.
 	CfgPath string
 	// Addr - address to start the HTTP server(host:port).
 	Addr string
+	// CheckCfg - only check the config (see checkCfgCommand).
+	CheckCfg bool
+	// PrintCfg - only print the effective config.
+	PrintCfg bool
+	// CfgFlags - a map of the path of the config field to the
+	// value set by the flag.
+	CfgFlags map[string]string
+}
+
+// cfgFlag is a flag that overrides the config field.
+type cfgFlag struct {
+	// field is the config field.
+	field core.CfgField
+	// flags is a map of the path of the field to the value.
+	flags map[string]string
 }
 
-// parseArgs returns the parsed arguments.
+// String returns the value of the flag.
+func (f *cfgFlag) String() string {
+	if f.flags == nil {
+		return ""
+	}
+	return f.flags[f.field.Path]
+}
+
+// Set sets the value of the flag.
+func (f *cfgFlag) Set(value string) error {
+	f.flags[f.field.Path] = value
+	return nil
+}
+
+// IsBoolFlag allows to set the boolean fields without a value ("-log.compress").
+func (f *cfgFlag) IsBoolFlag() bool {
+	return f.field.IsBool
+}
+
+// getenv returns the value of the environment variable
+// or the default value if the variable isn't set.
+func getenv(key string, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// parseArgs returns the parsed arguments. The command can be
+// passed as the first argument or after the flags.
 func parseArgs() *args {
-	var args args
-	flag.StringVar(&args.CfgPath, "cfg", "cfg.json",
-		"path to Tvisor config.")
-	flag.StringVar(&args.Addr, "addr", "127.0.0.1:8080",
-		"address to start the HTTP server(host:port).")
-	flag.Parse()
+	args := args{CfgFlags: make(map[string]string)}
+	flag.StringVar(&args.CfgPath, "cfg", getenv(cfgPathEnv, "cfg.json"),
+		"path to Tvisor config (empty to use only the defaults, "+
+			"the environment variables and the flags). Env: "+cfgPathEnv+".")
+	flag.StringVar(&args.Addr, "addr", getenv(addrEnv, "127.0.0.1:8080"),
+		"address to start the HTTP server(host:port). Env: "+addrEnv+".")
+	flag.BoolVar(&args.PrintCfg, "print-config", false,
+		"print the effective config with the sources of the values and exit.")
+	for _, field := range core.CfgFields() {
+		flag.Var(&cfgFlag{field: field, flags: args.CfgFlags}, field.Path,
+			fmt.Sprintf("overrides the %q config field. Env: %s.", field.Path, field.Env))
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [%s] [flags]\n", os.Args[0], checkCfgCommand)
+		flag.PrintDefaults()
+	}
+
+	arguments := os.Args[1:]
+	if len(arguments) != 0 && arguments[0] == checkCfgCommand {
+		args.CheckCfg = true
+		arguments = arguments[1:]
+	}
+	flag.CommandLine.Parse(arguments)
+	if flag.NArg() == 1 && flag.Arg(0) == checkCfgCommand {
+		args.CheckCfg = true
+	} else if flag.NArg() != 0 {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Unknown command: %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
 
 	return &args
 }
 
-// parseCfg parses the Tvisor JSON config.
-func parseCfg(path string) (*core.Cfg, error) {
-	// Check is the file exists.
-	if _, err := os.Stat(path); err != nil {
-		return nil, err
+// defaultCfg returns the default Tvisor config.
+func defaultCfg() core.Cfg {
+	return core.Cfg{
+		InstancesDir: "/etc/tarantool/tvisor/instances",
+		TermTimeout:  core.Duration(30 * time.Second),
+		Restart: core.RestartCfg{
+			BackoffInitial: core.Duration(time.Second),
+			BackoffMax:     core.Duration(time.Minute),
+			MaxRestarts:    5,
+			Window:         core.Duration(5 * time.Minute),
+		},
+		ExitHistorySize: 10,
+		Log: core.LogCfg{
+			MaxSize:    100,
+			MaxBackups: 10,
+			Compress:   true,
+		},
+		EventHistorySize:  1000,
+		ReconcileInterval: core.Duration(30 * time.Second),
+		AllowedSignals:    []string{"SIGHUP", "SIGUSR1", "SIGUSR2"},
 	}
+}
 
-	// Open the file.
-	jsonFile, err := os.Open(path)
+// loadCfg loads the Tvisor config from the layers: defaults, the config
+// file, the "TVISOR_*" environment variables and the flags. The format
+// of the config file (JSON, YAML or TOML) is detected by its extension.
+// The config isn't validated.
+func loadCfg(args *args) (*core.Cfg, core.CfgSources, error) {
+	var env []string
+	for _, kv := range os.Environ() {
+		// These variables aren't config fields.
+		if !strings.HasPrefix(kv, cfgPathEnv+"=") && !strings.HasPrefix(kv, addrEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return core.LoadCfg(&core.CfgLayers{
+		Defaults: defaultCfg(),
+		Path:     args.CfgPath,
+		Env:      env,
+		Flags:    args.CfgFlags,
+	})
+}
+
+// parseCfg loads (see loadCfg) and validates the Tvisor config.
+func parseCfg(args *args) (*core.Cfg, error) {
+	cfg, _, err := loadCfg(args)
 	if err != nil {
 		return nil, err
 	}
-	defer jsonFile.Close()
 
-	// Set defaults.
-	cfg := core.Cfg{
-		InstancesDir: "/etc/tarantool/tvisor/instances",
-		TermTimeout:  30,
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// checkCfg checks the config and exits with a non-zero
+// exit code if it is invalid.
+func checkCfg(args *args) {
+	if _, err := parseCfg(args); err != nil {
+		fmt.Fprintf(os.Stderr, "The config %q is invalid: %v\n", args.CfgPath, err)
+		os.Exit(1)
 	}
+	fmt.Printf("The config %q is valid.\n", args.CfgPath)
+}
 
-	// Read and parse config.
-	byteValue, err := ioutil.ReadAll(jsonFile)
+// printCfg prints the effective config: the value of each field
+// and its source. Exits with a non-zero exit code if the config
+// is invalid.
+func printCfg(args *args) {
+	cfg, sources, err := loadCfg(args)
 	if err != nil {
-		return nil, err
+		fmt.Fprintf(os.Stderr, "Can't load the config: %v\n", err)
+		os.Exit(1)
 	}
-	if err = json.Unmarshal(byteValue, &cfg); err != nil {
-		return nil, err
+	values, err := cfgValues(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't print the config: %v\n", err)
+		os.Exit(1)
 	}
 
-	// In the config, the time is indicated in seconds. Convert the value.
-	cfg.TermTimeout = cfg.TermTimeout * time.Second
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FIELD\tVALUE\tSOURCE")
+	for _, field := range core.CfgFields() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", field.Path, values[field.Path],
+			sources[field.Path])
+	}
+	w.Flush()
 
-	return &cfg, nil
+	if err = cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "The config is invalid: %v\n", err)
+		os.Exit(1)
+	}
 }
 
-// handleZombie handles zombie child processes, if any, in a non-blocking style.
+// cfgValues returns a map of the path of the config field to its value in JSON.
+func cfgValues(cfg *core.Cfg) (map[string]string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, field := range core.CfgFields() {
+		value := json.RawMessage(data)
+		for _, name := range strings.Split(field.Path, ".") {
+			var obj map[string]json.RawMessage
+			if err = json.Unmarshal(value, &obj); err != nil {
+				return nil, err
+			}
+			value = obj[name]
+		}
+		values[field.Path] = string(value)
+	}
+	return values, nil
+}
+
+// handleZombie handles the terminated Instances, if any, in a non-blocking
+// style. Only the processes of the Instances are reaped, so the other child
+// processes (the "exec" probes, the pre-stop hooks) are left to their owners.
 func handleZombie(sv *core.Supervisor) {
-	// Get PID of the terminated Instance.
-	pid, err := syscall.Wait4(-1, nil, syscall.WNOHANG, nil)
+	for _, exitStatus := range sv.ReapInstances() {
+		log.Printf("The process has been terminated. PID: %v. Exit code: %v. "+
+			"Signal: %v. Core dumped: %v.", exitStatus.Pid, exitStatus.ExitCode,
+			exitStatus.Signal, exitStatus.CoreDumped)
+		restartInstance(sv, exitStatus.Pid, exitStatus)
+	}
+}
 
-	// If the error "no child processes" (syscall.Errno(10)) or pid == 0 -
-	// this means that the process was stopped as planned, and the wait ()
-	// function has already been called.
-	if pid == 0 || (err != nil && errors.Is(err, syscall.Errno(10))) {
-		return
-	} else if err != nil {
-		log.Printf(`Can't get the PID of a process by using "Wait4". Error: "%v"`,
-			err)
-		return
+// handleAdopted checks whether the adopted Instances have been terminated.
+// Adopted Instances aren't child processes of the service, so "SIGCHLD"
+// isn't received on their termination.
+func handleAdopted(sv *core.Supervisor) {
+	for _, pid := range sv.DeadAdoptedInstances() {
+		restartInstance(sv, pid, nil)
 	}
+}
 
-	// If the Restartable Instance flag is true, try to restart the Instance.
-	if id, err := sv.RestartAfterTermInstance(pid); err != nil {
+// restartInstance restarts the terminated Instance by the PID of its process.
+// status - the exit status of the process (nil if it is unknown).
+func restartInstance(sv *core.Supervisor, pid int, status *core.ExitStatus) {
+	// If the restart policy of the Instance requires a restart,
+	// schedule the restart of the Instance.
+	if id, delay, err := sv.RestartAfterTermInstance(pid, status); err != nil {
 		log.Printf(`Can't restart the Instance. Old PID : %v. ID: %v. Error: "%v"`,
 			pid, id, err)
 	} else {
-		log.Printf("The Instance has been restarted. ID: %v", id)
+		log.Printf("The Instance restart has been scheduled. ID: %v. Delay: %v",
+			id, delay)
 	}
 }
 
 // terminateGracefully terminates the service correctly.
-func terminateGracefully(sv *core.Supervisor, srv *http.Server,
-	timeout time.Duration, done chan bool) {
+func terminateGracefully(sv *core.Supervisor, srv *http.Server, done chan bool) {
+	// We will use the instance completion timeout multiplied by 5
+	// as the service termination timeout. The timeout is taken from
+	// the current config, because it could be reloaded.
+	timeout := sv.TermTimeout() * 5
 	// First of all, shut down the HTTP server to avoid reciving a new request.
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	if err := srv.Shutdown(ctx); err != nil {
@@ -113,10 +294,17 @@ func terminateGracefully(sv *core.Supervisor, srv *http.Server,
 	done <- true
 }
 
+// reloadCfg reloads the config of the Supervisor.
+func reloadCfg(sv *core.Supervisor) {
+	if _, err := sv.ReloadCfg(); err != nil {
+		log.Printf(`The config hasn't been reloaded. Error: "%v"`, err)
+	}
+}
+
 // startSignalHandling adds "SIGTERM" and "SIGINT" signal handling to
-// terminate gracefully and "SIGCHLD" to restart Instances.
-func startSignalHandling(sv *core.Supervisor, srv *http.Server,
-	termTimeout time.Duration, done chan bool) {
+// terminate gracefully, "SIGCHLD" to restart Instances and "SIGHUP"
+// to reload the config.
+func startSignalHandling(sv *core.Supervisor, srv *http.Server, done chan bool) {
 	// The handling of the "SIGTERM" and "SIGINT" signals
 	// will be used to terminate gracefully.
 	sigTermChan := make(chan os.Signal, 1)
@@ -126,14 +314,23 @@ func startSignalHandling(sv *core.Supervisor, srv *http.Server,
 	sigChldChan := make(chan os.Signal, 1)
 	signal.Notify(sigChldChan, syscall.SIGCHLD)
 
+	// The handling of the "SIGHUP" will be used to reload the config.
+	sigHupChan := make(chan os.Signal, 1)
+	signal.Notify(sigHupChan, syscall.SIGHUP)
+
 	// Start signals handling.
 	go func() {
 		for {
 			select {
 			case _ = <-sigTermChan:
-				terminateGracefully(sv, srv, termTimeout, done)
+				terminateGracefully(sv, srv, done)
 			case _ = <-sigChldChan:
 				handleZombie(sv)
+			case _ = <-sigHupChan:
+				// The reload can take a while (the changed
+				// Instances are restarted), so let's not
+				// block the handling of other signals.
+				go reloadCfg(sv)
 			case <-time.After(20 * time.Second):
 				// According to
 				// https://www.gnu.org/software/libc/manual/html_node/Merged-Signals.html,
@@ -143,38 +340,79 @@ func startSignalHandling(sv *core.Supervisor, srv *http.Server,
 				// some kind of guard for this case. Let's just check periodically
 				// if we have some zombie process pending processing.
 				handleZombie(sv)
+				handleAdopted(sv)
 			}
 		}
 	}()
 }
 
 func main() {
+	// The executable is run by itself to set up the Instance process.
+	core.RunChildSetupIfRequested()
+
 	// Get config.
 	args := parseArgs()
-	cfg, err := parseCfg(args.CfgPath)
+	if args.PrintCfg {
+		printCfg(args)
+		return
+	}
+	if args.CheckCfg {
+		checkCfg(args)
+		return
+	}
+	cfg, err := parseCfg(args)
 	if err != nil {
 		log.Fatalf("Can't parse a config: %v", err)
 	}
 
-	// Create Supervisor.
 	sv := core.NewSupervisor(cfg)
+	sv.SetCfgLoader(func() (*core.Cfg, error) {
+		return parseCfg(args)
+	})
+
+	// The listener is bound before the privileges are dropped, so the
+	// privileged ports can be used. The privileges are dropped before
+	// any Instance is adopted or started, so the Instances without
+	// the "user" are run by the unprivileged user too.
+	ln, err := net.Listen("tcp", args.Addr)
+	if err != nil {
+		log.Fatalf(`Can't start HTTP server. Error: "%v"`, err)
+	}
+	if dropped, err := sv.DropPrivileges(); err != nil {
+		log.Printf(`The privileges haven't been dropped. Error: "%v"`, err)
+	} else if dropped {
+		log.Printf("The privileges have been dropped: uid %v, gid %v.",
+			os.Getuid(), os.Getgid())
+	}
+
+	// Re-adopt the Instances started before the service restart.
+	if restored, err := sv.RestoreState(); err != nil {
+		log.Printf(`Can't restore the state. Error: "%v"`, err)
+	} else if restored != 0 {
+		log.Printf("%v Instances have been restored.", restored)
+	}
+	// Start the declared Instances that haven't been restored.
+	sv.StartReconciliation()
 
 	// Prepare HTTP server.
 	svHandler := supervisorhttp.NewSupervisorHandler(sv)
 	http.Handle("/instance", svHandler)
+	instancesHandler := supervisorhttp.NewInstancesHandler(sv, svHandler)
+	http.Handle("/instances", instancesHandler)
+	http.Handle("/instances/", instancesHandler)
+	http.Handle("/logs", supervisorhttp.NewLogsHandler(sv))
+	http.Handle("/events", supervisorhttp.NewEventsHandler(sv))
+	http.Handle("/metrics", supervisorhttp.NewMetricsHandler(sv, svHandler))
 	srv := &http.Server{
 		Addr: args.Addr,
 	}
 
-	// We will use the instance completion timeout multiplied
-	// by 5 as the service termination timeout.
-	serviceTermTimeout := cfg.TermTimeout * 5
 	// Start signal processing.
 	done := make(chan bool, 1)
-	startSignalHandling(sv, srv, serviceTermTimeout, done)
+	startSignalHandling(sv, srv, done)
 
 	// Start HTTP server.
-	if err = srv.ListenAndServe(); err != http.ErrServerClosed {
+	if err = srv.Serve(ln); err != http.ErrServerClosed {
 		log.Fatalf("Can't start HTTP server")
 	}
 
@@ -0,0 +1,266 @@
+/*
+Metrics provides a minimal implementation of the Prometheus metric types
+and the text exposition format.
+*/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types.
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// DefaultBuckets are the default histogram buckets (in seconds).
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// Sample describes a value of a metric.
+type Sample struct {
+	// LabelValues - the values of the metric labels
+	// (in the order of the label names).
+	LabelValues []string
+	// Value - the value of the metric.
+	Value float64
+}
+
+// labelValueEscaper escapes label values according to the text format.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatValue formats the value according to the text format.
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// formatLabels formats the label pairs: `{name1="value1",name2="value2"}`.
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + labelValueEscaper.Replace(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// WriteFamily writes the metric family in the text format.
+// name - the name of the metric.
+// help - the description of the metric.
+// metricType - see metric types.
+// labelNames - the names of the metric labels.
+// samples - the values of the metric.
+func WriteFamily(w io.Writer, name string, help string, metricType string,
+	labelNames []string, samples []Sample) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n",
+		name, help, name, metricType); err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", name,
+			formatLabels(labelNames, sample.LabelValues),
+			formatValue(sample.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seriesKey returns the key of the label values.
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+// sortedKeys returns the sorted keys of the series.
+func sortedKeys(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a set of counters partitioned by the label values.
+type CounterVec struct {
+	// name - the name of the metric.
+	name string
+	// help - the description of the metric.
+	help string
+	// labelNames - the names of the metric labels.
+	labelNames []string
+	// mutex is used to work with the series.
+	mutex sync.Mutex
+	// series is a map of the label values key to the sample.
+	series map[string]*Sample
+}
+
+// NewCounterVec creates a CounterVec.
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		series:     make(map[string]*Sample),
+	}
+}
+
+// Add adds the value to the counter with the label values.
+func (vec *CounterVec) Add(value float64, labelValues ...string) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	key := seriesKey(labelValues)
+	sample, ok := vec.series[key]
+	if !ok {
+		sample = &Sample{LabelValues: labelValues}
+		vec.series[key] = sample
+	}
+	sample.Value += value
+}
+
+// Inc increments the counter with the label values.
+func (vec *CounterVec) Inc(labelValues ...string) {
+	vec.Add(1, labelValues...)
+}
+
+// Write writes the counters in the text format.
+func (vec *CounterVec) Write(w io.Writer) error {
+	vec.mutex.Lock()
+	keys := make([]string, 0, len(vec.series))
+	for key := range vec.series {
+		keys = append(keys, key)
+	}
+	samples := make([]Sample, 0, len(keys))
+	for _, key := range sortedKeys(keys) {
+		samples = append(samples, *vec.series[key])
+	}
+	vec.mutex.Unlock()
+	return WriteFamily(w, vec.name, vec.help, TypeCounter, vec.labelNames, samples)
+}
+
+// histogram describes the observations of a histogram series.
+type histogram struct {
+	// labelValues - the values of the metric labels.
+	labelValues []string
+	// counts - the number of observations in each bucket
+	// (not cumulative).
+	counts []uint64
+	// count - the total number of observations.
+	count uint64
+	// sum - the sum of the observations.
+	sum float64
+}
+
+// HistogramVec is a set of histograms partitioned by the label values.
+type HistogramVec struct {
+	// name - the name of the metric.
+	name string
+	// help - the description of the metric.
+	help string
+	// labelNames - the names of the metric labels.
+	labelNames []string
+	// buckets - the upper bounds of the buckets (sorted).
+	buckets []float64
+	// mutex is used to work with the series.
+	mutex sync.Mutex
+	// series is a map of the label values key to the histogram.
+	series map[string]*histogram
+}
+
+// NewHistogramVec creates a HistogramVec.
+// buckets - the upper bounds of the buckets (nil - DefaultBuckets).
+func NewHistogramVec(name string, help string, buckets []float64,
+	labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+	return &HistogramVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		buckets:    sorted,
+		series:     make(map[string]*histogram),
+	}
+}
+
+// Observe adds the observation to the histogram with the label values.
+func (vec *HistogramVec) Observe(value float64, labelValues ...string) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	key := seriesKey(labelValues)
+	hist, ok := vec.series[key]
+	if !ok {
+		hist = &histogram{
+			labelValues: labelValues,
+			counts:      make([]uint64, len(vec.buckets)),
+		}
+		vec.series[key] = hist
+	}
+	// The observation exceeding all the buckets is counted only in "+Inf".
+	if i := sort.SearchFloat64s(vec.buckets, value); i < len(vec.buckets) {
+		hist.counts[i]++
+	}
+	hist.count++
+	hist.sum += value
+}
+
+// Write writes the histograms in the text format.
+func (vec *HistogramVec) Write(w io.Writer) error {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n",
+		vec.name, vec.help, vec.name, TypeHistogram); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(vec.series))
+	for key := range vec.series {
+		keys = append(keys, key)
+	}
+	bucketLabels := append(append([]string{}, vec.labelNames...), "le")
+	for _, key := range sortedKeys(keys) {
+		hist := vec.series[key]
+		var cumulative uint64
+		for i, bound := range append(vec.buckets, math.Inf(1)) {
+			if i < len(hist.counts) {
+				cumulative += hist.counts[i]
+			} else {
+				cumulative = hist.count
+			}
+			labelValues := append(append([]string{}, hist.labelValues...),
+				formatValue(bound))
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", vec.name,
+				formatLabels(bucketLabels, labelValues), cumulative); err != nil {
+				return err
+			}
+		}
+		labels := formatLabels(vec.labelNames, hist.labelValues)
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n",
+			vec.name, labels, formatValue(hist.sum),
+			vec.name, labels, hist.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWriteFamily checks the text format of a metric family.
+func TestWriteFamily(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	err := WriteFamily(&buf, "test_gauge", "Test gauge.", TypeGauge,
+		[]string{"name"}, []Sample{
+			{LabelValues: []string{`a "quoted"\name`}, Value: 1.5},
+			{LabelValues: []string{"inf"}, Value: math.Inf(1)},
+		})
+	assert.Nil(err)
+	assert.Equal(`# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge{name="a \"quoted\"\\name"} 1.5
+test_gauge{name="inf"} +Inf
+`, buf.String())
+}
+
+// TestCounterVec checks the counters.
+func TestCounterVec(t *testing.T) {
+	assert := assert.New(t)
+	vec := NewCounterVec("test_total", "Test counter.", "command", "result")
+	vec.Inc("stop", "ok")
+	vec.Inc("start", "ok")
+	vec.Add(2, "start", "ok")
+
+	var buf bytes.Buffer
+	assert.Nil(vec.Write(&buf))
+	assert.Equal(`# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{command="start",result="ok"} 3
+test_total{command="stop",result="ok"} 1
+`, buf.String())
+}
+
+// TestHistogramVec checks the histograms.
+func TestHistogramVec(t *testing.T) {
+	assert := assert.New(t)
+	vec := NewHistogramVec("test_seconds", "Test histogram.", []float64{1, 0.1}, "name")
+	vec.Observe(0.05, "a")
+	vec.Observe(0.1, "a")
+	vec.Observe(0.5, "a")
+	vec.Observe(5, "a")
+
+	var buf bytes.Buffer
+	assert.Nil(vec.Write(&buf))
+	assert.Equal(`# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{name="a",le="0.1"} 2
+test_seconds_bucket{name="a",le="1"} 3
+test_seconds_bucket{name="a",le="+Inf"} 4
+test_seconds_sum{name="a"} 5.65
+test_seconds_count{name="a"} 4
+`, buf.String())
+}
@@ -27,11 +27,29 @@ def handler(signum, frame):
         sys.exit(0)
 
 
+def fork_child():
+    """Fork a child process (a helper of the instance)"""
+    mode = os.getenv('INSTFORK')
+    if not mode or os.fork() != 0:
+        return
+    if mode.lower() == 'ignore':
+        signal.signal(signal.SIGINT, signal.SIG_IGN)
+        signal.signal(signal.SIGTERM, signal.SIG_IGN)
+    while True:
+        time.sleep(1)
+
+
 def main():
     # Set a signal handler
     signal.signal(signal.SIGTERM, handler)
     signal.signal(signal.SIGINT, handler)
 
+    fork_child()
+
+    # Write something to the output streams to test the output capturing.
+    print('The instance has been started.', flush=True)
+    print('An error message.', file=sys.stderr, flush=True)
+
     while True:
         time.sleep(1)
 